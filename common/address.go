@@ -2,6 +2,7 @@ package common
 
 import (
 	"encoding/hex"
+	"fmt"
 	"seth/crypto/sha3"
 )
 
@@ -20,6 +21,12 @@ func BytesToAddress(b []byte) Address {
 	return a
 }
 
+// HexToAddress returns Address with byte values of s,the prefix 0x is optional
+func HexToAddress(s string) Address {
+	b, _ := hex.DecodeString(trimHexPrefix(s))
+	return BytesToAddress(b)
+}
+
 // SetBytes Sets the address to the value of b. If b is larger than len(a) it will panic
 func (a *Address) SetBytes(b []byte) {
 	if len(b) > len(a) {
@@ -49,3 +56,29 @@ func (a Address) Hex() string {
 	}
 	return "0x" + string(result)
 }
+
+// MarshalText returns the hex representation of a.
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(a.Hex()), nil
+}
+
+// UnmarshalText parses an address in hex syntax.
+func (a *Address) UnmarshalText(input []byte) error {
+	raw := trimHexPrefix(string(input))
+	if len(raw) != AddressLength*2 {
+		return fmt.Errorf("invalid address length %d of %s", len(raw), input)
+	}
+	b, err := hex.DecodeString(raw)
+	if err != nil {
+		return err
+	}
+	a.SetBytes(b)
+	return nil
+}
+
+func trimHexPrefix(s string) string {
+	if len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		return s[2:]
+	}
+	return s
+}
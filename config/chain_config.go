@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"math/big"
+)
+
+var (
+	// MainnetChainConfig chain config of main net
+	MainnetChainConfig = &ChainConfig{
+		ChainID:     big.NewInt(1),
+		EIP155Block: big.NewInt(0),
+		SecureTrie:  true,
+		PoW:         new(PoWConfig),
+	}
+	// TestnetChainConfig chain config of test net
+	TestnetChainConfig = &ChainConfig{
+		ChainID:     big.NewInt(0),
+		EIP155Block: big.NewInt(0),
+		SecureTrie:  true,
+		PoW:         new(PoWConfig),
+	}
+	// DevelopernetChainConfig chain config of developer net
+	DevelopernetChainConfig = &ChainConfig{
+		ChainID:     big.NewInt(-1),
+		EIP155Block: big.NewInt(0),
+		SecureTrie:  true,
+		PoW:         new(PoWConfig),
+	}
+)
+
+// ChainConfig is the core config which determines the blockchain settings,it
+// is stored in database with the genesis block
+type ChainConfig struct {
+	ChainID *big.Int `json:"chainId"` // chain id of transaction signer
+
+	EIP155Block *big.Int `json:"eip155Block,omitempty"` // replay protected transactions are required since this block
+
+	SecureTrie bool `json:"secureTrie,omitempty"` // hash the keys of state trie,it determines the state root
+
+	// consensus engine parameters
+	PoW *PoWConfig `json:"pow,omitempty"`
+	PoA *PoAConfig `json:"poa,omitempty"`
+	BFT *BFTConfig `json:"bft,omitempty"`
+}
+
+// PoWConfig is the consensus engine config of proof-of-work
+type PoWConfig struct{}
+
+// String implements the fmt.Stringer interface
+func (c *PoWConfig) String() string {
+	return "pow"
+}
+
+// PoAConfig is the consensus engine config of proof-of-authority
+type PoAConfig struct {
+	Period uint64 `json:"period"` // number of seconds between blocks to enforce
+	Epoch  uint64 `json:"epoch"`  // epoch length to reset votes and checkpoint
+}
+
+// String implements the fmt.Stringer interface
+func (c *PoAConfig) String() string {
+	return "poa"
+}
+
+// BFTConfig is the consensus engine config of byzantine fault tolerance
+type BFTConfig struct {
+	Period       uint64 `json:"period"`       // minimum number of seconds between blocks
+	RoundTimeout uint64 `json:"roundTimeout"` // milliseconds of the first round timeout,it grows with the round
+}
+
+// String implements the fmt.Stringer interface
+func (c *BFTConfig) String() string {
+	return "bft"
+}
+
+// String implements the fmt.Stringer interface
+func (c *ChainConfig) String() string {
+	var engine interface{}
+	switch {
+	case c.PoW != nil:
+		engine = c.PoW
+	case c.PoA != nil:
+		engine = c.PoA
+	case c.BFT != nil:
+		engine = c.BFT
+	default:
+		engine = "unknown"
+	}
+	return fmt.Sprintf("{ChainID: %v EIP155: %v Engine: %v}", c.ChainID, c.EIP155Block, engine)
+}
+
+// IsEIP155 returns whether num is either equal to the EIP155 fork block or greater
+func (c *ChainConfig) IsEIP155(num *big.Int) bool {
+	return isForked(c.EIP155Block, num)
+}
+
+// CheckCompatible checks whether the stored config is compatible with newcfg
+// when the chain is at height,the chain id can not be changed and the fork
+// blocks can only be rescheduled if they are not reached yet
+func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
+	head := new(big.Int).SetUint64(height)
+	if !configNumEqual(c.ChainID, newcfg.ChainID) {
+		return &ConfigCompatError{What: "chain id", Stored: c.ChainID, New: newcfg.ChainID}
+	}
+	if isForkIncompatible(c.EIP155Block, newcfg.EIP155Block, head) {
+		return &ConfigCompatError{What: "EIP155 fork block", Stored: c.EIP155Block, New: newcfg.EIP155Block}
+	}
+	if c.SecureTrie != newcfg.SecureTrie {
+		return &ConfigCompatError{What: "secure trie", Stored: boolNum(c.SecureTrie), New: boolNum(newcfg.SecureTrie)}
+	}
+	return nil
+}
+
+// ConfigCompatError is raised if the stored chain config is incompatible with
+// the new one
+type ConfigCompatError struct {
+	What   string
+	Stored *big.Int
+	New    *big.Int
+}
+
+func (err *ConfigCompatError) Error() string {
+	return fmt.Sprintf("mismatching %s in database (have %v, want %v)", err.What, err.Stored, err.New)
+}
+
+// isForkIncompatible returns true if a fork scheduled at s1 cannot be
+// rescheduled to block s2 because head is already past the fork
+func isForkIncompatible(s1, s2, head *big.Int) bool {
+	return (isForked(s1, head) || isForked(s2, head)) && !configNumEqual(s1, s2)
+}
+
+// isForked returns whether a fork scheduled at block s is active at the given head block
+func isForked(s, head *big.Int) bool {
+	if s == nil || head == nil {
+		return false
+	}
+	return s.Cmp(head) <= 0
+}
+
+// boolNum returns 1 for true and 0 for false,so that the flag can be reported
+// by ConfigCompatError
+func boolNum(b bool) *big.Int {
+	if b {
+		return big.NewInt(1)
+	}
+	return big.NewInt(0)
+}
+
+func configNumEqual(x, y *big.Int) bool {
+	if x == nil {
+		return y == nil
+	}
+	if y == nil {
+		return x == nil
+	}
+	return x.Cmp(y) == 0
+}
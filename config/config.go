@@ -10,8 +10,15 @@ import (
 	"github.com/naoina/toml"
 )
 
-// SethConfigFile seth config file name
-const SethConfigFile = "seth.conf"
+const (
+	// SethConfigFile seth config file name
+	SethConfigFile = "seth.conf"
+
+	// GCModeArchive gc mode to keep the state of all blocks
+	GCModeArchive = "archive"
+	// GCModeFull gc mode to keep only the state of recent blocks
+	GCModeFull = "full"
+)
 
 // Config var for config
 var Config _Config
@@ -21,6 +28,12 @@ type _Config struct {
 	Name    string   `toml:"name"`
 	DataDir string   `toml:"datadir"`
 	ChainID *big.Int `toml:"chainid"`
+
+	TrieCleanCache int `toml:"triecleancache"` // number of clean trie nodes cached in memory
+	TrieDirtyCache int `toml:"triedirtycache"` // megabytes of dirty trie nodes kept in memory before flushed
+
+	GCMode      string `toml:"gcmode"`      // archive or full
+	StateRetain uint64 `toml:"stateretain"` // number of recent states kept in full gc mode
 }
 
 func init() {
@@ -0,0 +1,572 @@
+package bft
+
+import (
+	"errors"
+	"math/big"
+	"seth/common"
+	"seth/config"
+	"seth/consensus"
+	"seth/core/state"
+	"seth/core/types"
+	"seth/crypto"
+	"seth/log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRoundTimeout = 3000 // milliseconds of the first round timeout if not configured
+
+	// allowedFutureBlockTime max time from current time allowed for blocks,before they're considered future blocks
+	allowedFutureBlockTime = 15 * time.Second
+)
+
+var (
+	defaultDifficulty = big.NewInt(1) // difficulty of all blocks,the committed block is final
+)
+
+var (
+	// ErrInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+	// ErrInvalidDifficulty is returned if the difficulty of a block is not 1
+	ErrInvalidDifficulty = errors.New("invalid difficulty")
+	// ErrUnauthorized is returned if a header is sealed by a non validator
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrInsufficientCommits is returned if a header is not committed by more
+	// than two thirds of the validators
+	ErrInsufficientCommits = errors.New("insufficient commit signatures")
+	// ErrGasUsedExceedsLimit is returned if the gas used of block is larger
+	// than the gas limit
+	ErrGasUsedExceedsLimit = errors.New("gas used exceeds gas limit")
+
+	errInvalidNonce      = errors.New("non-zero nonce")
+	errInvalidMixDigest  = errors.New("non-zero mix digest")
+	errExtraValidators   = errors.New("non-genesis block contains validator list")
+	errInvalidCommit     = errors.New("invalid commit signature")
+	errNoValidators      = errors.New("no validators in genesis block")
+	errNoValidator       = errors.New("no authorized validator")
+	errNotStarted        = errors.New("bft consensus not started")
+	errAlreadyStarted    = errors.New("bft consensus already started")
+	errUnknownValidators = errors.New("unknown validator set")
+	errUnknownCommit     = errors.New("unknown commit of parent")
+)
+
+// Handler is the node side of the running consensus
+type Handler interface {
+	// Broadcast sends the message to the other validators
+	Broadcast(msg *Message)
+	// Commit is called with the committed block which no Seal is waiting for,
+	// it should be inserted to the chain
+	Commit(block *types.Block)
+}
+
+// BFT is the byzantine fault tolerant consensus engine,the validators taken
+// from genesis block decide each block in rounds of propose,prevote and
+// precommit,a block is final once more than two thirds of them committed it
+type BFT struct {
+	config     *config.BFTConfig
+	validators *validatorSet // validator set of genesis block,cached on first use
+
+	address common.Address     // address of the validator key
+	key     *crypto.PrivateKey // validator key to sign seals and messages
+	lock    sync.RWMutex       // protects the validator set and key fields
+
+	chain   consensus.ChainReader
+	handler Handler
+	core    *core
+	head    *types.Header                  // last committed header
+	commit  *blockCommit                   // commit of the last committed block
+	queue   []*Message                     // local messages to be handled by core
+	waiters map[uint64][]chan *types.Block // Seal calls waiting for the committed block by height
+	calls   []func()                       // handler callbacks invoked after coreMu is released
+	coreMu  sync.Mutex                     // protects the running consensus fields
+}
+
+// blockCommit is the round and precommit signatures in which a block is
+// committed
+type blockCommit struct {
+	hash      common.Hash
+	round     uint64
+	committed [][]byte
+}
+
+// New creates a BFT engine
+func New(config *config.BFTConfig) *BFT {
+	conf := *config
+	if conf.RoundTimeout == 0 {
+		conf.RoundTimeout = defaultRoundTimeout
+	}
+	return &BFT{
+		config: &conf,
+	}
+}
+
+// validatorSet returns the validators in the extra data of genesis block
+func (b *BFT) validatorSet(chain consensus.ChainReader) (*validatorSet, error) {
+	b.lock.RLock()
+	validators := b.validators
+	b.lock.RUnlock()
+	if validators != nil {
+		return validators, nil
+	}
+	genesis := chain.GetHeaderByNumber(0)
+	if genesis == nil {
+		return nil, errUnknownValidators
+	}
+	extra, err := decodeExtra(genesis)
+	if err != nil {
+		return nil, err
+	}
+	if len(extra.Validators) == 0 {
+		return nil, errNoValidators
+	}
+	validators = newValidatorSet(extra.Validators)
+
+	b.lock.Lock()
+	b.validators = validators
+	b.lock.Unlock()
+	return validators, nil
+}
+
+// Author implements consensus.Engine,returning the validator who proposed the
+// block
+func (b *BFT) Author(header *types.Header) (common.Address, error) {
+	extra, err := decodeExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	hash, err := sealHash(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverAddress(hash, extra.Seal)
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules
+func (b *BFT) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	if header.Number == nil || header.Number.Sign() <= 0 {
+		return consensus.ErrInvalidNumber
+	}
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	if err := b.verifyHeader(header, parent); err != nil {
+		return err
+	}
+	if seal {
+		return b.VerifySeal(chain, header)
+	}
+	return nil
+}
+
+// verifyHeader checks the fields of header against its parent
+func (b *BFT) verifyHeader(header, parent *types.Header) error {
+	if header.Number.Cmp(new(big.Int).Add(parent.Number, common.Big1)) != 0 {
+		return consensus.ErrInvalidNumber
+	}
+	if header.Time == nil || header.Time.Cmp(big.NewInt(time.Now().Add(allowedFutureBlockTime).Unix())) > 0 {
+		return consensus.ErrFutureBlock
+	}
+	if parent.Time.Uint64()+b.config.Period > header.Time.Uint64() {
+		return ErrInvalidTimestamp
+	}
+	if header.Difficulty == nil || header.Difficulty.Cmp(defaultDifficulty) != 0 {
+		return ErrInvalidDifficulty
+	}
+	if header.Nonce != (types.BlockNonce{}) {
+		return errInvalidNonce
+	}
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	extra, err := decodeExtra(header)
+	if err != nil {
+		return err
+	}
+	if len(extra.Validators) != 0 {
+		return errExtraValidators
+	}
+	if header.GasUsed > header.GasLimit {
+		return ErrGasUsedExceedsLimit
+	}
+	return nil
+}
+
+// VerifySeal checks the block is proposed by a validator and its parent is
+// committed by more than two thirds of the validators
+func (b *BFT) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	if header.Number == nil || header.Number.Sign() <= 0 {
+		return consensus.ErrInvalidNumber
+	}
+	validators, err := b.validatorSet(chain)
+	if err != nil {
+		return err
+	}
+	if err := verifyProposer(validators, header); err != nil {
+		return err
+	}
+	return verifyCommit(validators, header)
+}
+
+// verifyCommit checks the commit signatures of parent in header
+func verifyCommit(validators *validatorSet, header *types.Header) error {
+	extra, err := decodeExtra(header)
+	if err != nil {
+		return err
+	}
+	// the genesis block is not committed by the validators
+	if header.Number.Uint64() == 1 {
+		if extra.Round != 0 || len(extra.Committed) != 0 {
+			return errInvalidCommit
+		}
+		return nil
+	}
+	commitHash := messageHash(MsgPrecommit, header.Number.Uint64()-1, extra.Round, -1, header.ParentHash)
+	committers := make(map[common.Address]bool)
+	for _, sig := range extra.Committed {
+		addr, err := recoverAddress(commitHash, sig)
+		if err != nil {
+			return errInvalidCommit
+		}
+		if !validators.contains(addr) {
+			return ErrUnauthorized
+		}
+		committers[addr] = true
+	}
+	if len(committers) < validators.quorum() {
+		return ErrInsufficientCommits
+	}
+	return nil
+}
+
+// verifyProposer checks the proposer seal of header is signed by a validator
+func verifyProposer(validators *validatorSet, header *types.Header) error {
+	extra, err := decodeExtra(header)
+	if err != nil {
+		return err
+	}
+	hash, err := sealHash(header)
+	if err != nil {
+		return err
+	}
+	proposer, err := recoverAddress(hash, extra.Seal)
+	if err != nil {
+		return ErrUnauthorized
+	}
+	if !validators.contains(proposer) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// Prepare sets the difficulty,extra data with the commit of parent and
+// timestamp of header
+func (b *BFT) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	header.Nonce = types.BlockNonce{}
+	header.MixDigest = common.Hash{}
+	header.Difficulty = new(big.Int).Set(defaultDifficulty)
+
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	commit := new(bftExtra)
+	if parent.Number.Sign() > 0 {
+		b.coreMu.Lock()
+		if b.commit == nil || b.commit.hash != header.ParentHash {
+			b.coreMu.Unlock()
+			return errUnknownCommit
+		}
+		commit.Round, commit.Committed = b.commit.round, b.commit.committed
+		b.coreMu.Unlock()
+	}
+	vanity := make([]byte, extraVanity)
+	copy(vanity, header.Extra)
+	extra, err := encodeExtra(vanity, commit)
+	if err != nil {
+		return err
+	}
+	header.Extra = extra
+
+	header.Time = new(big.Int).Add(parent.Time, new(big.Int).SetUint64(b.config.Period))
+	if now := big.NewInt(time.Now().Unix()); header.Time.Cmp(now) < 0 {
+		header.Time = now
+	}
+	return nil
+}
+
+// Finalize sets the roots and bloom of header and assembles the block
+func (b *BFT) Finalize(chain consensus.ChainReader, header *types.Header, statedb *state.Statedb, txs []*types.Transaction,
+	receipts []*types.Receipt) (*types.Block, error) {
+	header.Root = statedb.IntermediateRoot()
+	header.ReceiptHash = types.DeriveSha(types.Receipts(receipts))
+	header.Bloom = types.CreateBloom(receipts)
+	return types.NewBlock(header, txs), nil
+}
+
+// Authorize injects the validator key into the consensus engine
+func (b *BFT) Authorize(key *crypto.PrivateKey) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.key = key
+	b.address = crypto.PubkeyToAddress(&key.PublicKey)
+}
+
+// Signer returns the address of the local validator,the fees of its blocks
+// are credited to it
+func (b *BFT) Signer() common.Address {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.address
+}
+
+// Start starts the consensus of the block after head,the messages of the
+// local validator are sent by handler
+func (b *BFT) Start(chain consensus.ChainReader, head *types.Header, handler Handler) error {
+	validators, err := b.validatorSet(chain)
+	if err != nil {
+		return err
+	}
+	b.lock.RLock()
+	address, key := b.address, b.key
+	b.lock.RUnlock()
+	if key == nil {
+		return errNoValidator
+	}
+	if !validators.contains(address) {
+		return ErrUnauthorized
+	}
+
+	b.coreMu.Lock()
+	defer b.unlockCore()
+	if b.core != nil {
+		return errAlreadyStarted
+	}
+	b.chain, b.handler, b.head = chain, handler, head
+	b.waiters = make(map[uint64][]chan *types.Block)
+	b.core = newCore(&engineBackend{b}, address, validators, time.Duration(b.config.RoundTimeout)*time.Millisecond)
+	b.run(func(c *core) { c.startHeight(head.Number.Uint64() + 1) })
+	return nil
+}
+
+// Stop stops the running consensus,the waiting Seal calls return nil
+func (b *BFT) Stop() {
+	b.coreMu.Lock()
+	defer b.coreMu.Unlock()
+
+	for _, waiters := range b.waiters {
+		for _, ch := range waiters {
+			close(ch)
+		}
+	}
+	b.core, b.chain, b.handler, b.head = nil, nil, nil, nil
+	b.queue, b.waiters = nil, nil
+}
+
+// HandleMessage verifies and handles a consensus message from other validators
+func (b *BFT) HandleMessage(msg *Message) error {
+	b.coreMu.Lock()
+	defer b.unlockCore()
+
+	if b.core == nil {
+		return errNotStarted
+	}
+	if err := msg.verify(b.core.validators); err != nil {
+		return err
+	}
+	var err error
+	b.run(func(c *core) { err = c.handleMessage(msg) })
+	return err
+}
+
+// unlockCore releases coreMu and then invokes the handler callbacks collected
+// while it was held,the handler may call back into the engine
+func (b *BFT) unlockCore() {
+	calls := b.calls
+	b.calls = nil
+	b.coreMu.Unlock()
+
+	for _, call := range calls {
+		call()
+	}
+}
+
+// run calls fn with the running core and then handles the local messages,it
+// is called with coreMu held
+func (b *BFT) run(fn func(c *core)) {
+	c := b.core
+	fn(c)
+	for len(b.queue) > 0 && b.core == c {
+		msg := b.queue[0]
+		b.queue = b.queue[1:]
+		c.handleMessage(msg)
+	}
+}
+
+// Seal signs the block as the proposer and waits until a block of the same
+// height is committed,which is the sealed block or the one proposed by the
+// other validator. It returns nil if stop is closed or the consensus stopped.
+func (b *BFT) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	header := block.Header.Clone()
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil, consensus.ErrInvalidNumber
+	}
+	b.lock.RLock()
+	key := b.key
+	b.lock.RUnlock()
+	if key == nil {
+		return nil, errNoValidator
+	}
+
+	extra, err := decodeExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := sealHash(header)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := key.Sign(hash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	extra.Seal = sig[:]
+	if header, err = withExtra(header, extra); err != nil {
+		return nil, err
+	}
+	candidate := block.WithSeal(header)
+
+	// the candidate is proposed no earlier than its timestamp
+	delay := time.Unix(header.Time.Int64(), 0).Sub(time.Now())
+	select {
+	case <-stop:
+		return nil, nil
+	case <-time.After(delay):
+	}
+
+	ch := make(chan *types.Block, 1)
+	b.coreMu.Lock()
+	if b.core == nil {
+		b.coreMu.Unlock()
+		return nil, errNotStarted
+	}
+	if number < b.core.height {
+		b.coreMu.Unlock()
+		return nil, consensus.ErrInvalidNumber
+	}
+	b.waiters[number] = append(b.waiters[number], ch)
+	b.run(func(c *core) { c.setCandidate(candidate) })
+	b.unlockCore()
+
+	select {
+	case <-stop:
+		b.coreMu.Lock()
+		if b.waiters != nil {
+			waiters := b.waiters[number]
+			for i, waiter := range waiters {
+				if waiter == ch {
+					b.waiters[number] = append(waiters[:i], waiters[i+1:]...)
+					break
+				}
+			}
+		}
+		b.coreMu.Unlock()
+		return nil, nil
+	case committed := <-ch:
+		return committed, nil
+	}
+}
+
+// CalcDifficulty returns 1,the committed blocks are final and all of them
+// have the same difficulty
+func (b *BFT) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(defaultDifficulty)
+}
+
+// engineBackend is the backend of the core running in the engine,its methods
+// are called with coreMu held so the handler is called back after unlock
+type engineBackend struct {
+	bft *BFT
+}
+
+func (e *engineBackend) Sign(hash common.Hash) ([]byte, error) {
+	e.bft.lock.RLock()
+	key := e.bft.key
+	e.bft.lock.RUnlock()
+	if key == nil {
+		return nil, errNoValidator
+	}
+	sig, err := key.Sign(hash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return sig[:], nil
+}
+
+func (e *engineBackend) Broadcast(msg *Message) {
+	handler := e.bft.handler
+	e.bft.queue = append(e.bft.queue, msg)
+	e.bft.calls = append(e.bft.calls, func() { handler.Broadcast(msg) })
+}
+
+func (e *engineBackend) Verify(block *types.Block) error {
+	// the parent may be committed but not inserted yet
+	parent := e.bft.chain.GetHeader(block.Header.ParentHash, block.NumberU64()-1)
+	if parent == nil && e.bft.head != nil && e.bft.head.Hash() == block.Header.ParentHash {
+		parent = e.bft.head
+	}
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	if err := e.bft.verifyHeader(block.Header, parent); err != nil {
+		return err
+	}
+	if err := verifyCommit(e.bft.core.validators, block.Header); err != nil {
+		return err
+	}
+	if block.Header.TxHash != types.DeriveSha(types.Transactions(block.Transactions())) {
+		return errInvalidMessage
+	}
+	return verifyProposer(e.bft.core.validators, block.Header)
+}
+
+func (e *engineBackend) Commit(block *types.Block, round uint64, committed [][]byte) {
+	number := block.NumberU64()
+	e.bft.head = block.Header
+	e.bft.commit = &blockCommit{hash: block.Hash(), round: round, committed: committed}
+	log.Info("Committed bft block,number %d hash %s", number, block.Hash().Hex())
+
+	waiters := e.bft.waiters[number]
+	for height, stale := range e.bft.waiters {
+		if height < number {
+			for _, ch := range stale {
+				close(ch)
+			}
+			delete(e.bft.waiters, height)
+		}
+	}
+	delete(e.bft.waiters, number)
+	if len(waiters) == 0 {
+		handler := e.bft.handler
+		e.bft.calls = append(e.bft.calls, func() { handler.Commit(block) })
+		return
+	}
+	for _, ch := range waiters {
+		ch <- block
+	}
+}
+
+func (e *engineBackend) ScheduleTimeout(t timeout, d time.Duration) {
+	b, c := e.bft, e.bft.core
+	time.AfterFunc(d, func() {
+		b.coreMu.Lock()
+		defer b.unlockCore()
+		if b.core == c {
+			b.run(func(c *core) { c.handleTimeout(t) })
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package bft
+
+import (
+	"math/big"
+	"seth/config"
+	"seth/core/types"
+	"testing"
+	"time"
+)
+
+// testHandler connects the engines of test,messages are delivered
+// synchronously since the engine calls back with its lock released
+type testHandler struct {
+	engines   []*BFT
+	self      *BFT
+	committed chan *types.Block
+}
+
+func (h *testHandler) Broadcast(msg *Message) {
+	for _, engine := range h.engines {
+		if engine != h.self {
+			cpy := *msg
+			engine.HandleMessage(&cpy)
+		}
+	}
+}
+
+func (h *testHandler) Commit(block *types.Block) {
+	h.committed <- block
+}
+
+func Test_BFT_Seal(t *testing.T) {
+	validators := newTestValidators(4)
+	genesis := newTestGenesis(validators)
+	chain := testChain{genesis.Hash(): genesis}
+
+	engines := make([]*BFT, len(validators))
+	for i, validator := range validators {
+		engines[i] = New(&config.BFTConfig{RoundTimeout: 200})
+		engines[i].Authorize(validator.key)
+	}
+	committed := make(chan *types.Block, 4*len(engines))
+	for _, engine := range engines {
+		handler := &testHandler{engines: engines, self: engine, committed: committed}
+		if err := engine.Start(chain, genesis, handler); err != nil {
+			t.Fatalf("start engine failed: %v", err)
+		}
+		defer engine.Stop()
+	}
+
+	parent := genesis
+	for number := int64(1); number <= 2; number++ {
+		for _, engine := range engines {
+			header := &types.Header{ParentHash: parent.Hash(), Number: big.NewInt(number), GasLimit: 5000}
+			if err := engine.Prepare(chain, header); err != nil {
+				t.Fatalf("prepare block %d failed: %v", number, err)
+			}
+			go func(engine *BFT, block *types.Block) {
+				if sealed, err := engine.Seal(chain, block, nil); err == nil && sealed != nil {
+					committed <- sealed
+				}
+			}(engine, types.NewBlock(header, nil))
+		}
+
+		var block *types.Block
+		for range engines {
+			select {
+			case b := <-committed:
+				if block != nil && b.Hash() != block.Hash() {
+					t.Fatalf("committed conflicting blocks %d", number)
+				}
+				block = b
+			case <-time.After(10 * time.Second):
+				t.Fatalf("block %d not committed", number)
+			}
+		}
+		if err := engines[0].VerifyHeader(chain, block.Header, true); err != nil {
+			t.Fatalf("committed block %d verification failed: %v", number, err)
+		}
+		chain[block.Hash()] = block.Header
+		parent = block.Header
+	}
+
+	// the block 2 carries the commit of block 1
+	extra, _ := decodeExtra(parent)
+	extra.Committed = extra.Committed[:1]
+	header, _ := withExtra(parent, extra)
+	if err := verifyCommit(engines[0].validators, header); err != ErrInsufficientCommits {
+		t.Fatalf("expected error %v,got %v", ErrInsufficientCommits, err)
+	}
+
+	outsider := newTestValidators(1)[0]
+	block := newTestBlock(outsider, genesis, nil, 0)
+	if err := engines[0].VerifySeal(chain, block.Header); err != ErrUnauthorized {
+		t.Fatalf("expected error %v,got %v", ErrUnauthorized, err)
+	}
+}
@@ -0,0 +1,376 @@
+package bft
+
+import (
+	"errors"
+	"seth/common"
+	"seth/core/types"
+	"seth/log"
+	"time"
+)
+
+// step is the step of a round
+type step uint8
+
+const (
+	stepPropose step = iota
+	stepPrevote
+	stepPrecommit
+)
+
+// maxFutureMessages is the number of next height messages buffered for each
+// validator
+const maxFutureMessages = 64
+
+var (
+	errOldMessage  = errors.New("message of old height")
+	errNotProposer = errors.New("proposal from non proposer")
+	// errFutureMessage is returned if the message is beyond the next height
+	// or the buffer of its sender is full
+	errFutureMessage = errors.New("message of future height")
+)
+
+// timeout is a round change timeout scheduled by the core
+type timeout struct {
+	Height uint64
+	Round  uint64
+	Step   step
+}
+
+// backend is the environment of the core,the consensus engine and the test
+// network implement it
+type backend interface {
+	// Sign signs the hash with the key of local validator
+	Sign(hash common.Hash) ([]byte, error)
+	// Broadcast sends the message to all validators including the local one
+	Broadcast(msg *Message)
+	// Verify checks the proposed block is valid on the committed chain
+	Verify(block *types.Block) error
+	// Commit is called with the block decided at the height,the round and the
+	// precommit signatures are aggregated into the header of next block
+	Commit(block *types.Block, round uint64, committed [][]byte)
+	// ScheduleTimeout calls back HandleTimeout of core after d
+	ScheduleTimeout(t timeout, d time.Duration)
+}
+
+// voteSet is the prevotes or precommits of a round,only the first vote of a
+// validator is counted
+type voteSet struct {
+	votes  map[common.Address]*Message
+	counts map[common.Hash]int
+}
+
+func newVoteSet() *voteSet {
+	return &voteSet{votes: make(map[common.Address]*Message), counts: make(map[common.Hash]int)}
+}
+
+func (s *voteSet) add(msg *Message) bool {
+	if _, ok := s.votes[msg.from]; ok {
+		return false
+	}
+	s.votes[msg.from] = msg
+	s.counts[msg.BlockHash]++
+	return true
+}
+
+// core is the deterministic state machine of Tendermint consensus for one
+// validator,it is not thread safe and must be driven by a single goroutine
+type core struct {
+	backend      backend
+	address      common.Address
+	validators   *validatorSet
+	roundTimeout time.Duration
+
+	height uint64
+	round  uint64
+	step   step
+
+	lockedBlock *types.Block
+	lockedRound int64
+	validBlock  *types.Block
+	validRound  int64
+
+	candidate *types.Block // block to propose when the local validator is the proposer
+	proposed  bool         // whether the proposal of current round is sent
+
+	proposals  map[uint64]*Message                // proposal of the proposer in each round
+	blocks     map[common.Hash]*types.Block       // valid proposed blocks by proposal hash
+	prevotes   map[uint64]*voteSet                // prevotes in each round
+	precommits map[uint64]*voteSet                // precommits in each round
+	senders    map[uint64]map[common.Address]bool // validators sent messages in each round
+	polka      map[uint64]bool                    // whether the polka rule of round is executed
+	scheduled  map[timeout]bool                   // scheduled prevote and precommit timeouts
+
+	future []*Message // messages of next height
+}
+
+func newCore(backend backend, address common.Address, validators *validatorSet, roundTimeout time.Duration) *core {
+	return &core{
+		backend:      backend,
+		address:      address,
+		validators:   validators,
+		roundTimeout: roundTimeout,
+	}
+}
+
+// startHeight starts the consensus of height from round 0
+func (c *core) startHeight(height uint64) {
+	c.height = height
+	c.lockedBlock, c.lockedRound = nil, -1
+	c.validBlock, c.validRound = nil, -1
+	c.proposals = make(map[uint64]*Message)
+	c.blocks = make(map[common.Hash]*types.Block)
+	c.prevotes = make(map[uint64]*voteSet)
+	c.precommits = make(map[uint64]*voteSet)
+	c.senders = make(map[uint64]map[common.Address]bool)
+	c.polka = make(map[uint64]bool)
+	c.scheduled = make(map[timeout]bool)
+	c.startRound(0)
+
+	// replay the buffered messages of the height
+	future := c.future
+	c.future = nil
+	for _, msg := range future {
+		if msg.Height >= c.height {
+			c.handleMessage(msg)
+		}
+	}
+}
+
+// startRound starts round at current height
+func (c *core) startRound(round uint64) {
+	log.Debug("Start bft round,height %d round %d", c.height, round)
+	c.round = round
+	c.step = stepPropose
+	c.proposed = false
+	if c.validators.proposer(c.height, round) == c.address {
+		c.propose()
+	}
+	c.backend.ScheduleTimeout(timeout{Height: c.height, Round: round, Step: stepPropose}, c.timeoutDuration(round))
+	c.check()
+}
+
+// timeoutDuration grows with the round so that the validators eventually
+// stay in the same round long enough
+func (c *core) timeoutDuration(round uint64) time.Duration {
+	return c.roundTimeout * time.Duration(round+1)
+}
+
+// setCandidate sets the block to propose at its height
+func (c *core) setCandidate(block *types.Block) {
+	c.candidate = block
+	if c.step == stepPropose && !c.proposed && c.validators.proposer(c.height, c.round) == c.address {
+		c.propose()
+	}
+}
+
+// propose broadcasts the valid block or the candidate as the proposal
+func (c *core) propose() {
+	block, validRound := c.validBlock, c.validRound
+	if block == nil {
+		if c.candidate == nil || c.candidate.NumberU64() != c.height {
+			// wait for the candidate
+			return
+		}
+		block, validRound = c.candidate, -1
+	}
+	c.proposed = true
+	c.broadcast(&Message{Code: MsgProposal, Height: c.height, Round: c.round, ValidRound: validRound, BlockHash: block.Hash(), Block: block})
+}
+
+// broadcast signs and sends the message of the local validator
+func (c *core) broadcast(msg *Message) {
+	sig, err := c.backend.Sign(msg.signHash())
+	if err != nil {
+		log.Error("Failed to sign bft message: %v", err)
+		return
+	}
+	msg.Signature = sig
+	msg.from = c.address
+	c.backend.Broadcast(msg)
+}
+
+func (c *core) vote(code uint8, hash common.Hash) {
+	c.broadcast(&Message{Code: code, Height: c.height, Round: c.round, ValidRound: -1, BlockHash: hash})
+}
+
+// handleMessage handles a verified message
+func (c *core) handleMessage(msg *Message) error {
+	if msg.Height < c.height {
+		return errOldMessage
+	}
+	if msg.Height > c.height {
+		return c.buffer(msg)
+	}
+	switch msg.Code {
+	case MsgProposal:
+		if msg.from != c.validators.proposer(msg.Height, msg.Round) {
+			return errNotProposer
+		}
+		// the blocks of conflicting proposals are kept,the decided one may be
+		// any of them
+		if msg.Block.Hash() == msg.BlockHash && msg.Block.NumberU64() == c.height && c.backend.Verify(msg.Block) == nil {
+			c.blocks[msg.BlockHash] = msg.Block
+		}
+		if _, ok := c.proposals[msg.Round]; !ok {
+			c.proposals[msg.Round] = msg
+		}
+	case MsgPrevote:
+		c.votes(c.prevotes, msg.Round).add(msg)
+	case MsgPrecommit:
+		c.votes(c.precommits, msg.Round).add(msg)
+	default:
+		return errInvalidMessage
+	}
+	if c.senders[msg.Round] == nil {
+		c.senders[msg.Round] = make(map[common.Address]bool)
+	}
+	c.senders[msg.Round][msg.from] = true
+	c.check()
+	return nil
+}
+
+// buffer keeps the message of next height until the height starts,the count
+// of messages from each validator is limited
+func (c *core) buffer(msg *Message) error {
+	if msg.Height > c.height+1 {
+		return errFutureMessage
+	}
+	count := 0
+	for _, buffered := range c.future {
+		if buffered.from == msg.from {
+			count++
+		}
+	}
+	if count >= maxFutureMessages {
+		return errFutureMessage
+	}
+	c.future = append(c.future, msg)
+	return nil
+}
+
+func (c *core) votes(sets map[uint64]*voteSet, round uint64) *voteSet {
+	if sets[round] == nil {
+		sets[round] = newVoteSet()
+	}
+	return sets[round]
+}
+
+// handleTimeout handles the timeout scheduled by the core
+func (c *core) handleTimeout(t timeout) {
+	if t.Height != c.height || t.Round != c.round {
+		return
+	}
+	switch {
+	case t.Step == stepPropose && c.step == stepPropose:
+		c.vote(MsgPrevote, common.Hash{})
+		c.step = stepPrevote
+	case t.Step == stepPrevote && c.step == stepPrevote:
+		c.vote(MsgPrecommit, common.Hash{})
+		c.step = stepPrecommit
+	case t.Step == stepPrecommit:
+		c.startRound(c.round + 1)
+		return
+	}
+	c.check()
+}
+
+// check executes the rules whose conditions are satisfied
+func (c *core) check() {
+	quorum := c.validators.quorum()
+
+	// decide the block with a quorum of precommits in any round
+	for round, set := range c.precommits {
+		for hash, count := range set.counts {
+			if count >= quorum && hash != (common.Hash{}) && c.blocks[hash] != nil {
+				c.commit(round, hash)
+				return
+			}
+		}
+	}
+	// skip to the round in which at least one honest validator is
+	skip := c.round
+	for round, senders := range c.senders {
+		if round > skip && len(senders) > c.validators.faulty() {
+			skip = round
+		}
+	}
+	if skip > c.round {
+		c.startRound(skip)
+		return
+	}
+
+	proposal := c.proposals[c.round]
+	var block *types.Block
+	if proposal != nil {
+		block = c.blocks[proposal.BlockHash]
+	}
+	prevotes := c.votes(c.prevotes, c.round)
+
+	// prevote the proposal unless locked on another block
+	if c.step == stepPropose && proposal != nil {
+		if proposal.ValidRound == -1 {
+			if block != nil && (c.lockedRound == -1 || c.lockedBlock.Hash() == proposal.BlockHash) {
+				c.vote(MsgPrevote, proposal.BlockHash)
+			} else {
+				c.vote(MsgPrevote, common.Hash{})
+			}
+			c.step = stepPrevote
+		} else if proposal.ValidRound < int64(c.round) && c.votes(c.prevotes, uint64(proposal.ValidRound)).counts[proposal.BlockHash] >= quorum {
+			if block != nil && (c.lockedRound <= proposal.ValidRound || c.lockedBlock.Hash() == proposal.BlockHash) {
+				c.vote(MsgPrevote, proposal.BlockHash)
+			} else {
+				c.vote(MsgPrevote, common.Hash{})
+			}
+			c.step = stepPrevote
+		}
+	}
+	if c.step == stepPrevote && len(prevotes.votes) >= quorum {
+		c.schedule(timeout{Height: c.height, Round: c.round, Step: stepPrevote})
+	}
+	// lock on the block with a polka
+	if c.step >= stepPrevote && block != nil && prevotes.counts[proposal.BlockHash] >= quorum && !c.polka[c.round] {
+		c.polka[c.round] = true
+		if c.step == stepPrevote {
+			c.lockedBlock, c.lockedRound = block, int64(c.round)
+			c.vote(MsgPrecommit, proposal.BlockHash)
+			c.step = stepPrecommit
+		}
+		c.validBlock, c.validRound = block, int64(c.round)
+	}
+	if c.step == stepPrevote && prevotes.counts[common.Hash{}] >= quorum {
+		c.vote(MsgPrecommit, common.Hash{})
+		c.step = stepPrecommit
+	}
+	if len(c.votes(c.precommits, c.round).votes) >= quorum {
+		c.schedule(timeout{Height: c.height, Round: c.round, Step: stepPrecommit})
+	}
+}
+
+// schedule schedules the timeout once
+func (c *core) schedule(t timeout) {
+	if c.scheduled[t] {
+		return
+	}
+	c.scheduled[t] = true
+	c.backend.ScheduleTimeout(t, c.timeoutDuration(t.Round))
+}
+
+// commit collects the precommit signatures of the decided block and moves to
+// the next height
+func (c *core) commit(round uint64, hash common.Hash) {
+	set := c.precommits[round]
+	signers := make([]common.Address, 0, len(set.votes))
+	for addr, vote := range set.votes {
+		if vote.BlockHash == hash {
+			signers = append(signers, addr)
+		}
+	}
+	sortAddresses(signers)
+	committed := make([][]byte, len(signers))
+	for i, addr := range signers {
+		committed[i] = set.votes[addr].Signature
+	}
+	log.Debug("Commit bft block,height %d round %d hash %s", c.height, round, hash.Hex())
+	c.backend.Commit(c.blocks[hash], round, committed)
+	c.startHeight(c.height + 1)
+}
@@ -0,0 +1,198 @@
+package bft
+
+import (
+	"seth/common"
+	"seth/config"
+	"seth/core/types"
+	"testing"
+	"time"
+)
+
+// checkChains checks the honest nodes committed the same blocks with valid
+// seals and commit signatures
+func checkChains(t *testing.T, net *testNetwork, height int) {
+	engine := New(&config.BFTConfig{})
+	chain := testChain{net.genesis.Hash(): net.genesis}
+	expected := net.honest()[0].committed
+	for _, node := range net.honest() {
+		for i := 0; i < height; i++ {
+			if node.committed[i].Hash() != expected[i].Hash() {
+				t.Fatalf("node %d committed block %d %s,expected %s", node.index, i+1, node.committed[i].Hash().Hex(), expected[i].Hash().Hex())
+			}
+		}
+	}
+	for _, block := range expected[:height] {
+		if err := engine.VerifyHeader(chain, block.Header, true); err != nil {
+			t.Fatalf("committed block %d verification failed: %v", block.NumberU64(), err)
+		}
+		chain[block.Hash()] = block.Header
+	}
+}
+
+func Test_BFT_Liveness(t *testing.T) {
+	net := newTestNetwork(4)
+	// the proposer of round 0 at height 1 is crashed
+	net.nodes[1].crashed = true
+	if !net.run(4, 100000) {
+		t.Fatalf("honest validators did not commit 4 blocks")
+	}
+	checkChains(t, net, 4)
+
+	// the commit of block 1 is in block 2
+	extra, err := decodeExtra(net.honest()[0].committed[1].Header)
+	if err != nil {
+		t.Fatalf("decode extra failed: %v", err)
+	}
+	if extra.Round == 0 {
+		t.Fatalf("block 1 committed in round 0,expected a later round")
+	}
+}
+
+func Test_BFT_Safety(t *testing.T) {
+	for _, n := range []int{4, 7} {
+		net := newTestNetwork(n)
+		for i := 0; i < net.validators.faulty(); i++ {
+			net.nodes[i*2].byzantine = true
+		}
+		if !net.run(2*n, 1000000) {
+			t.Fatalf("honest validators of %d did not commit %d blocks", n, 2*n)
+		}
+		checkChains(t, net, 2*n)
+	}
+}
+
+func Test_BFT_NoQuorum(t *testing.T) {
+	net := newTestNetwork(4)
+	net.nodes[0].crashed = true
+	net.nodes[1].crashed = true
+	if net.run(1, 10000) {
+		t.Fatalf("block committed without quorum")
+	}
+	for _, node := range net.honest() {
+		if len(node.committed) != 0 {
+			t.Fatalf("node %d committed %d blocks without quorum", node.index, len(node.committed))
+		}
+		if node.core.height != 1 {
+			t.Fatalf("node %d moved to height %d without quorum", node.index, node.core.height)
+		}
+	}
+}
+
+// testBackend records the messages of a single core
+type testBackend struct {
+	validator testValidator
+	sent      []*Message
+}
+
+func (b *testBackend) Sign(hash common.Hash) ([]byte, error) {
+	sig, err := b.validator.key.Sign(hash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return sig[:], nil
+}
+
+func (b *testBackend) Broadcast(msg *Message)                                      { b.sent = append(b.sent, msg) }
+func (b *testBackend) Verify(block *types.Block) error                             { return nil }
+func (b *testBackend) Commit(block *types.Block, round uint64, committed [][]byte) {}
+func (b *testBackend) ScheduleTimeout(t timeout, d time.Duration)                  {}
+
+func (b *testBackend) last() *Message {
+	return b.sent[len(b.sent)-1]
+}
+
+func Test_BFT_Locking(t *testing.T) {
+	validators := newTestValidators(4)
+	genesis := newTestGenesis(validators)
+	set := newValidatorSet([]common.Address{validators[0].addr, validators[1].addr, validators[2].addr, validators[3].addr})
+	backend := &testBackend{validator: validators[0]}
+	c := newCore(backend, validators[0].addr, set, time.Second)
+	c.startHeight(1)
+
+	send := func(from int, code uint8, round uint64, validRound int64, block *types.Block) {
+		msg := &Message{Code: code, Height: 1, Round: round, ValidRound: validRound, Block: block}
+		if block != nil {
+			msg.BlockHash = block.Hash()
+		}
+		if code != MsgProposal {
+			msg.Block = nil
+		}
+		if err := msg.sign(validators[from].key); err != nil {
+			t.Fatalf("sign message failed: %v", err)
+		}
+		if err := c.handleMessage(msg); err != nil {
+			t.Fatalf("handle message failed: %v", err)
+		}
+	}
+	blockA := newTestBlock(validators[1], genesis, nil, 1)
+	blockB := newTestBlock(validators[2], genesis, nil, 2)
+	hashA := blockA.Hash()
+
+	// lock on A with a polka in round 0
+	send(1, MsgProposal, 0, -1, blockA)
+	if msg := backend.last(); msg.Code != MsgPrevote || msg.BlockHash != hashA {
+		t.Fatalf("expected prevote for proposal of round 0")
+	}
+	for i := 1; i < 4; i++ {
+		send(i, MsgPrevote, 0, -1, blockA)
+	}
+	if msg := backend.last(); msg.Code != MsgPrecommit || msg.BlockHash != hashA {
+		t.Fatalf("expected precommit for block with polka")
+	}
+	if c.lockedRound != 0 || c.lockedBlock != blockA {
+		t.Fatalf("locked round %d,expected 0", c.lockedRound)
+	}
+
+	// skip to round 1 and reject the new block B
+	send(2, MsgProposal, 1, -1, blockB)
+	send(3, MsgPrevote, 1, -1, nil)
+	if c.round != 1 {
+		t.Fatalf("round %d,expected 1", c.round)
+	}
+	if msg := backend.last(); msg.Code != MsgPrevote || msg.Round != 1 || msg.BlockHash != (common.Hash{}) {
+		t.Fatalf("expected nil prevote when locked on another block")
+	}
+
+	// skip to round 2 and accept the reproposed A with the polka of round 0
+	send(3, MsgProposal, 2, 0, blockA)
+	send(1, MsgPrevote, 2, -1, nil)
+	if c.round != 2 {
+		t.Fatalf("round %d,expected 2", c.round)
+	}
+	if msg := backend.last(); msg.Code != MsgPrevote || msg.Round != 2 || msg.BlockHash != hashA {
+		t.Fatalf("expected prevote for reproposed locked block")
+	}
+}
+
+func Test_BFT_FutureMessages(t *testing.T) {
+	validators := newTestValidators(4)
+	set := newValidatorSet([]common.Address{validators[0].addr, validators[1].addr, validators[2].addr, validators[3].addr})
+	c := newCore(&testBackend{validator: validators[0]}, validators[0].addr, set, time.Second)
+	c.startHeight(1)
+
+	send := func(from int, height, round uint64) error {
+		msg := &Message{Code: MsgPrevote, Height: height, Round: round, ValidRound: -1}
+		if err := msg.sign(validators[from].key); err != nil {
+			t.Fatalf("sign message failed: %v", err)
+		}
+		return c.handleMessage(msg)
+	}
+	if err := send(1, 3, 0); err != errFutureMessage {
+		t.Fatalf("expected error %v for message beyond next height,got %v", errFutureMessage, err)
+	}
+	for round := uint64(0); round < maxFutureMessages; round++ {
+		if err := send(1, 2, round); err != nil {
+			t.Fatalf("buffer message of round %d failed: %v", round, err)
+		}
+	}
+	if err := send(1, 2, maxFutureMessages); err != errFutureMessage {
+		t.Fatalf("expected error %v when buffer is full,got %v", errFutureMessage, err)
+	}
+	// the buffer of other validators is not affected
+	if err := send(2, 2, 0); err != nil {
+		t.Fatalf("buffer message of other validator failed: %v", err)
+	}
+	if len(c.future) != maxFutureMessages+1 {
+		t.Fatalf("buffered %d messages,expected %d", len(c.future), maxFutureMessages+1)
+	}
+}
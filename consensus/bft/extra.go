@@ -0,0 +1,101 @@
+package bft
+
+import (
+	"bytes"
+	"errors"
+	"seth/common"
+	"seth/core/types"
+	"seth/crypto"
+	"seth/rlp"
+	"sort"
+)
+
+const (
+	extraVanity = 32 // fixed number of extra-data prefix bytes reserved for validator vanity
+)
+
+var (
+	errInvalidExtra = errors.New("invalid bft extra data")
+)
+
+// bftExtra is the consensus data in the extra data of header after the vanity,
+// the commit of a block is aggregated into the header of its child so that the
+// hash of block is decided when it is proposed
+type bftExtra struct {
+	Validators []common.Address // validator set,only in genesis block
+	Round      uint64           // round in which the parent is committed
+	Committed  [][]byte         // precommit signatures of the parent
+	Seal       []byte           // signature of the proposer
+}
+
+// GenesisExtra returns the extra data of genesis block with the validators
+func GenesisExtra(validators []common.Address) []byte {
+	sorted := make([]common.Address, len(validators))
+	copy(sorted, validators)
+	sortAddresses(sorted)
+	extra, _ := encodeExtra(make([]byte, extraVanity), &bftExtra{Validators: sorted})
+	return extra
+}
+
+// encodeExtra returns the vanity followed by the encoded extra
+func encodeExtra(vanity []byte, extra *bftExtra) ([]byte, error) {
+	payload, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, extraVanity, extraVanity+len(payload))
+	copy(data, vanity)
+	return append(data, payload...), nil
+}
+
+// decodeExtra extracts the bft extra from the extra data of header
+func decodeExtra(header *types.Header) (*bftExtra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, errInvalidExtra
+	}
+	extra := new(bftExtra)
+	if err := rlp.DecodeBytes(header.Extra[extraVanity:], extra); err != nil {
+		return nil, errInvalidExtra
+	}
+	return extra, nil
+}
+
+// withExtra returns a copy of header with the bft extra replaced
+func withExtra(header *types.Header, extra *bftExtra) (*types.Header, error) {
+	data, err := encodeExtra(header.Extra[:extraVanity], extra)
+	if err != nil {
+		return nil, err
+	}
+	cpy := header.Clone()
+	cpy.Extra = data
+	return cpy, nil
+}
+
+// sealHash returns the hash signed by the proposer,the seal is not included
+func sealHash(header *types.Header) (common.Hash, error) {
+	extra, err := decodeExtra(header)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	extra.Seal = nil
+	cpy, err := withExtra(header, extra)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return cpy.Hash(), nil
+}
+
+// recoverAddress returns the address which signed the hash
+func recoverAddress(hash common.Hash, sig []byte) (common.Address, error) {
+	pubkey, err := crypto.SigToPub(hash[:], sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(pubkey), nil
+}
+
+func sortAddresses(addrs []common.Address) {
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+}
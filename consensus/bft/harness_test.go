@@ -0,0 +1,275 @@
+package bft
+
+import (
+	"bytes"
+	"math/big"
+	"seth/accounts"
+	"seth/common"
+	"seth/config"
+	"seth/consensus"
+	"seth/core/types"
+	"seth/crypto"
+	"sort"
+	"time"
+)
+
+// testChain is a chain reader on the headers in memory
+type testChain map[common.Hash]*types.Header
+
+func (c testChain) Config() *config.ChainConfig { return config.MainnetChainConfig }
+
+func (c testChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+func (c testChain) GetHeaderByNumber(number uint64) *types.Header {
+	for _, header := range c {
+		if header.Number.Uint64() == number {
+			return header
+		}
+	}
+	return nil
+}
+
+// testValidator is a validator of test
+type testValidator struct {
+	addr common.Address
+	key  *crypto.PrivateKey
+}
+
+// newTestValidators returns n validators in ascending order of address
+func newTestValidators(n int) []testValidator {
+	validators := make([]testValidator, n)
+	for i := range validators {
+		validators[i].addr, validators[i].key = accounts.NewRandomAccount()
+	}
+	sort.Slice(validators, func(i, j int) bool {
+		return bytes.Compare(validators[i].addr[:], validators[j].addr[:]) < 0
+	})
+	return validators
+}
+
+func newTestGenesis(validators []testValidator) *types.Header {
+	addrs := make([]common.Address, len(validators))
+	for i, validator := range validators {
+		addrs[i] = validator.addr
+	}
+	return &types.Header{Number: big.NewInt(0), Time: big.NewInt(0), Difficulty: big.NewInt(1), Extra: GenesisExtra(addrs)}
+}
+
+// newTestBlock returns a block on parent with the commit of parent sealed by
+// the proposer,blocks with different vanity are conflicting proposals
+func newTestBlock(proposer testValidator, parent *types.Header, commit *bftExtra, vanity byte) *types.Block {
+	if commit == nil {
+		commit = new(bftExtra)
+	}
+	extra, _ := encodeExtra([]byte{vanity}, commit)
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		Time:       new(big.Int).Add(parent.Time, common.Big1),
+		Difficulty: big.NewInt(1),
+		Extra:      extra,
+	}
+	hash, _ := sealHash(header)
+	sig, _ := proposer.key.Sign(hash.Bytes())
+	header, _ = withExtra(header, &bftExtra{Round: commit.Round, Committed: commit.Committed, Seal: sig[:]})
+	return types.NewBlock(header, nil)
+}
+
+// testNode is a validator in the test network
+type testNode struct {
+	network   *testNetwork
+	index     int
+	validator testValidator
+	core      *core
+
+	crashed   bool // the crashed node neither sends nor receives messages
+	byzantine bool // the byzantine node sends conflicting messages to half of the network
+
+	committed []*types.Block
+	commit    *bftExtra // commit of the last committed block
+}
+
+func (n *testNode) head() *types.Header {
+	if len(n.committed) == 0 {
+		return n.network.genesis
+	}
+	return n.committed[len(n.committed)-1].Header
+}
+
+func (n *testNode) Sign(hash common.Hash) ([]byte, error) {
+	sig, err := n.validator.key.Sign(hash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return sig[:], nil
+}
+
+func (n *testNode) Broadcast(msg *Message) {
+	n.network.broadcast(n, msg)
+}
+
+func (n *testNode) Verify(block *types.Block) error {
+	if block.Header.ParentHash != n.head().Hash() {
+		return consensus.ErrUnknownAncestor
+	}
+	if err := verifyProposer(n.network.validators, block.Header); err != nil {
+		return err
+	}
+	return verifyCommit(n.network.validators, block.Header)
+}
+
+func (n *testNode) Commit(block *types.Block, round uint64, committed [][]byte) {
+	n.committed = append(n.committed, block)
+	n.commit = &bftExtra{Round: round, Committed: committed}
+}
+
+func (n *testNode) ScheduleTimeout(t timeout, d time.Duration) {
+	n.network.timers = append(n.network.timers, testTimer{at: n.network.now + d, seq: n.network.seq, node: n, timeout: t})
+	n.network.seq++
+}
+
+// feed sets the candidate of the current height like the miner does
+func (n *testNode) feed() {
+	if n.core.candidate == nil || n.core.candidate.NumberU64() != n.core.height {
+		n.core.setCandidate(newTestBlock(n.validator, n.head(), n.commit, byte(n.index)))
+	}
+}
+
+// equivocate returns a message conflicting with msg
+func (n *testNode) equivocate(msg *Message) *Message {
+	conflict := *msg
+	if msg.Code == MsgProposal {
+		conflict.Block = newTestBlock(n.validator, n.head(), n.commit, 0xff)
+		conflict.BlockHash = conflict.Block.Hash()
+	} else {
+		conflict.BlockHash = crypto.RlpHash(msg.BlockHash)
+	}
+	conflict.sign(n.validator.key)
+	return &conflict
+}
+
+type testDelivery struct {
+	to  *testNode
+	msg *Message
+}
+
+type testTimer struct {
+	at      time.Duration
+	seq     int
+	node    *testNode
+	timeout timeout
+}
+
+// testNetwork is a deterministic in-process network of validators,messages
+// are delivered in order and timeouts fire on a logical clock when there is
+// no message in flight
+type testNetwork struct {
+	genesis    *types.Header
+	validators *validatorSet
+	nodes      []*testNode
+
+	queue  []testDelivery
+	timers []testTimer
+	now    time.Duration
+	seq    int
+}
+
+func newTestNetwork(n int) *testNetwork {
+	validators := newTestValidators(n)
+	network := &testNetwork{genesis: newTestGenesis(validators)}
+	addrs := make([]common.Address, n)
+	for i, validator := range validators {
+		addrs[i] = validator.addr
+	}
+	network.validators = newValidatorSet(addrs)
+	for i, validator := range validators {
+		node := &testNode{network: network, index: i, validator: validator}
+		node.core = newCore(node, validator.addr, network.validators, time.Second)
+		network.nodes = append(network.nodes, node)
+	}
+	return network
+}
+
+func (net *testNetwork) broadcast(from *testNode, msg *Message) {
+	var conflict *Message
+	if from.byzantine {
+		conflict = from.equivocate(msg)
+	}
+	for _, node := range net.nodes {
+		if conflict != nil && node.index%2 == 1 && node != from {
+			net.queue = append(net.queue, testDelivery{to: node, msg: conflict})
+		} else {
+			net.queue = append(net.queue, testDelivery{to: node, msg: msg})
+		}
+	}
+}
+
+// honest returns the nodes neither crashed nor byzantine
+func (net *testNetwork) honest() []*testNode {
+	var nodes []*testNode
+	for _, node := range net.nodes {
+		if !node.crashed && !node.byzantine {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// run runs the network until all honest nodes committed height blocks or
+// steps events are handled,it reports whether the height is reached
+func (net *testNetwork) run(height int, steps int) bool {
+	for _, node := range net.nodes {
+		if !node.crashed {
+			node.core.startHeight(1)
+			node.feed()
+		}
+	}
+	for i := 0; i < steps; i++ {
+		reached := true
+		for _, node := range net.honest() {
+			if len(node.committed) < height {
+				reached = false
+			}
+		}
+		if reached {
+			return true
+		}
+
+		if len(net.queue) > 0 {
+			delivery := net.queue[0]
+			net.queue = net.queue[1:]
+			if delivery.to.crashed {
+				continue
+			}
+			msg := *delivery.msg
+			if err := msg.verify(net.validators); err != nil {
+				continue
+			}
+			delivery.to.core.handleMessage(&msg)
+			delivery.to.feed()
+			continue
+		}
+		if len(net.timers) == 0 {
+			return false
+		}
+		sort.Slice(net.timers, func(i, j int) bool {
+			if net.timers[i].at != net.timers[j].at {
+				return net.timers[i].at < net.timers[j].at
+			}
+			return net.timers[i].seq < net.timers[j].seq
+		})
+		timer := net.timers[0]
+		net.timers = net.timers[1:]
+		net.now = timer.at
+		if !timer.node.crashed {
+			timer.node.core.handleTimeout(timer.timeout)
+			timer.node.feed()
+		}
+	}
+	return false
+}
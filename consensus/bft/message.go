@@ -0,0 +1,120 @@
+package bft
+
+import (
+	"errors"
+	"seth/common"
+	"seth/core/types"
+	"seth/crypto"
+)
+
+const (
+	// MsgProposal proposes a block for the round
+	MsgProposal uint8 = iota
+	// MsgPrevote votes for the proposal of the round or nil
+	MsgPrevote
+	// MsgPrecommit commits to the proposal of the round or nil
+	MsgPrecommit
+)
+
+var (
+	errInvalidMessage   = errors.New("invalid bft message")
+	errUnauthorizedPeer = errors.New("message from non validator")
+)
+
+// Message is a consensus message of the validators
+type Message struct {
+	Code       uint8
+	Height     uint64
+	Round      uint64
+	ValidRound int64        // round in which the proposed block got a polka,-1 if none
+	BlockHash  common.Hash  // hash of the proposed block,zero hash for nil vote
+	Block      *types.Block // the proposed block,only in proposal
+	Signature  []byte
+
+	from common.Address
+}
+
+// From returns the validator who signed the message,it is set after the
+// message is verified
+func (m *Message) From() common.Address {
+	return m.from
+}
+
+// signHash returns the hash signed by the validator
+func (m *Message) signHash() common.Hash {
+	return messageHash(m.Code, m.Height, m.Round, m.ValidRound, m.BlockHash)
+}
+
+// messageHash returns the hash of a message,the precommit hash is used to
+// verify the commit signatures of parent in header
+func messageHash(code uint8, height, round uint64, validRound int64, hash common.Hash) common.Hash {
+	return crypto.RlpHash([]interface{}{code, height, round, uint64(validRound + 1), hash})
+}
+
+// sign signs the message with key and sets the sender
+func (m *Message) sign(key *crypto.PrivateKey) error {
+	sig, err := key.Sign(m.signHash().Bytes())
+	if err != nil {
+		return err
+	}
+	m.Signature = sig[:]
+	m.from = crypto.PubkeyToAddress(&key.PublicKey)
+	return nil
+}
+
+// verify recovers the sender of message and checks it is a validator
+func (m *Message) verify(validators *validatorSet) error {
+	from, err := recoverAddress(m.signHash(), m.Signature)
+	if err != nil {
+		return errInvalidMessage
+	}
+	if !validators.contains(from) {
+		return errUnauthorizedPeer
+	}
+	if m.Code == MsgProposal && m.Block == nil {
+		return errInvalidMessage
+	}
+	m.from = from
+	return nil
+}
+
+// validatorSet is the sorted list of validators
+type validatorSet struct {
+	list []common.Address
+}
+
+func newValidatorSet(validators []common.Address) *validatorSet {
+	list := make([]common.Address, len(validators))
+	copy(list, validators)
+	sortAddresses(list)
+	return &validatorSet{list: list}
+}
+
+func (v *validatorSet) size() int {
+	return len(v.list)
+}
+
+func (v *validatorSet) contains(addr common.Address) bool {
+	for _, validator := range v.list {
+		if validator == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// faulty returns the maximum number of faulty validators tolerated
+func (v *validatorSet) faulty() int {
+	return (len(v.list) - 1) / 3
+}
+
+// quorum returns the number of validators more than two thirds
+func (v *validatorSet) quorum() int {
+	return 2*len(v.list)/3 + 1
+}
+
+// proposer returns the proposer of the round at height,the validators
+// propose in turn
+func (v *validatorSet) proposer(height, round uint64) common.Address {
+	return v.list[(height+round)%uint64(len(v.list))]
+}
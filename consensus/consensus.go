@@ -0,0 +1,57 @@
+package consensus
+
+import (
+	"math/big"
+	"seth/common"
+	"seth/config"
+	"seth/core/state"
+	"seth/core/types"
+)
+
+// MinGasLimit minimum the gas limit of block may ever be,whatever the engine
+const MinGasLimit uint64 = 5000
+
+// ChainReader defines a small collection of methods needed to access the local
+// blockchain during header verification
+type ChainReader interface {
+	// Config retrieves the blockchain's chain configuration
+	Config() *config.ChainConfig
+
+	// GetHeader retrieves a block header from the database by hash and number
+	GetHeader(hash common.Hash, number uint64) *types.Header
+
+	// GetHeaderByNumber retrieves a canonical block header from the database by number
+	GetHeaderByNumber(number uint64) *types.Header
+}
+
+// Engine is an algorithm agnostic consensus engine
+type Engine interface {
+	// Author retrieves the address of the account that sealed the block
+	Author(header *types.Header) (common.Address, error)
+
+	// VerifyHeader checks whether a header conforms to the consensus rules of
+	// the engine,the seal is verified too if seal is true
+	VerifyHeader(chain ChainReader, header *types.Header, seal bool) error
+
+	// VerifySeal checks whether the crypto seal on a header is valid according
+	// to the consensus rules of the engine
+	VerifySeal(chain ChainReader, header *types.Header) error
+
+	// Prepare initializes the consensus fields of a block header according to
+	// the rules of the engine
+	Prepare(chain ChainReader, header *types.Header) error
+
+	// Finalize sets the state root,receipts root and bloom of header after the
+	// transactions are applied to statedb,and assembles the block
+	Finalize(chain ChainReader, header *types.Header, statedb *state.Statedb, txs []*types.Transaction,
+		receipts []*types.Receipt) (*types.Block, error)
+
+	// Seal generates a new block for the given input block with the local
+	// miner's seal place on top. It returns nil if stop is closed before the
+	// seal is found.
+	Seal(chain ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error)
+
+	// CalcDifficulty is the difficulty adjustment algorithm. It returns the
+	// difficulty that a new block should have.
+	CalcDifficulty(chain ChainReader, time uint64, parent *types.Header) *big.Int
+}
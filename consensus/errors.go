@@ -0,0 +1,17 @@
+package consensus
+
+import "errors"
+
+var (
+	// ErrUnknownAncestor is returned when validating a block requires an ancestor
+	// that is unknown
+	ErrUnknownAncestor = errors.New("unknown ancestor")
+
+	// ErrFutureBlock is returned when a block's timestamp is in the future
+	// according to the current node
+	ErrFutureBlock = errors.New("block in the future")
+
+	// ErrInvalidNumber is returned if a block's number doesn't equal it's
+	// parent's plus one
+	ErrInvalidNumber = errors.New("invalid block number")
+)
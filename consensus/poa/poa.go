@@ -0,0 +1,535 @@
+package poa
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"math/rand"
+	"seth/common"
+	"seth/config"
+	"seth/consensus"
+	"seth/core/state"
+	"seth/core/types"
+	"seth/crypto"
+	"seth/database"
+	"seth/log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+)
+
+const (
+	checkpointInterval = 1024 // number of blocks after which to save the vote snapshot to the database
+	inmemorySnapshots  = 128  // number of recent vote snapshots to keep in memory
+	inmemorySignatures = 4096 // number of recent block signatures to keep in memory
+
+	wiggleTime = 500 * time.Millisecond // random delay (per signer) to allow concurrent signers
+
+	// allowedFutureBlockTime max time from current time allowed for blocks,before they're considered future blocks
+	allowedFutureBlockTime = 15 * time.Second
+)
+
+var (
+	epochLength = uint64(30000) // default number of blocks after which to checkpoint and reset the pending votes
+
+	extraVanity   = 32                            // fixed number of extra-data prefix bytes reserved for signer vanity
+	extraSeal     = crypto.SignatureSize          // fixed number of extra-data suffix bytes reserved for signer seal
+	nonceAuthVote = bytes.Repeat([]byte{0xff}, 8) // magic nonce number to vote on adding a new signer
+	nonceDropVote = make([]byte, 8)               // magic nonce number to vote on removing a signer
+
+	diffInTurn = big.NewInt(2) // block difficulty for in-turn signatures
+	diffNoTurn = big.NewInt(1) // block difficulty for out-of-turn signatures
+
+	snapshotPrefix = []byte("poa-") // snapshotPrefix + hash -> vote snapshot
+)
+
+var (
+	// ErrInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+	// ErrInvalidDifficulty is returned if the difficulty of a block is not
+	// 2 for in-turn signer or 1 for out-of-turn signer
+	ErrInvalidDifficulty = errors.New("invalid difficulty")
+	// ErrUnauthorized is returned if a header is signed by a non-authorized entity
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRecentlySigned is returned if a header is signed by an authorized entity
+	// that already signed a header recently,thus is temporarily not allowed to
+	// sign again
+	ErrRecentlySigned = errors.New("recently signed")
+	// ErrInvalidCheckpointSigners is returned if a checkpoint block contains an
+	// invalid list of signers
+	ErrInvalidCheckpointSigners = errors.New("invalid signer list on checkpoint block")
+	// ErrGasUsedExceedsLimit is returned if the gas used of block is larger
+	// than the gas limit
+	ErrGasUsedExceedsLimit = errors.New("gas used exceeds gas limit")
+
+	errInvalidCheckpointBeneficiary = errors.New("beneficiary in checkpoint block non-zero")
+	errInvalidVote                  = errors.New("vote nonce not 0x00..0 or 0xff..f")
+	errInvalidCheckpointVote        = errors.New("vote nonce in checkpoint block non-zero")
+	errMissingVanity                = errors.New("extra-data 32 byte vanity prefix missing")
+	errMissingSignature             = errors.New("extra-data 65 byte signature suffix missing")
+	errExtraSigners                 = errors.New("non-checkpoint block contains extra signer list")
+	errInvalidMixDigest             = errors.New("non-zero mix digest")
+	errInvalidVotingChain           = errors.New("invalid voting chain")
+	errWaitTransactions             = errors.New("waiting for transactions")
+	errNoSigner                     = errors.New("no authorized signer")
+)
+
+// sealHash returns the hash of a block prior to it being sealed,the signature
+// suffix of extra data is not included
+func sealHash(header *types.Header) common.Hash {
+	return crypto.RlpHash([]interface{}{
+		header.ParentHash,
+		header.Coinbase,
+		header.Root,
+		header.TxHash,
+		header.ReceiptHash,
+		header.Bloom,
+		header.Difficulty,
+		header.Number,
+		header.GasLimit,
+		header.GasUsed,
+		header.Time,
+		header.Extra[:len(header.Extra)-extraSeal],
+		header.MixDigest,
+		header.Nonce,
+	})
+}
+
+// ecrecover extracts the account address from a signed header
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	if len(header.Extra) < extraSeal {
+		return common.Address{}, errMissingSignature
+	}
+	signature := header.Extra[len(header.Extra)-extraSeal:]
+
+	pubkey, err := crypto.SigToPub(sealHash(header).Bytes(), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	signer := crypto.PubkeyToAddress(pubkey)
+
+	sigcache.Add(hash, signer)
+	return signer, nil
+}
+
+// PoA is the proof-of-authority consensus engine,the blocks are sealed by the
+// authorized signers in turn and the signers are added or removed by voting
+type PoA struct {
+	config *config.PoAConfig
+	db     database.Database // database to store and retrieve snapshot checkpoints
+
+	recents    *lru.ARCCache // snapshots for recent block to speed up reorgs
+	signatures *lru.ARCCache // signatures of recent blocks to speed up mining
+
+	proposals map[common.Address]bool // current list of proposals we are pushing
+
+	signer common.Address     // address of the signing key
+	key    *crypto.PrivateKey // signing key to authorize hashes with
+	lock   sync.RWMutex       // protects the signer fields
+}
+
+// New creates a PoA engine with the initial signers set to the ones provided
+// by the genesis block
+func New(config *config.PoAConfig, db database.Database) *PoA {
+	conf := *config
+	if conf.Epoch == 0 {
+		conf.Epoch = epochLength
+	}
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+
+	return &PoA{
+		config:     &conf,
+		db:         db,
+		recents:    recents,
+		signatures: signatures,
+		proposals:  make(map[common.Address]bool),
+	}
+}
+
+// GenesisExtra returns the extra data of genesis block with the initial signers
+func GenesisExtra(signers []common.Address) []byte {
+	extra := make([]byte, extraVanity, extraVanity+len(signers)*common.AddressLength+extraSeal)
+	for _, signer := range signers {
+		extra = append(extra, signer[:]...)
+	}
+	return append(extra, make([]byte, extraSeal)...)
+}
+
+// Author implements consensus.Engine,returning the account address that
+// signed the header
+func (p *PoA) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, p.signatures)
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules
+func (p *PoA) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	if header.Number == nil || header.Number.Sign() <= 0 {
+		return consensus.ErrInvalidNumber
+	}
+	number := header.Number.Uint64()
+
+	if header.Time == nil || header.Time.Cmp(big.NewInt(time.Now().Add(allowedFutureBlockTime).Unix())) > 0 {
+		return consensus.ErrFutureBlock
+	}
+	checkpoint := (number % p.config.Epoch) == 0
+	if checkpoint && header.Coinbase != (common.Address{}) {
+		return errInvalidCheckpointBeneficiary
+	}
+	if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidVote
+	}
+	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidCheckpointVote
+	}
+	if len(header.Extra) < extraVanity {
+		return errMissingVanity
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		return errMissingSignature
+	}
+	signersBytes := len(header.Extra) - extraVanity - extraSeal
+	if !checkpoint && signersBytes != 0 {
+		return errExtraSigners
+	}
+	if checkpoint && signersBytes%common.AddressLength != 0 {
+		return ErrInvalidCheckpointSigners
+	}
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	if header.Difficulty == nil || (header.Difficulty.Cmp(diffInTurn) != 0 && header.Difficulty.Cmp(diffNoTurn) != 0) {
+		return ErrInvalidDifficulty
+	}
+	if header.GasUsed > header.GasLimit {
+		return ErrGasUsedExceedsLimit
+	}
+
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time.Uint64()+p.config.Period > header.Time.Uint64() {
+		return ErrInvalidTimestamp
+	}
+	// the signers in checkpoint block must match the snapshot
+	snap, err := p.snapshot(chain, number-1, header.ParentHash)
+	if err != nil {
+		return err
+	}
+	if checkpoint {
+		signers := make([]byte, 0, len(snap.Signers)*common.AddressLength)
+		for _, signer := range snap.signers() {
+			signers = append(signers, signer[:]...)
+		}
+		if !bytes.Equal(header.Extra[extraVanity:len(header.Extra)-extraSeal], signers) {
+			return ErrInvalidCheckpointSigners
+		}
+	}
+	if seal {
+		return p.verifySeal(snap, header)
+	}
+	return nil
+}
+
+// snapshot retrieves the authorization snapshot at a given point in time
+func (p *PoA) snapshot(chain consensus.ChainReader, number uint64, hash common.Hash) (*Snapshot, error) {
+	var (
+		headers []*types.Header
+		snap    *Snapshot
+	)
+	for snap == nil {
+		// in-memory snapshot
+		if s, ok := p.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		// on-disk checkpoint snapshot
+		if number%checkpointInterval == 0 && p.db != nil {
+			if s, err := loadSnapshot(p.config, p.signatures, p.db, hash); err == nil {
+				log.Debug("Loaded voting snapshot from disk,number %d hash %s", number, hash.Hex())
+				snap = s
+				break
+			}
+		}
+		// genesis block,create the snapshot from the signers in extra data
+		if number == 0 {
+			genesis := chain.GetHeader(hash, 0)
+			if genesis == nil {
+				return nil, consensus.ErrUnknownAncestor
+			}
+			if len(genesis.Extra) < extraVanity+extraSeal || (len(genesis.Extra)-extraVanity-extraSeal)%common.AddressLength != 0 {
+				return nil, ErrInvalidCheckpointSigners
+			}
+			signers := make([]common.Address, (len(genesis.Extra)-extraVanity-extraSeal)/common.AddressLength)
+			for i := 0; i < len(signers); i++ {
+				copy(signers[i][:], genesis.Extra[extraVanity+i*common.AddressLength:])
+			}
+			snap = newSnapshot(p.config, p.signatures, 0, genesis.Hash(), signers)
+			if err := p.storeSnapshot(snap); err != nil {
+				return nil, err
+			}
+			break
+		}
+		header := chain.GetHeader(hash, number)
+		if header == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+		headers = append(headers, header)
+		number, hash = number-1, header.ParentHash
+	}
+	// apply the headers from the snapshot in ascending order
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	snap, err := snap.apply(headers)
+	if err != nil {
+		return nil, err
+	}
+	p.recents.Add(snap.Hash, snap)
+
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err := p.storeSnapshot(snap); err != nil {
+			return nil, err
+		}
+	}
+	return snap, nil
+}
+
+// storeSnapshot persists the snapshot if the engine has a database
+func (p *PoA) storeSnapshot(snap *Snapshot) error {
+	if p.db == nil {
+		return nil
+	}
+	if err := snap.store(p.db); err != nil {
+		return err
+	}
+	log.Debug("Stored voting snapshot to disk,number %d hash %s", snap.Number, snap.Hash.Hex())
+	return nil
+}
+
+// VerifySeal checks whether the signature contained in the header satisfies
+// the consensus protocol requirements
+func (p *PoA) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	if header.Number == nil || header.Number.Sign() <= 0 {
+		return consensus.ErrInvalidNumber
+	}
+	snap, err := p.snapshot(chain, header.Number.Uint64()-1, header.ParentHash)
+	if err != nil {
+		return err
+	}
+	return p.verifySeal(snap, header)
+}
+
+// verifySeal checks the signer of header is authorized in snap
+func (p *PoA) verifySeal(snap *Snapshot, header *types.Header) error {
+	number := header.Number.Uint64()
+	signer, err := ecrecover(header, p.signatures)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Signers[signer]; !ok {
+		return ErrUnauthorized
+	}
+	for seen, recent := range snap.Recents {
+		if recent == signer {
+			// the signer is among recents,only fail if the current block doesn't shift it out
+			if limit := uint64(len(snap.Signers)/2 + 1); number < limit || seen > number-limit {
+				return ErrRecentlySigned
+			}
+		}
+	}
+	inturn := snap.inturn(number, signer)
+	if inturn && header.Difficulty.Cmp(diffInTurn) != 0 {
+		return ErrInvalidDifficulty
+	}
+	if !inturn && header.Difficulty.Cmp(diffNoTurn) != 0 {
+		return ErrInvalidDifficulty
+	}
+	return nil
+}
+
+// Prepare sets the vote,difficulty,extra data and timestamp of header. The
+// coinbase is the vote target,the fees are credited to the signer instead.
+func (p *PoA) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	header.Coinbase = common.Address{}
+	header.Nonce = types.BlockNonce{}
+
+	number := header.Number.Uint64()
+	snap, err := p.snapshot(chain, number-1, header.ParentHash)
+	if err != nil {
+		return err
+	}
+	p.lock.RLock()
+	if number%p.config.Epoch != 0 {
+		// cast a random vote of the valid proposals
+		addresses := make([]common.Address, 0, len(p.proposals))
+		for address, authorize := range p.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		if len(addresses) > 0 {
+			header.Coinbase = addresses[rand.Intn(len(addresses))]
+			if p.proposals[header.Coinbase] {
+				copy(header.Nonce[:], nonceAuthVote)
+			} else {
+				copy(header.Nonce[:], nonceDropVote)
+			}
+		}
+	}
+	signer := p.signer
+	p.lock.RUnlock()
+
+	header.Difficulty = calcDifficulty(snap, signer)
+
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	header.Extra = header.Extra[:extraVanity]
+	if number%p.config.Epoch == 0 {
+		for _, signer := range snap.signers() {
+			header.Extra = append(header.Extra, signer[:]...)
+		}
+	}
+	header.Extra = append(header.Extra, make([]byte, extraSeal)...)
+	header.MixDigest = common.Hash{}
+
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Time = new(big.Int).Add(parent.Time, new(big.Int).SetUint64(p.config.Period))
+	if now := big.NewInt(time.Now().Unix()); header.Time.Cmp(now) < 0 {
+		header.Time = now
+	}
+	if header.Time.Cmp(parent.Time) <= 0 {
+		// zero period,the chain needs the time to go forward
+		header.Time = new(big.Int).Add(parent.Time, common.Big1)
+	}
+	return nil
+}
+
+// Finalize sets the roots and bloom of header and assembles the block
+func (p *PoA) Finalize(chain consensus.ChainReader, header *types.Header, statedb *state.Statedb, txs []*types.Transaction,
+	receipts []*types.Receipt) (*types.Block, error) {
+	header.Root = statedb.IntermediateRoot()
+	header.ReceiptHash = types.DeriveSha(types.Receipts(receipts))
+	header.Bloom = types.CreateBloom(receipts)
+	return types.NewBlock(header, txs), nil
+}
+
+// Authorize injects a private key into the consensus engine to mint new blocks
+func (p *PoA) Authorize(key *crypto.PrivateKey) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.key = key
+	p.signer = crypto.PubkeyToAddress(&key.PublicKey)
+}
+
+// Signer returns the address of the local signer,the fees of its blocks are
+// credited to it
+func (p *PoA) Signer() common.Address {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.signer
+}
+
+// Propose injects a new authorization proposal that the signer will attempt
+// to push through
+func (p *PoA) Propose(address common.Address, authorize bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.proposals[address] = authorize
+}
+
+// Discard drops a currently running proposal,stopping the signer from casting
+// further votes (either for or against)
+func (p *PoA) Discard(address common.Address) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.proposals, address)
+}
+
+// Seal signs the block with the authorized key after the block time,it
+// returns nil if stop is closed before the block time
+func (p *PoA) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	header := block.Header.Clone()
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil, consensus.ErrInvalidNumber
+	}
+	// sealing empty blocks is pointless if the period is 0
+	if p.config.Period == 0 && len(block.Transactions()) == 0 {
+		return nil, errWaitTransactions
+	}
+	p.lock.RLock()
+	signer, key := p.signer, p.key
+	p.lock.RUnlock()
+	if key == nil {
+		return nil, errNoSigner
+	}
+
+	snap, err := p.snapshot(chain, number-1, header.ParentHash)
+	if err != nil {
+		return nil, err
+	}
+	if _, authorized := snap.Signers[signer]; !authorized {
+		return nil, ErrUnauthorized
+	}
+	for seen, recent := range snap.Recents {
+		if recent == signer {
+			if limit := uint64(len(snap.Signers)/2 + 1); number < limit || seen > number-limit {
+				return nil, ErrRecentlySigned
+			}
+		}
+	}
+	// wait until the block time,the out-of-turn signer waits a random time more
+	delay := time.Unix(header.Time.Int64(), 0).Sub(time.Now())
+	if header.Difficulty.Cmp(diffNoTurn) == 0 {
+		wiggle := time.Duration(len(snap.Signers)/2+1) * wiggleTime
+		delay += time.Duration(rand.Int63n(int64(wiggle)))
+	}
+	log.Debug("Waiting for slot to sign and propagate,delay %v", delay)
+	select {
+	case <-stop:
+		return nil, nil
+	case <-time.After(delay):
+	}
+
+	sig, err := key.Sign(sealHash(header).Bytes())
+	if err != nil {
+		return nil, err
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig[:])
+	return block.WithSeal(header), nil
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm,it returns 2 if the
+// local signer is in-turn and 1 otherwise
+func (p *PoA) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	snap, err := p.snapshot(chain, parent.Number.Uint64(), parent.Hash())
+	if err != nil {
+		return nil
+	}
+	p.lock.RLock()
+	signer := p.signer
+	p.lock.RUnlock()
+	return calcDifficulty(snap, signer)
+}
+
+func calcDifficulty(snap *Snapshot, signer common.Address) *big.Int {
+	if snap.inturn(snap.Number+1, signer) {
+		return new(big.Int).Set(diffInTurn)
+	}
+	return new(big.Int).Set(diffNoTurn)
+}
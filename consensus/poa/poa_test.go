@@ -0,0 +1,338 @@
+package poa
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"seth/accounts"
+	"seth/common"
+	"seth/config"
+	"seth/core/types"
+	"seth/crypto"
+	"seth/database"
+	"seth/database/leveldb"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newTestPoADB() (database.Database, func()) {
+	dir, err := ioutil.TempDir("", "testpoadb")
+	if err != nil {
+		panic(err)
+	}
+	db, err := leveldb.NewLevelDB(dir, 0, 0)
+	if err != nil {
+		panic(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// testChain is a chain reader on the headers in memory
+type testChain map[common.Hash]*types.Header
+
+func (c testChain) Config() *config.ChainConfig { return config.MainnetChainConfig }
+
+func (c testChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+func (c testChain) GetHeaderByNumber(number uint64) *types.Header {
+	for _, header := range c {
+		if header.Number.Uint64() == number {
+			return header
+		}
+	}
+	return nil
+}
+
+// testSigner is an authorized signer of test
+type testSigner struct {
+	addr common.Address
+	key  *crypto.PrivateKey
+}
+
+// newTestSigners returns n signers in ascending order of address
+func newTestSigners(n int) []testSigner {
+	signers := make([]testSigner, n)
+	for i := range signers {
+		signers[i].addr, signers[i].key = accounts.NewRandomAccount()
+	}
+	sort.Slice(signers, func(i, j int) bool {
+		return bytes.Compare(signers[i].addr[:], signers[j].addr[:]) < 0
+	})
+	return signers
+}
+
+func newTestGenesis(signers []testSigner) *types.Header {
+	addrs := make([]common.Address, len(signers))
+	for i, signer := range signers {
+		addrs[i] = signer.addr
+	}
+	return &types.Header{Number: big.NewInt(0), Time: big.NewInt(0), Difficulty: big.NewInt(1), Extra: GenesisExtra(addrs)}
+}
+
+// newTestHeader makes a header on parent which votes on the coinbase and is
+// signed by signer
+func newTestHeader(parent *types.Header, signer testSigner, coinbase common.Address, authorize bool, difficulty int64) *types.Header {
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Coinbase:   coinbase,
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		Time:       new(big.Int).Add(parent.Time, common.Big1),
+		Difficulty: big.NewInt(difficulty),
+		Extra:      make([]byte, extraVanity+extraSeal),
+	}
+	if authorize {
+		copy(header.Nonce[:], nonceAuthVote)
+	}
+	signTestHeader(header, signer)
+	return header
+}
+
+func signTestHeader(header *types.Header, signer testSigner) {
+	sig, _ := signer.key.Sign(sealHash(header).Bytes())
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig[:])
+}
+
+func Test_PoA_VerifyHeader(t *testing.T) {
+	signers := newTestSigners(3)
+	engine := New(&config.PoAConfig{Period: 1, Epoch: 100}, nil)
+	genesis := newTestGenesis(signers)
+	chain := testChain{genesis.Hash(): genesis}
+
+	// signer 1 is in-turn for block 1
+	block1 := newTestHeader(genesis, signers[1], common.Address{}, false, 2)
+	if err := engine.VerifyHeader(chain, block1, true); err != nil {
+		t.Fatalf("Failed to verify in-turn header: %v", err)
+	}
+	if author, err := engine.Author(block1); err != nil || author != signers[1].addr {
+		t.Fatalf("Error author of header: %x,%v", author, err)
+	}
+	outturn := newTestHeader(genesis, signers[2], common.Address{}, false, 1)
+	if err := engine.VerifyHeader(chain, outturn, true); err != nil {
+		t.Fatalf("Failed to verify out-of-turn header: %v", err)
+	}
+
+	unauthorized := newTestSigners(1)[0]
+	tests := []struct {
+		header *types.Header
+		err    error
+	}{
+		{newTestHeader(genesis, signers[1], common.Address{}, false, 1), ErrInvalidDifficulty},
+		{newTestHeader(genesis, signers[2], common.Address{}, false, 2), ErrInvalidDifficulty},
+		{newTestHeader(genesis, signers[1], common.Address{}, false, 3), ErrInvalidDifficulty},
+		{newTestHeader(genesis, unauthorized, common.Address{}, false, 1), ErrUnauthorized},
+		{func() *types.Header {
+			header := newTestHeader(genesis, signers[1], common.Address{}, false, 2)
+			header.Time = new(big.Int).Set(genesis.Time)
+			signTestHeader(header, signers[1])
+			return header
+		}(), ErrInvalidTimestamp},
+		{func() *types.Header {
+			header := newTestHeader(genesis, signers[1], common.Address{}, false, 2)
+			header.Extra = make([]byte, extraVanity+common.AddressLength+extraSeal)
+			signTestHeader(header, signers[1])
+			return header
+		}(), errExtraSigners},
+	}
+	for i, test := range tests {
+		if err := engine.VerifyHeader(chain, test.header, true); err != test.err {
+			t.Fatalf("Error result of header %d: %v,want %v", i, err, test.err)
+		}
+	}
+
+	// the signer can not sign the next block
+	chain[block1.Hash()] = block1
+	if err := engine.VerifyHeader(chain, newTestHeader(block1, signers[1], common.Address{}, false, 1), true); err != ErrRecentlySigned {
+		t.Fatalf("Error result of recently signed header: %v", err)
+	}
+	if err := engine.VerifyHeader(chain, newTestHeader(block1, signers[2], common.Address{}, false, 2), true); err != nil {
+		t.Fatalf("Failed to verify header of block 2: %v", err)
+	}
+}
+
+func Test_PoA_Voting(t *testing.T) {
+	signers := newTestSigners(2)
+	candidate := newTestSigners(1)[0]
+	engine := New(&config.PoAConfig{Epoch: 100}, nil)
+	genesis := newTestGenesis(signers)
+	chain := testChain{genesis.Hash(): genesis}
+
+	insert := func(parent *types.Header, signer testSigner, coinbase common.Address, authorize bool) *types.Header {
+		snap, err := engine.snapshot(chain, parent.Number.Uint64(), parent.Hash())
+		if err != nil {
+			t.Fatalf("Failed to get snapshot: %v", err)
+		}
+		difficulty := int64(1)
+		if snap.inturn(parent.Number.Uint64()+1, signer.addr) {
+			difficulty = 2
+		}
+		header := newTestHeader(parent, signer, coinbase, authorize, difficulty)
+		if err := engine.VerifyHeader(chain, header, true); err != nil {
+			t.Fatalf("Failed to verify header %d: %v", header.Number, err)
+		}
+		chain[header.Hash()] = header
+		return header
+	}
+	signersAt := func(header *types.Header) map[common.Address]struct{} {
+		snap, err := engine.snapshot(chain, header.Number.Uint64(), header.Hash())
+		if err != nil {
+			t.Fatalf("Failed to get snapshot: %v", err)
+		}
+		return snap.Signers
+	}
+
+	// a single vote is not the majority
+	block1 := insert(genesis, signers[0], candidate.addr, true)
+	if _, ok := signersAt(block1)[candidate.addr]; ok {
+		t.Fatalf("Error: candidate authorized without majority")
+	}
+	block2 := insert(block1, signers[1], candidate.addr, true)
+	if _, ok := signersAt(block2)[candidate.addr]; !ok {
+		t.Fatalf("Error: candidate not authorized by majority")
+	}
+
+	// the new signer can seal blocks
+	block3 := insert(block2, candidate, common.Address{}, false)
+
+	// two of three signers vote to remove the candidate
+	block4 := insert(block3, signers[0], candidate.addr, false)
+	block5 := insert(block4, signers[1], candidate.addr, false)
+	if current := signersAt(block5); len(current) != 2 {
+		t.Fatalf("Error signers after removal: %v", current)
+	} else if _, ok := current[candidate.addr]; ok {
+		t.Fatalf("Error: candidate not removed by majority")
+	}
+	if snap, _ := engine.snapshot(chain, 5, block5.Hash()); len(snap.Votes) != 0 || len(snap.Tally) != 0 {
+		t.Fatalf("Error: votes not discarded after removal: %v", snap.Votes)
+	}
+	header := newTestHeader(block5, candidate, common.Address{}, false, 1)
+	if err := engine.VerifyHeader(chain, header, true); err != ErrUnauthorized {
+		t.Fatalf("Error result of removed signer: %v", err)
+	}
+
+	// signer 1 is removed by the majority
+	block6 := insert(block5, signers[0], signers[0].addr, false)
+	block7 := insert(block6, signers[1], signers[1].addr, false)
+	block8 := insert(block7, signers[0], signers[1].addr, false)
+	if current := signersAt(block8); len(current) != 1 {
+		t.Fatalf("Error signers after removal: %v", current)
+	}
+	// the last signer can't be dropped
+	block9 := insert(block8, signers[0], signers[0].addr, false)
+	if current := signersAt(block9); len(current) != 1 {
+		t.Fatalf("Error: last signer dropped: %v", current)
+	}
+}
+
+func Test_PoA_Checkpoint(t *testing.T) {
+	db, remove := newTestPoADB()
+	defer remove()
+
+	signers := newTestSigners(2)
+	engine := New(&config.PoAConfig{Epoch: 2}, db)
+	genesis := newTestGenesis(signers)
+	chain := testChain{genesis.Hash(): genesis}
+
+	block1 := newTestHeader(genesis, signers[1], signers[0].addr, false, 2)
+	if err := engine.VerifyHeader(chain, block1, true); err != nil {
+		t.Fatalf("Failed to verify header: %v", err)
+	}
+	chain[block1.Hash()] = block1
+
+	checkpoint := newTestHeader(block1, signers[0], common.Address{}, false, 2)
+	checkpoint.Extra = append(make([]byte, extraVanity), append(append(signers[0].addr[:], signers[1].addr[:]...), make([]byte, extraSeal)...)...)
+	signTestHeader(checkpoint, signers[0])
+	if err := engine.VerifyHeader(chain, checkpoint, true); err != nil {
+		t.Fatalf("Failed to verify checkpoint: %v", err)
+	}
+	chain[checkpoint.Hash()] = checkpoint
+	if snap, _ := engine.snapshot(chain, 2, checkpoint.Hash()); len(snap.Votes) != 0 || len(snap.Tally) != 0 {
+		t.Fatalf("Error: votes not reset on checkpoint: %v", snap.Votes)
+	}
+
+	invalid := newTestHeader(block1, signers[0], common.Address{}, false, 2)
+	invalid.Extra = append(make([]byte, extraVanity), append(signers[1].addr[:], make([]byte, extraSeal)...)...)
+	signTestHeader(invalid, signers[0])
+	if err := engine.VerifyHeader(chain, invalid, true); err != ErrInvalidCheckpointSigners {
+		t.Fatalf("Error result of invalid checkpoint signers: %v", err)
+	}
+	invalid = newTestHeader(block1, signers[0], signers[1].addr, false, 2)
+	if err := engine.VerifyHeader(chain, invalid, true); err != errInvalidCheckpointBeneficiary {
+		t.Fatalf("Error result of vote in checkpoint: %v", err)
+	}
+
+	// the genesis snapshot is persisted
+	snap, err := loadSnapshot(engine.config, engine.signatures, db, genesis.Hash())
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	if len(snap.Signers) != 2 || snap.Number != 0 {
+		t.Fatalf("Error loaded snapshot: %v", snap.Signers)
+	}
+}
+
+func Test_PoA_Seal(t *testing.T) {
+	signers := newTestSigners(2)
+	engine := New(&config.PoAConfig{Epoch: 100}, nil)
+	genesis := newTestGenesis(signers)
+	chain := testChain{genesis.Hash(): genesis}
+	candidate := newTestSigners(1)[0]
+
+	// signer 1 is in-turn for block 1
+	engine.Authorize(signers[1].key)
+	engine.Propose(candidate.addr, true)
+	header := &types.Header{ParentHash: genesis.Hash(), Number: big.NewInt(1), GasLimit: 1000000}
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("Failed to prepare header: %v", err)
+	}
+	if header.Difficulty.Cmp(diffInTurn) != 0 || header.Coinbase != candidate.addr || !bytes.Equal(header.Nonce[:], nonceAuthVote) {
+		t.Fatalf("Error prepared header: %v %x", header.Difficulty, header.Coinbase)
+	}
+	if _, err := engine.Seal(chain, types.NewBlock(header, nil), nil); err != errWaitTransactions {
+		t.Fatalf("Error result of sealing empty block: %v", err)
+	}
+	tx := types.NewTransaction(candidate.addr, big.NewInt(1), 0, 21000, big.NewInt(1))
+	block, err := engine.Seal(chain, types.NewBlock(header, []*types.Transaction{tx}), nil)
+	if err != nil {
+		t.Fatalf("Failed to seal block: %v", err)
+	}
+	if err := engine.VerifyHeader(chain, block.Header, true); err != nil {
+		t.Fatalf("Failed to verify sealed header: %v", err)
+	}
+	if author, _ := engine.Author(block.Header); author != signers[1].addr {
+		t.Fatalf("Error author of sealed block: %x", author)
+	}
+
+	unauthorized := New(&config.PoAConfig{Epoch: 100}, nil)
+	unauthorized.Authorize(candidate.key)
+	if _, err := unauthorized.Seal(chain, block, nil); err != ErrUnauthorized {
+		t.Fatalf("Error result of unauthorized signer: %v", err)
+	}
+}
+
+func Test_PoA_PrepareTime(t *testing.T) {
+	signers := newTestSigners(1)
+	engine := New(&config.PoAConfig{Epoch: 100}, nil)
+	genesis := newTestGenesis(signers)
+	// the parent is ahead of the local clock and the period is zero
+	genesis.Time = big.NewInt(time.Now().Unix() + 5)
+	chain := testChain{genesis.Hash(): genesis}
+
+	engine.Authorize(signers[0].key)
+	header := &types.Header{ParentHash: genesis.Hash(), Number: big.NewInt(1), GasLimit: 1000000}
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("Failed to prepare header: %v", err)
+	}
+	if header.Time.Cmp(genesis.Time) <= 0 {
+		t.Fatalf("Error time of prepared header: %v,parent %v", header.Time, genesis.Time)
+	}
+}
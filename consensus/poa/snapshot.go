@@ -0,0 +1,271 @@
+package poa
+
+import (
+	"bytes"
+	"encoding/json"
+	"seth/common"
+	"seth/config"
+	"seth/core/types"
+	"seth/database"
+	"sort"
+
+	"github.com/hashicorp/golang-lru"
+)
+
+// Vote represents a single vote that an authorized signer made to modify the
+// list of authorizations
+type Vote struct {
+	Signer    common.Address `json:"signer"`    // authorized signer that cast this vote
+	Block     uint64         `json:"block"`     // block number the vote was cast in (expire old votes)
+	Address   common.Address `json:"address"`   // account being voted on to change its authorization
+	Authorize bool           `json:"authorize"` // whether to authorize or deauthorize the voted account
+}
+
+// Tally is a simple vote tally to keep the current score of votes
+type Tally struct {
+	Authorize bool `json:"authorize"` // whether the vote is about authorizing or kicking someone
+	Votes     int  `json:"votes"`     // number of votes until now wanting to pass the proposal
+}
+
+// Snapshot is the state of the authorization voting at a given point in time
+type Snapshot struct {
+	config   *config.PoAConfig
+	sigcache *lru.ARCCache
+
+	Number  uint64                      `json:"number"`  // block number where the snapshot was created
+	Hash    common.Hash                 `json:"hash"`    // block hash where the snapshot was created
+	Signers map[common.Address]struct{} `json:"signers"` // set of authorized signers at this moment
+	Recents map[uint64]common.Address   `json:"recents"` // set of recent signers for spam protections
+	Votes   []*Vote                     `json:"votes"`   // list of votes cast in chronological order
+	Tally   map[common.Address]Tally    `json:"tally"`   // current vote tally to avoid recalculating
+}
+
+// newSnapshot creates a new snapshot with the specified startup parameters,it
+// is only used for the genesis block
+func newSnapshot(config *config.PoAConfig, sigcache *lru.ARCCache, number uint64, hash common.Hash, signers []common.Address) *Snapshot {
+	snap := &Snapshot{
+		config:   config,
+		sigcache: sigcache,
+		Number:   number,
+		Hash:     hash,
+		Signers:  make(map[common.Address]struct{}),
+		Recents:  make(map[uint64]common.Address),
+		Tally:    make(map[common.Address]Tally),
+	}
+	for _, signer := range signers {
+		snap.Signers[signer] = struct{}{}
+	}
+	return snap
+}
+
+// loadSnapshot loads an existing snapshot from the database
+func loadSnapshot(config *config.PoAConfig, sigcache *lru.ARCCache, db database.Database, hash common.Hash) (*Snapshot, error) {
+	blob, err := db.Get(append(snapshotPrefix, hash[:]...))
+	if err != nil {
+		return nil, err
+	}
+	snap := new(Snapshot)
+	if err := json.Unmarshal(blob, snap); err != nil {
+		return nil, err
+	}
+	snap.config = config
+	snap.sigcache = sigcache
+	return snap, nil
+}
+
+// store inserts the snapshot into the database
+func (s *Snapshot) store(db database.Database) error {
+	blob, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return db.Put(append(snapshotPrefix, s.Hash[:]...), blob)
+}
+
+// copy creates a deep copy of the snapshot,though not the individual votes
+func (s *Snapshot) copy() *Snapshot {
+	cpy := &Snapshot{
+		config:   s.config,
+		sigcache: s.sigcache,
+		Number:   s.Number,
+		Hash:     s.Hash,
+		Signers:  make(map[common.Address]struct{}),
+		Recents:  make(map[uint64]common.Address),
+		Votes:    make([]*Vote, len(s.Votes)),
+		Tally:    make(map[common.Address]Tally),
+	}
+	for signer := range s.Signers {
+		cpy.Signers[signer] = struct{}{}
+	}
+	for block, signer := range s.Recents {
+		cpy.Recents[block] = signer
+	}
+	for address, tally := range s.Tally {
+		cpy.Tally[address] = tally
+	}
+	copy(cpy.Votes, s.Votes)
+	return cpy
+}
+
+// validVote returns whether it makes sense to cast the specified vote in the
+// given snapshot context,the last signer can't be dropped
+func (s *Snapshot) validVote(address common.Address, authorize bool) bool {
+	_, signer := s.Signers[address]
+	return (signer && !authorize && len(s.Signers) > 1) || (!signer && authorize)
+}
+
+// cast adds a new vote into the tally
+func (s *Snapshot) cast(address common.Address, authorize bool) bool {
+	if !s.validVote(address, authorize) {
+		return false
+	}
+	if old, ok := s.Tally[address]; ok {
+		old.Votes++
+		s.Tally[address] = old
+	} else {
+		s.Tally[address] = Tally{Authorize: authorize, Votes: 1}
+	}
+	return true
+}
+
+// uncast removes a previously cast vote from the tally
+func (s *Snapshot) uncast(address common.Address, authorize bool) bool {
+	tally, ok := s.Tally[address]
+	if !ok {
+		return false
+	}
+	if tally.Authorize != authorize {
+		return false
+	}
+	if tally.Votes > 1 {
+		tally.Votes--
+		s.Tally[address] = tally
+	} else {
+		delete(s.Tally, address)
+	}
+	return true
+}
+
+// apply creates a new authorization snapshot by applying the given headers to
+// the original one
+func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
+	if len(headers) == 0 {
+		return s, nil
+	}
+	for i := 0; i < len(headers)-1; i++ {
+		if headers[i+1].Number.Uint64() != headers[i].Number.Uint64()+1 {
+			return nil, errInvalidVotingChain
+		}
+	}
+	if headers[0].Number.Uint64() != s.Number+1 {
+		return nil, errInvalidVotingChain
+	}
+	snap := s.copy()
+
+	for _, header := range headers {
+		// remove any votes on checkpoint blocks
+		number := header.Number.Uint64()
+		if number%s.config.Epoch == 0 {
+			snap.Votes = nil
+			snap.Tally = make(map[common.Address]Tally)
+		}
+		// delete the oldest signer from the recent list to allow it signing again
+		if limit := uint64(len(snap.Signers)/2 + 1); number >= limit {
+			delete(snap.Recents, number-limit)
+		}
+		signer, err := ecrecover(header, s.sigcache)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := snap.Signers[signer]; !ok {
+			return nil, ErrUnauthorized
+		}
+		for _, recent := range snap.Recents {
+			if recent == signer {
+				return nil, ErrRecentlySigned
+			}
+		}
+		snap.Recents[number] = signer
+
+		// discard any previous votes of the signer on the same address
+		for i, vote := range snap.Votes {
+			if vote.Signer == signer && vote.Address == header.Coinbase {
+				snap.uncast(vote.Address, vote.Authorize)
+				snap.Votes = append(snap.Votes[:i], snap.Votes[i+1:]...)
+				break
+			}
+		}
+		var authorize bool
+		switch {
+		case bytes.Equal(header.Nonce[:], nonceAuthVote):
+			authorize = true
+		case bytes.Equal(header.Nonce[:], nonceDropVote):
+			authorize = false
+		default:
+			return nil, errInvalidVote
+		}
+		if snap.cast(header.Coinbase, authorize) {
+			snap.Votes = append(snap.Votes, &Vote{
+				Signer:    signer,
+				Block:     number,
+				Address:   header.Coinbase,
+				Authorize: authorize,
+			})
+		}
+		// if the vote passed,update the list of signers,the last one is kept
+		tally := snap.Tally[header.Coinbase]
+		if tally.Votes > len(snap.Signers)/2 && (tally.Authorize || len(snap.Signers) > 1) {
+			if tally.Authorize {
+				snap.Signers[header.Coinbase] = struct{}{}
+			} else {
+				delete(snap.Signers, header.Coinbase)
+
+				// signer list shrunk,delete any leftover recent caches
+				if limit := uint64(len(snap.Signers)/2 + 1); number >= limit {
+					delete(snap.Recents, number-limit)
+				}
+				// discard any previous votes the deauthorized signer cast
+				for i := 0; i < len(snap.Votes); i++ {
+					if snap.Votes[i].Signer == header.Coinbase {
+						snap.uncast(snap.Votes[i].Address, snap.Votes[i].Authorize)
+						snap.Votes = append(snap.Votes[:i], snap.Votes[i+1:]...)
+						i--
+					}
+				}
+			}
+			// discard any previous votes around the just changed account
+			for i := 0; i < len(snap.Votes); i++ {
+				if snap.Votes[i].Address == header.Coinbase {
+					snap.Votes = append(snap.Votes[:i], snap.Votes[i+1:]...)
+					i--
+				}
+			}
+			delete(snap.Tally, header.Coinbase)
+		}
+	}
+	snap.Number += uint64(len(headers))
+	snap.Hash = headers[len(headers)-1].Hash()
+
+	return snap, nil
+}
+
+// signers retrieves the list of authorized signers in ascending order
+func (s *Snapshot) signers() []common.Address {
+	signers := make([]common.Address, 0, len(s.Signers))
+	for signer := range s.Signers {
+		signers = append(signers, signer)
+	}
+	sort.Slice(signers, func(i, j int) bool {
+		return bytes.Compare(signers[i][:], signers[j][:]) < 0
+	})
+	return signers
+}
+
+// inturn returns if a signer at a given block height is in-turn or not
+func (s *Snapshot) inturn(number uint64, signer common.Address) bool {
+	signers, offset := s.signers(), 0
+	for offset < len(signers) && signers[offset] != signer {
+		offset++
+	}
+	return (number % uint64(len(signers))) == uint64(offset)
+}
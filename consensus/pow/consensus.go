@@ -0,0 +1,167 @@
+package pow
+
+import (
+	"errors"
+	"math/big"
+	"seth/common"
+	"seth/consensus"
+	"seth/core/state"
+	"seth/core/types"
+	"time"
+)
+
+const (
+	// MaximumExtraDataSize maximum size of extra data in block header
+	MaximumExtraDataSize = 32
+	// GasLimitBoundDivisor the bound divisor of the gas limit,used in update calculations
+	GasLimitBoundDivisor uint64 = 1024
+
+	// allowedFutureBlockTime max time from current time allowed for blocks,before they're considered future blocks
+	allowedFutureBlockTime = 15 * time.Second
+)
+
+var (
+	// DifficultyBoundDivisor the bound divisor of the difficulty,used in the update calculations
+	DifficultyBoundDivisor = big.NewInt(2048)
+	// MinimumDifficulty the minimum that the difficulty may ever be
+	MinimumDifficulty = big.NewInt(131072)
+	// DurationLimit the decision boundary on the blocktime duration used to
+	// determine whether difficulty should go up or not
+	DurationLimit = big.NewInt(10)
+
+	bigMinus99 = big.NewInt(-99)
+)
+
+var (
+	// ErrInvalidTimestamp is returned if the timestamp of block is not later
+	// than parent
+	ErrInvalidTimestamp = errors.New("timestamp older than parent")
+	// ErrExtraDataTooLong is returned if the extra data of block exceeds
+	// MaximumExtraDataSize
+	ErrExtraDataTooLong = errors.New("extra data too long")
+	// ErrInvalidDifficulty is returned if the difficulty of block is not the
+	// one calculated from parent
+	ErrInvalidDifficulty = errors.New("invalid difficulty")
+	// ErrInvalidGasLimit is returned if the gas limit of block changes too much
+	// from parent
+	ErrInvalidGasLimit = errors.New("invalid gas limit")
+	// ErrGasUsedExceedsLimit is returned if the gas used of block is larger
+	// than the gas limit
+	ErrGasUsedExceedsLimit = errors.New("gas used exceeds gas limit")
+	// ErrInvalidMixDigest is returned if the mix digest of block does not
+	// match the nonce
+	ErrInvalidMixDigest = errors.New("invalid mix digest")
+	// ErrInvalidPoW is returned if the nonce of block does not satisfy the
+	// difficulty
+	ErrInvalidPoW = errors.New("invalid proof-of-work")
+)
+
+// Author returns the coinbase of header,the miner who found the seal
+func (p *PoW) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules
+func (p *PoW) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	if p.config.Mode == ModeFullFake {
+		return nil
+	}
+	if header.Number == nil || header.Number.Sign() <= 0 {
+		return consensus.ErrInvalidNumber
+	}
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	if header.Number.Cmp(new(big.Int).Add(parent.Number, common.Big1)) != 0 {
+		return consensus.ErrInvalidNumber
+	}
+	if len(header.Extra) > MaximumExtraDataSize {
+		return ErrExtraDataTooLong
+	}
+	if header.Time == nil || header.Time.Cmp(big.NewInt(time.Now().Add(allowedFutureBlockTime).Unix())) > 0 {
+		return consensus.ErrFutureBlock
+	}
+	if header.Time.Cmp(parent.Time) <= 0 {
+		return ErrInvalidTimestamp
+	}
+	expected := p.CalcDifficulty(chain, header.Time.Uint64(), parent)
+	if header.Difficulty == nil || header.Difficulty.Cmp(expected) != 0 {
+		return ErrInvalidDifficulty
+	}
+
+	if header.GasUsed > header.GasLimit {
+		return ErrGasUsedExceedsLimit
+	}
+	diff := parent.GasLimit - header.GasLimit
+	if header.GasLimit > parent.GasLimit {
+		diff = header.GasLimit - parent.GasLimit
+	}
+	if limit := parent.GasLimit / GasLimitBoundDivisor; diff >= limit || header.GasLimit < consensus.MinGasLimit {
+		return ErrInvalidGasLimit
+	}
+
+	if seal {
+		return p.VerifySeal(chain, header)
+	}
+	return nil
+}
+
+// VerifySeal checks whether the nonce of header satisfies the difficulty
+func (p *PoW) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	if p.config.Mode == ModeFake || p.config.Mode == ModeFullFake {
+		return nil
+	}
+	if header.Difficulty == nil || header.Difficulty.Sign() <= 0 {
+		return ErrInvalidDifficulty
+	}
+	mix, result := hashimoto(header.HashNoNonce(), header.Nonce.Uint64())
+	if mix != header.MixDigest {
+		return ErrInvalidMixDigest
+	}
+	target := new(big.Int).Div(maxUint256, header.Difficulty)
+	if new(big.Int).SetBytes(result).Cmp(target) > 0 {
+		return ErrInvalidPoW
+	}
+	return nil
+}
+
+// Prepare sets the difficulty of header
+func (p *PoW) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Difficulty = p.CalcDifficulty(chain, header.Time.Uint64(), parent)
+	return nil
+}
+
+// Finalize sets the roots and bloom of header and assembles the block
+func (p *PoW) Finalize(chain consensus.ChainReader, header *types.Header, statedb *state.Statedb, txs []*types.Transaction,
+	receipts []*types.Receipt) (*types.Block, error) {
+	header.Root = statedb.IntermediateRoot()
+	header.ReceiptHash = types.DeriveSha(types.Receipts(receipts))
+	header.Bloom = types.CreateBloom(receipts)
+	return types.NewBlock(header, txs), nil
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm,the difficulty goes
+// up if the block time is shorter than DurationLimit and goes down otherwise:
+//
+// diff = parent_diff + parent_diff / 2048 * max(1 - (block_time - parent_time) / 10, -99)
+func (p *PoW) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	x := new(big.Int).SetUint64(time)
+	x.Sub(x, parent.Time)
+	x.Div(x, DurationLimit)
+	x.Sub(common.Big1, x)
+	if x.Cmp(bigMinus99) < 0 {
+		x.Set(bigMinus99)
+	}
+	y := new(big.Int).Div(parent.Difficulty, DifficultyBoundDivisor)
+	x.Mul(y, x)
+	x.Add(parent.Difficulty, x)
+	if x.Cmp(MinimumDifficulty) < 0 {
+		x.Set(MinimumDifficulty)
+	}
+	return x
+}
@@ -0,0 +1,75 @@
+package pow
+
+import (
+	"encoding/binary"
+	"math/big"
+	"math/rand"
+	"runtime"
+	"seth/common"
+	"seth/crypto"
+	"sync"
+)
+
+// Mode defines the type and amount of PoW verification a PoW engine makes
+type Mode uint
+
+const (
+	// ModeNormal verify the seal of headers
+	ModeNormal Mode = iota
+	// ModeFake accept any seal,the other rules of header are still verified
+	ModeFake
+	// ModeFullFake accept any header without verification
+	ModeFullFake
+)
+
+// maxUint256 is a big integer representing 2^256
+var maxUint256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
+
+// Config are the configuration parameters of the PoW engine
+type Config struct {
+	Threads int  // number of threads to search the nonce,0 for the number of CPUs
+	Mode    Mode // verification mode
+}
+
+// PoW is a consensus engine based on proof-of-work,the seal is a nonce which
+// makes the keccak256 hash of the header lower than 2^256/difficulty
+type PoW struct {
+	config Config
+
+	lock sync.Mutex // protects the random source
+	rand *rand.Rand // random source for the initial nonce
+}
+
+// New creates a PoW engine
+func New(config Config) *PoW {
+	return &PoW{config: config}
+}
+
+// NewFaker creates a PoW engine which accepts any seal as valid and seals
+// blocks without searching the nonce,it is used in unit tests
+func NewFaker() *PoW {
+	return New(Config{Mode: ModeFake})
+}
+
+// NewFullFaker creates a PoW engine which accepts any header as valid,it is
+// used in unit tests
+func NewFullFaker() *PoW {
+	return New(Config{Mode: ModeFullFake})
+}
+
+// Threads returns the number of threads to search the nonce
+func (p *PoW) Threads() int {
+	if p.config.Threads <= 0 {
+		return runtime.NumCPU()
+	}
+	return p.config.Threads
+}
+
+// hashimoto returns the mix digest and the result of the nonce for the hash
+// of header without nonce
+func hashimoto(hash common.Hash, nonce uint64) (common.Hash, []byte) {
+	seed := make([]byte, 8)
+	binary.LittleEndian.PutUint64(seed, nonce)
+	mix := crypto.Keccak256(hash[:], seed)
+	return common.BytesToHash(mix), crypto.Keccak256(seed, mix)
+}
@@ -0,0 +1,147 @@
+package pow
+
+import (
+	"math/big"
+	"seth/common"
+	"seth/config"
+	"seth/consensus"
+	"seth/core/types"
+	"testing"
+	"time"
+)
+
+// testChain is a chain reader on the headers in memory
+type testChain map[common.Hash]*types.Header
+
+func (c testChain) Config() *config.ChainConfig { return config.MainnetChainConfig }
+
+func (c testChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+func (c testChain) GetHeaderByNumber(number uint64) *types.Header {
+	for _, header := range c {
+		if header.Number.Uint64() == number {
+			return header
+		}
+	}
+	return nil
+}
+
+func Test_PoW_Seal(t *testing.T) {
+	engine := New(Config{Threads: 2})
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(100), Time: big.NewInt(10)}
+	block, err := engine.Seal(nil, types.NewBlock(header, nil), nil)
+	if err != nil || block == nil {
+		t.Fatalf("Failed to seal block: %v", err)
+	}
+	if err := engine.VerifySeal(nil, block.Header); err != nil {
+		t.Fatalf("Failed to verify seal: %v", err)
+	}
+
+	invalid := block.Header.Clone()
+	invalid.Nonce = types.EncodeNonce(invalid.Nonce.Uint64() + 1)
+	if err := engine.VerifySeal(nil, invalid); err != ErrInvalidMixDigest {
+		t.Fatalf("Error result of invalid nonce: %v", err)
+	}
+	invalid = block.Header.Clone()
+	invalid.Difficulty = new(big.Int).Lsh(common.Big1, 250)
+	invalid.MixDigest, _ = hashimoto(invalid.HashNoNonce(), invalid.Nonce.Uint64())
+	if err := engine.VerifySeal(nil, invalid); err != ErrInvalidPoW {
+		t.Fatalf("Error result of unsatisfied difficulty: %v", err)
+	}
+	if err := NewFaker().VerifySeal(nil, invalid); err != nil {
+		t.Fatalf("Error: fake engine should accept any seal: %v", err)
+	}
+	invalid.Difficulty = nil
+	if err := engine.VerifySeal(nil, invalid); err != ErrInvalidDifficulty {
+		t.Fatalf("Error result of nil difficulty: %v", err)
+	}
+}
+
+func Test_PoW_SealAbort(t *testing.T) {
+	engine := New(Config{Threads: 2})
+	header := &types.Header{Number: big.NewInt(1), Difficulty: new(big.Int).Lsh(common.Big1, 200), Time: big.NewInt(10)}
+	stop := make(chan struct{})
+	result := make(chan *types.Block)
+	go func() {
+		block, _ := engine.Seal(nil, types.NewBlock(header, nil), stop)
+		result <- block
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	select {
+	case block := <-result:
+		if block != nil {
+			t.Fatalf("Error: aborted seal should return nil block")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Error: seal is not aborted")
+	}
+}
+
+func Test_PoW_CalcDifficulty(t *testing.T) {
+	engine := NewFaker()
+	parent := &types.Header{Difficulty: big.NewInt(2048 * 1000), Time: big.NewInt(100)}
+	tests := []struct {
+		time   uint64
+		expect int64
+	}{
+		{105, 2048*1000 + 1000},
+		{110, 2048 * 1000},
+		{125, 2048*1000 - 1000},
+		{100000, 2048*1000 - 99*1000},
+	}
+	for i, test := range tests {
+		if diff := engine.CalcDifficulty(nil, test.time, parent); diff.Cmp(big.NewInt(test.expect)) != 0 {
+			t.Fatalf("Error difficulty %d: %v,want %d", i, diff, test.expect)
+		}
+	}
+	parent.Difficulty = big.NewInt(1)
+	if diff := engine.CalcDifficulty(nil, 200, parent); diff.Cmp(MinimumDifficulty) != 0 {
+		t.Fatalf("Error minimum difficulty: %v", diff)
+	}
+}
+
+func Test_PoW_VerifyHeader(t *testing.T) {
+	engine := NewFaker()
+	parent := &types.Header{Number: big.NewInt(0), Difficulty: MinimumDifficulty, Time: big.NewInt(100), GasLimit: 1000000}
+	chain := testChain{parent.Hash(): parent}
+	valid := &types.Header{ParentHash: parent.Hash(), Number: big.NewInt(1), Time: big.NewInt(110), GasLimit: 1000000}
+	if err := engine.Prepare(chain, valid); err != nil {
+		t.Fatalf("Failed to prepare header: %v", err)
+	}
+	if err := engine.VerifyHeader(chain, valid, true); err != nil {
+		t.Fatalf("Failed to verify header: %v", err)
+	}
+
+	modify := func(fn func(header *types.Header)) *types.Header {
+		header := valid.Clone()
+		fn(header)
+		return header
+	}
+	tests := []struct {
+		header *types.Header
+		err    error
+	}{
+		{modify(func(h *types.Header) { h.ParentHash = common.Hash{} }), consensus.ErrUnknownAncestor},
+		{modify(func(h *types.Header) { h.Number = big.NewInt(2) }), consensus.ErrUnknownAncestor},
+		{modify(func(h *types.Header) { h.Extra = make([]byte, MaximumExtraDataSize+1) }), ErrExtraDataTooLong},
+		{modify(func(h *types.Header) { h.Time = big.NewInt(time.Now().Unix() + 60) }), consensus.ErrFutureBlock},
+		{modify(func(h *types.Header) { h.Time = big.NewInt(100) }), ErrInvalidTimestamp},
+		{modify(func(h *types.Header) { h.Difficulty = big.NewInt(1) }), ErrInvalidDifficulty},
+		{modify(func(h *types.Header) { h.GasUsed = h.GasLimit + 1 }), ErrGasUsedExceedsLimit},
+		{modify(func(h *types.Header) { h.GasLimit = 2000000 }), ErrInvalidGasLimit},
+	}
+	for i, test := range tests {
+		if err := engine.VerifyHeader(chain, test.header, true); err != test.err {
+			t.Fatalf("Error result of header %d: %v,want %v", i, err, test.err)
+		}
+	}
+	if err := NewFullFaker().VerifyHeader(chain, tests[2].header, true); err != nil {
+		t.Fatalf("Error: full fake engine should accept any header: %v", err)
+	}
+}
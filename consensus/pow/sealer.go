@@ -0,0 +1,95 @@
+package pow
+
+import (
+	crand "crypto/rand"
+	"math"
+	"math/big"
+	"math/rand"
+	"seth/common"
+	"seth/consensus"
+	"seth/core/types"
+	"seth/log"
+	"sync"
+)
+
+// Seal searches the nonce which satisfies the difficulty of block with
+// multiple threads,it returns nil if stop is closed before the nonce is found
+func (p *PoW) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	if p.config.Mode == ModeFake || p.config.Mode == ModeFullFake {
+		header := block.Header.Clone()
+		header.Nonce, header.MixDigest = types.BlockNonce{}, common.Hash{}
+		return block.WithSeal(header), nil
+	}
+	if block.Header.Difficulty == nil || block.Header.Difficulty.Sign() <= 0 {
+		return nil, ErrInvalidDifficulty
+	}
+
+	p.lock.Lock()
+	if p.rand == nil {
+		seed, err := crand.Int(crand.Reader, big.NewInt(math.MaxInt64))
+		if err != nil {
+			p.lock.Unlock()
+			return nil, err
+		}
+		p.rand = rand.New(rand.NewSource(seed.Int64()))
+	}
+	seed := uint64(p.rand.Int63())
+	p.lock.Unlock()
+
+	var (
+		abort   = make(chan struct{})
+		found   = make(chan *types.Block)
+		threads = p.Threads()
+		pend    sync.WaitGroup
+	)
+	for i := 0; i < threads; i++ {
+		pend.Add(1)
+		go func(id int, nonce uint64) {
+			defer pend.Done()
+			p.mine(block, id, nonce, abort, found)
+		}(i, seed+uint64(i)*(math.MaxUint64/uint64(threads)))
+	}
+
+	var result *types.Block
+	select {
+	case <-stop:
+	case result = <-found:
+	}
+	close(abort)
+	pend.Wait()
+	return result, nil
+}
+
+// mine searches the nonce from seed until a valid one is found or abort is
+// closed
+func (p *PoW) mine(block *types.Block, id int, seed uint64, abort chan struct{}, found chan *types.Block) {
+	var (
+		header = block.Header
+		hash   = header.HashNoNonce()
+		target = new(big.Int).Div(maxUint256, header.Difficulty)
+		nonce  = seed
+	)
+	log.Debug("Started pow search for new nonces,miner %d seed %d", id, seed)
+	for {
+		select {
+		case <-abort:
+			log.Debug("Pow nonce search aborted,miner %d attempts %d", id, nonce-seed)
+			return
+		default:
+			mix, result := hashimoto(hash, nonce)
+			if new(big.Int).SetBytes(result).Cmp(target) <= 0 {
+				sealed := header.Clone()
+				sealed.Nonce = types.EncodeNonce(nonce)
+				sealed.MixDigest = mix
+				select {
+				case found <- block.WithSeal(sealed):
+					log.Debug("Pow nonce found and reported,miner %d attempts %d nonce %d", id, nonce-seed, nonce)
+				case <-abort:
+					log.Debug("Pow nonce found but discarded,miner %d attempts %d nonce %d", id, nonce-seed, nonce)
+				}
+				return
+			}
+			nonce++
+		}
+	}
+}
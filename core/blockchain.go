@@ -2,34 +2,90 @@ package core
 
 import (
 	"errors"
+	"math/big"
 	"seth/common"
+	"seth/config"
+	"seth/consensus"
+	"seth/core/state"
 	"seth/core/types"
 	"seth/database"
+	"seth/event"
+	"seth/log"
+	"seth/trie"
+	"sync"
 
 	"github.com/hashicorp/golang-lru"
 )
 
 const (
 	blockCacheLimit = 256
+
+	// DefaultStateRetain default number of recent blocks whose state is retained
+	DefaultStateRetain uint64 = 128
+
+	// stateFlushInterval number of blocks between the writes of retained state
+	// to disk in full gc mode,it bounds the blocks lost on a crash
+	stateFlushInterval uint64 = 128
+
+	// MaximumExtraDataSize max size of the extra data of header,the engines
+	// limit it further
+	MaximumExtraDataSize = 32 * 1024
 )
 
 var (
 	ErrNoGenesis = errors.New("Genesis not found in chain")
+	// ErrNoChainConfig is returned when the chain config of genesis is not
+	// found in database
+	ErrNoChainConfig = errors.New("chain config not found in chain")
+
+	// ErrNonContiguous is returned when the blocks to insert are not contiguous
+	ErrNonContiguous = errors.New("non contiguous insert")
+	// ErrInvalidTxHash is returned when the transactions root of block does
+	// not match the transactions
+	ErrInvalidTxHash = errors.New("invalid transactions root")
+	// ErrInvalidTimestamp is returned when the timestamp of block is not
+	// later than its parent
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+	// ErrExtraDataTooLong is returned when the extra data of block exceeds
+	// MaximumExtraDataSize
+	ErrExtraDataTooLong = errors.New("extra data too long")
+	// ErrGasUsedExceedsLimit is returned when the gas used of block exceeds
+	// its gas limit
+	ErrGasUsedExceedsLimit = errors.New("gas used exceeds gas limit")
 )
 
 // BlockChain block chain
 type BlockChain struct {
 	db database.Database
 
+	chainConfig  *config.ChainConfig
 	genesisBlock *types.Block
+	currentBlock *types.Block // head block of canonical chain
+
+	chainmu    sync.Mutex // lock for inserting blocks
+	engine     consensus.Engine
+	signer     types.Signer
+	dispatcher event.Dispatcher   // dispatcher of chain events
+	triedb     *trie.NodeDatabase // node database of state trie
+	pruner     *state.Pruner      // retain the state of recent blocks,nil in archive gc mode
 
 	blockCache *lru.Cache // Cache for the most recent entire blocks
 }
 
-// NewBlockChain new block chain
-func NewBlockChain(db database.Database) (*BlockChain, error) {
+// NewBlockChain new block chain,the headers of blocks are verified by engine
+func NewBlockChain(db database.Database, engine consensus.Engine) (*BlockChain, error) {
 	bc := &BlockChain{
-		db: db,
+		db:         db,
+		engine:     engine,
+		dispatcher: event.SharedDispatcher(),
+		triedb:     trie.NewNodeDatabase(db),
+	}
+	if config.Config.GCMode != config.GCModeArchive {
+		retain := config.Config.StateRetain
+		if retain == 0 {
+			retain = DefaultStateRetain
+		}
+		bc.pruner = state.NewPruner(bc.triedb, int(retain))
 	}
 
 	bc.blockCache, _ = lru.New(blockCacheLimit)
@@ -37,9 +93,104 @@ func NewBlockChain(db database.Database) (*BlockChain, error) {
 	if bc.genesisBlock == nil {
 		return nil, ErrNoGenesis
 	}
+	bc.chainConfig = ReadChainConfig(db, bc.genesisBlock.Hash())
+	if bc.chainConfig == nil {
+		return nil, ErrNoChainConfig
+	}
+	if id := config.Config.ChainID; id != nil && id.Cmp(bc.chainConfig.ChainID) != 0 {
+		return nil, &config.ConfigCompatError{What: "chain id", Stored: bc.chainConfig.ChainID, New: id}
+	}
+	bc.signer = types.NewSethSigner(bc.chainConfig.ChainID)
+	bc.currentBlock = bc.genesisBlock
+	if head := ReadHeadBlockHash(db); head != (common.Hash{}) {
+		if number, ok := GetBlockNumber(db, head); ok {
+			if block := bc.GetBlock(head, number); block != nil {
+				bc.currentBlock = block
+			}
+		}
+	}
+	if err := bc.repair(); err != nil {
+		return nil, err
+	}
 	return bc, nil
 }
 
+// repair rewinds the head to the newest block whose state is on disk,the
+// recent state of full gc mode is lost if the node is not stopped cleanly
+func (bc *BlockChain) repair() error {
+	var (
+		head    = bc.currentBlock
+		rewound []*types.Block
+	)
+	for !bc.hasState(head.Header.Root) {
+		if head.NumberU64() == 0 {
+			return ErrNoGenesis
+		}
+		parent := bc.GetBlock(head.Header.ParentHash, head.NumberU64()-1)
+		if parent == nil {
+			return consensus.ErrUnknownAncestor
+		}
+		rewound = append(rewound, head)
+		head = parent
+	}
+	if len(rewound) == 0 {
+		return nil
+	}
+	log.Warn("rewind chain head from block %d to %d,state is missing", bc.currentBlock.NumberU64(), head.NumberU64())
+
+	batch := bc.db.NewBatch()
+	for _, block := range rewound {
+		DeleteCanonicalHash(batch, block.NumberU64())
+		for _, tx := range block.Transactions() {
+			DeleteTxLookupEntry(batch, tx.Hash())
+		}
+	}
+	WriteHeadBlockHash(batch, head.Hash())
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	bc.currentBlock = head
+	return nil
+}
+
+// hasState returns whether the state of root is available
+func (bc *BlockChain) hasState(root common.Hash) bool {
+	_, err := openState(bc.chainConfig, root, bc.triedb)
+	return err == nil
+}
+
+// Config return the chain config of block chain
+func (bc *BlockChain) Config() *config.ChainConfig {
+	return bc.chainConfig
+}
+
+// Engine return the consensus engine of block chain
+func (bc *BlockChain) Engine() consensus.Engine {
+	return bc.engine
+}
+
+// CurrentBlock return the head block of canonical chain
+func (bc *BlockChain) CurrentBlock() *types.Block {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+	return bc.currentBlock
+}
+
+// StateAt return the state of root,the state is not written to database until
+// the block is inserted
+func (bc *BlockChain) StateAt(root common.Hash) (*state.Statedb, error) {
+	return openState(bc.chainConfig, root, bc.triedb)
+}
+
+// openState opens the state of root on the trie determined by the chain
+// config,the choice of trie is a consensus rule since it changes the root
+func openState(cfg *config.ChainConfig, root common.Hash, db *trie.NodeDatabase) (*state.Statedb, error) {
+	if cfg != nil && cfg.SecureTrie {
+		return state.NewSecureStatedb(root, db)
+	}
+	return state.NewStatedb(root, db)
+}
+
 // GetBlockByNumber get block by number
 func (bc *BlockChain) GetBlockByNumber(number uint64) *types.Block {
 	hash := GetCanonicalHash(bc.db, number)
@@ -49,6 +200,23 @@ func (bc *BlockChain) GetBlockByNumber(number uint64) *types.Block {
 	return bc.GetBlock(hash, number)
 }
 
+// GetHeader get block header by hash & number
+func (bc *BlockChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if block, ok := bc.blockCache.Get(hash); ok {
+		return block.(*types.Block).Header
+	}
+	return GetHeader(bc.db, hash, number)
+}
+
+// GetHeaderByNumber get block header of canonical chain by number
+func (bc *BlockChain) GetHeaderByNumber(number uint64) *types.Header {
+	hash := GetCanonicalHash(bc.db, number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return bc.GetHeader(hash, number)
+}
+
 // GetBlock get block by hash & number
 func (bc *BlockChain) GetBlock(hash common.Hash, number uint64) *types.Block {
 	if block, ok := bc.blockCache.Get(hash); ok {
@@ -62,3 +230,272 @@ func (bc *BlockChain) GetBlock(hash common.Hash, number uint64) *types.Block {
 	bc.blockCache.Add(block.Hash(), block)
 	return block
 }
+
+// GetTransactionByHash get the transaction of canonical chain with its block
+// hash,block number and index,the transaction is nil if not found
+func (bc *BlockChain) GetTransactionByHash(hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64) {
+	blockHash, blockNumber, index := GetTxLookupEntry(bc.db, hash)
+	if blockHash == (common.Hash{}) {
+		return nil, common.Hash{}, 0, 0
+	}
+	block := bc.GetBlock(blockHash, blockNumber)
+	if block == nil || uint64(len(block.Transactions())) <= index {
+		return nil, common.Hash{}, 0, 0
+	}
+	return block.Transactions()[index], blockHash, blockNumber, index
+}
+
+// InsertChain validate and execute the blocks,then write them to database.
+// The block becomes the new head of canonical chain if the total difficulty
+// is higher than the current head,the canonical chain is reorganised if the
+// block is on a side chain. It returns the index of the failed block and the
+// error.
+func (bc *BlockChain) InsertChain(chain []*types.Block) (int, error) {
+	for i := 1; i < len(chain); i++ {
+		if chain[i].NumberU64() != chain[i-1].NumberU64()+1 || chain[i].Header.ParentHash != chain[i-1].Hash() {
+			return i, ErrNonContiguous
+		}
+	}
+
+	// the events are dispatched after the chain is unlocked,so that the
+	// listeners can access the chain
+	n, events, err := bc.insertChain(chain)
+	for _, e := range events {
+		bc.dispatcher.Dispatch(e)
+	}
+	return n, err
+}
+
+func (bc *BlockChain) insertChain(chain []*types.Block) (int, []event.Event, error) {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	var (
+		events []event.Event
+		head   *types.Block
+	)
+	for i, block := range chain {
+		status, dropped, err := bc.insertBlock(block)
+		if err != nil {
+			log.Error("insert block %d %s error: %v", block.NumberU64(), block.Hash().Hex(), err)
+			if head != nil {
+				events = append(events, event.NewParamsEvent(event.EventChainHead).SetParam("block", head))
+			}
+			return i, events, err
+		}
+		if len(dropped) > 0 {
+			events = append(events, event.NewParamsEvent(event.EventTxsDropped).SetParam("txs", dropped))
+		}
+		switch status {
+		case canonStatus:
+			head = block
+		case sideStatus:
+			events = append(events, event.NewParamsEvent(event.EventChainSide).SetParam("block", block))
+		}
+	}
+	if head != nil {
+		events = append(events, event.NewParamsEvent(event.EventChainHead).SetParam("block", head))
+	}
+	return len(chain), events, nil
+}
+
+// validateHeader checks the header against its parent with the rules every
+// engine shares
+func validateHeader(header, parent *types.Header) error {
+	if header.Time == nil || header.Time.Cmp(parent.Time) <= 0 {
+		return ErrInvalidTimestamp
+	}
+	if len(header.Extra) > MaximumExtraDataSize {
+		return ErrExtraDataTooLong
+	}
+	if header.GasUsed > header.GasLimit {
+		return ErrGasUsedExceedsLimit
+	}
+	return nil
+}
+
+// writeStatus status of the inserted block
+type writeStatus int
+
+const (
+	nonStatus   writeStatus = iota // the block is not written
+	canonStatus                    // the block is the new head
+	sideStatus                     // the block is written to a side chain
+	knownStatus                    // the block is known and skipped
+)
+
+// insertBlock validate,execute and write the block,it returns the status of
+// the block and the transactions dropped by reorganisation
+func (bc *BlockChain) insertBlock(block *types.Block) (writeStatus, types.Transactions, error) {
+	header := block.Header
+	if header.Number == nil || header.Number.Sign() <= 0 {
+		return nonStatus, nil, consensus.ErrInvalidNumber
+	}
+	if GetHeader(bc.db, block.Hash(), block.NumberU64()) != nil {
+		// known block,the ones above head whose state is lost are imported again
+		if block.NumberU64() <= bc.currentBlock.NumberU64() || bc.hasState(header.Root) {
+			return knownStatus, nil, nil
+		}
+	}
+	parent := GetHeader(bc.db, header.ParentHash, block.NumberU64()-1)
+	if parent == nil {
+		return nonStatus, nil, consensus.ErrUnknownAncestor
+	}
+	if err := validateHeader(header, parent); err != nil {
+		return nonStatus, nil, err
+	}
+	if err := bc.engine.VerifyHeader(bc, header, true); err != nil {
+		return nonStatus, nil, err
+	}
+	if types.DeriveSha(block.Transactions()) != header.TxHash {
+		return nonStatus, nil, ErrInvalidTxHash
+	}
+
+	statedb, err := openState(bc.chainConfig, parent.Root, bc.triedb)
+	if err != nil {
+		return nonStatus, nil, err
+	}
+	// the fees are credited to the account sealing the block,the coinbase is
+	// the vote target of some engines
+	author, err := bc.engine.Author(header)
+	if err != nil {
+		return nonStatus, nil, err
+	}
+	receipts, err := ApplyBlock(statedb, bc.signer, &author, block)
+	if err != nil {
+		return nonStatus, nil, err
+	}
+
+	ptd := GetTd(bc.db, header.ParentHash, parent.Number.Uint64())
+	if ptd == nil {
+		return nonStatus, nil, consensus.ErrUnknownAncestor
+	}
+	externTd := new(big.Int).Add(ptd, header.Difficulty)
+	localTd := GetTd(bc.db, bc.currentBlock.Hash(), bc.currentBlock.NumberU64())
+	batch := bc.db.NewBatch()
+	if bc.pruner != nil {
+		var root common.Hash
+		if root, err = bc.pruner.Commit(statedb); err == nil && block.NumberU64()%stateFlushInterval == 0 {
+			err = bc.pruner.Persist(root)
+		}
+	} else {
+		_, err = statedb.Commit(batch)
+	}
+	if err != nil {
+		batch.Rollback()
+		return nonStatus, nil, err
+	}
+	if err := WriteTd(batch, block.Hash(), block.NumberU64(), externTd); err != nil {
+		batch.Rollback()
+		return nonStatus, nil, err
+	}
+	if err := WriteBlock(batch, block); err != nil {
+		batch.Rollback()
+		return nonStatus, nil, err
+	}
+	if err := WriteReceipts(batch, block.Hash(), block.NumberU64(), receipts); err != nil {
+		batch.Rollback()
+		return nonStatus, nil, err
+	}
+
+	// the block of side chain is kept unless its total difficulty is higher
+	if localTd != nil && externTd.Cmp(localTd) <= 0 {
+		if err := batch.Commit(); err != nil {
+			return nonStatus, nil, err
+		}
+		bc.blockCache.Add(block.Hash(), block)
+		return sideStatus, nil, nil
+	}
+	var dropped types.Transactions
+	if header.ParentHash != bc.currentBlock.Hash() {
+		if dropped, err = bc.reorg(batch, bc.currentBlock, block); err != nil {
+			batch.Rollback()
+			return nonStatus, nil, err
+		}
+	}
+	if err := WriteTxLookupEntries(batch, block); err != nil {
+		batch.Rollback()
+		return nonStatus, nil, err
+	}
+	WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+	WriteHeadBlockHash(batch, block.Hash())
+	if err := batch.Commit(); err != nil {
+		return nonStatus, nil, err
+	}
+
+	bc.currentBlock = block
+	bc.blockCache.Add(block.Hash(), block)
+	return canonStatus, dropped, nil
+}
+
+// reorg rewrite the canonical chain from the common ancestor of the old head
+// and the new head to the new head,it returns the transactions which are in
+// the old chain but not in the new chain
+func (bc *BlockChain) reorg(batch database.Batch, oldHead, newHead *types.Block) (types.Transactions, error) {
+	var (
+		oldChain  []*types.Block
+		newChain  []*types.Block
+		oldBlock  = oldHead
+		newBlock  = newHead
+		getParent = func(block *types.Block) *types.Block {
+			return bc.GetBlock(block.Header.ParentHash, block.NumberU64()-1)
+		}
+	)
+	// reduce the longer chain to the same number as the shorter one
+	for ; oldBlock != nil && oldBlock.NumberU64() > newBlock.NumberU64(); oldBlock = getParent(oldBlock) {
+		oldChain = append(oldChain, oldBlock)
+	}
+	for ; oldBlock != nil && newBlock != nil && newBlock.NumberU64() > oldBlock.NumberU64(); newBlock = getParent(newBlock) {
+		newChain = append(newChain, newBlock)
+	}
+	// step back both chains until the common ancestor is found
+	for oldBlock != nil && newBlock != nil && oldBlock.Hash() != newBlock.Hash() {
+		oldChain = append(oldChain, oldBlock)
+		newChain = append(newChain, newBlock)
+		oldBlock, newBlock = getParent(oldBlock), getParent(newBlock)
+	}
+	if oldBlock == nil || newBlock == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	log.Info("chain reorg at block %d %s,drop %d blocks,add %d blocks", oldBlock.NumberU64(), oldBlock.Hash().Hex(), len(oldChain), len(newChain))
+
+	// the canonical hash and lookup entries of the new head are written by the caller
+	for i := 1; i < len(newChain); i++ {
+		WriteCanonicalHash(batch, newChain[i].Hash(), newChain[i].NumberU64())
+		if err := WriteTxLookupEntries(batch, newChain[i]); err != nil {
+			return nil, err
+		}
+	}
+	for number := newHead.NumberU64() + 1; number <= oldHead.NumberU64(); number++ {
+		DeleteCanonicalHash(batch, number)
+	}
+
+	included := make(map[common.Hash]struct{})
+	for _, block := range newChain {
+		for _, tx := range block.Transactions() {
+			included[tx.Hash()] = struct{}{}
+		}
+	}
+	var dropped types.Transactions
+	for i := len(oldChain) - 1; i >= 0; i-- {
+		for _, tx := range oldChain[i].Transactions() {
+			if _, ok := included[tx.Hash()]; !ok {
+				dropped = append(dropped, tx)
+				DeleteTxLookupEntry(batch, tx.Hash())
+			}
+		}
+	}
+	return dropped, nil
+}
+
+// Stop write the retained state in memory to database,it must be called on
+// shutdown otherwise the head is rewound to the last flushed state on restart
+func (bc *BlockChain) Stop() error {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	if bc.pruner == nil {
+		return nil
+	}
+	return bc.pruner.Flush()
+}
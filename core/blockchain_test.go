@@ -0,0 +1,436 @@
+package core
+
+import (
+	"math/big"
+	"seth/accounts"
+	"seth/common"
+	"seth/config"
+	"seth/consensus"
+	"seth/consensus/pow"
+	"seth/core/state"
+	"seth/core/types"
+	"seth/crypto"
+	"seth/database"
+	"seth/event"
+	"seth/trie"
+	"testing"
+)
+
+var (
+	testChainConfig = &config.ChainConfig{ChainID: big.NewInt(1)}
+	testSigner      = types.NewSethSigner(testChainConfig.ChainID)
+	testEngine      = pow.NewFaker()
+)
+
+// newTestBlockChain commit the genesis block with the alloc state and return
+// the block chain on it
+func newTestBlockChain(t *testing.T, db database.Database, alloc map[common.Address]*big.Int) *BlockChain {
+	genesis := &Genesis{Config: testChainConfig, Difficulty: big.NewInt(1), GasLimit: GenesisGasLimit, Alloc: GenesisAlloc{}}
+	for addr, amount := range alloc {
+		genesis.Alloc[addr] = GenesisAccount{Balance: amount}
+	}
+	if _, err := genesis.Commit(db); err != nil {
+		t.Fatalf("Failed to commit genesis: %v", err)
+	}
+
+	bc, err := NewBlockChain(db, testEngine)
+	if err != nil {
+		t.Fatalf("Failed to new block chain: %v", err)
+	}
+	return bc
+}
+
+// makeTestBlock make a valid block on parent with the transactions,the state
+// of block is kept in memory of gen so that blocks can be made on it
+func makeTestBlock(t *testing.T, gen *trie.NodeDatabase, parent *types.Block, txs []*types.Transaction) *types.Block {
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Coinbase:   common.BytesToAddress([]byte("coinbase")),
+		Number:     new(big.Int).Add(parent.Header.Number, common.Big1),
+		Time:       new(big.Int).Add(parent.Header.Time, big.NewInt(10)),
+		GasLimit:   parent.Header.GasLimit,
+	}
+	header.Difficulty = testEngine.CalcDifficulty(nil, header.Time.Uint64(), parent.Header)
+	statedb, err := state.NewStatedb(parent.Header.Root, gen)
+	if err != nil {
+		t.Fatalf("Failed to open state of parent: %v", err)
+	}
+	receipts := types.Receipts{}
+	for _, tx := range txs {
+		receipt, err := ApplyTransaction(statedb, testSigner, nil, header, tx, &header.GasUsed)
+		if err != nil {
+			t.Fatalf("Failed to apply tx: %v", err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	block, err := testEngine.Finalize(nil, header, statedb, txs, receipts)
+	if err != nil {
+		t.Fatalf("Failed to finalize block: %v", err)
+	}
+	statedb.Commit(nil)
+	return block
+}
+
+func newTestTransfer(t *testing.T, key *crypto.PrivateKey, to common.Address, nonce uint64) *types.Transaction {
+	tx := types.NewTransaction(to, big.NewInt(10), nonce, TxGas, big.NewInt(1))
+	if err := tx.Sign(testSigner, key); err != nil {
+		t.Fatalf("Failed to sign tx: %v", err)
+	}
+	return tx
+}
+
+func Test_BlockChain_InsertChain(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(1000000)})
+	gen := trie.NewNodeDatabase(db)
+
+	blocks := []*types.Block{}
+	parent := bc.CurrentBlock()
+	for i := uint64(0); i < 3; i++ {
+		block := makeTestBlock(t, gen, parent, []*types.Transaction{newTestTransfer(t, key, to, i)})
+		blocks = append(blocks, block)
+		parent = block
+	}
+	if n, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("Failed to insert block %d: %v", n, err)
+	}
+	head := bc.CurrentBlock()
+	if head.Hash() != blocks[2].Hash() || ReadHeadBlockHash(db) != head.Hash() {
+		t.Fatalf("Error head block: %d", head.NumberU64())
+	}
+	for _, block := range blocks {
+		if GetCanonicalHash(db, block.NumberU64()) != block.Hash() {
+			t.Fatalf("Error canonical hash of block %d", block.NumberU64())
+		}
+		if receipts := GetReceipts(db, block.Hash(), block.NumberU64()); len(receipts) != 1 {
+			t.Fatalf("Error receipts of block %d", block.NumberU64())
+		}
+	}
+	expectTd := new(big.Int).Add(common.Big1, new(big.Int).Mul(pow.MinimumDifficulty, big.NewInt(3)))
+	if td := GetTd(db, head.Hash(), head.NumberU64()); td == nil || td.Cmp(expectTd) != 0 {
+		t.Fatalf("Error total difficulty of head: %v", td)
+	}
+	statedb, err := state.NewStatedb(head.Header.Root, bc.triedb)
+	if err != nil {
+		t.Fatalf("Failed to open state of head: %v", err)
+	}
+	if amount := statedb.GetAmount(to); amount.Cmp(big.NewInt(30)) != 0 {
+		t.Fatalf("Error amount of recipient: %v", amount)
+	}
+
+	// known blocks are skipped
+	if _, err := bc.InsertChain(blocks[1:]); err != nil {
+		t.Fatalf("Failed to insert known blocks: %v", err)
+	}
+
+	// the head and state are restored after restart
+	if err := bc.Stop(); err != nil {
+		t.Fatalf("Failed to stop block chain: %v", err)
+	}
+	bc, _ = NewBlockChain(db, testEngine)
+	if bc.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("Error head block after restart: %d", bc.CurrentBlock().NumberU64())
+	}
+	block := makeTestBlock(t, gen, head, []*types.Transaction{newTestTransfer(t, key, to, 3)})
+	if _, err := bc.InsertChain([]*types.Block{block}); err != nil {
+		t.Fatalf("Failed to insert block after restart: %v", err)
+	}
+}
+
+func Test_BlockChain_Repair(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(1000000)})
+	gen := trie.NewNodeDatabase(db)
+
+	blocks := []*types.Block{}
+	parent := bc.CurrentBlock()
+	for i := uint64(0); i < 3; i++ {
+		block := makeTestBlock(t, gen, parent, []*types.Transaction{newTestTransfer(t, key, to, i)})
+		blocks = append(blocks, block)
+		parent = block
+	}
+	if n, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("Failed to insert block %d: %v", n, err)
+	}
+
+	// the state in memory is lost without stop,the head is rewound to genesis
+	genesis := bc.genesisBlock
+	bc, err := NewBlockChain(db, testEngine)
+	if err != nil {
+		t.Fatalf("Failed to new block chain: %v", err)
+	}
+	if bc.CurrentBlock().Hash() != genesis.Hash() || ReadHeadBlockHash(db) != genesis.Hash() {
+		t.Fatalf("Error head block after repair: %d", bc.CurrentBlock().NumberU64())
+	}
+	if GetCanonicalHash(db, 1) != (common.Hash{}) {
+		t.Fatalf("Error canonical hash above repaired head")
+	}
+	if tx, _, _, _ := bc.GetTransactionByHash(blocks[0].Transactions()[0].Hash()); tx != nil {
+		t.Fatalf("Error: transaction lookup of rewound block should be deleted")
+	}
+
+	// the blocks above head are imported again
+	if n, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("Failed to insert block %d after repair: %v", n, err)
+	}
+	if bc.CurrentBlock().Hash() != blocks[2].Hash() {
+		t.Fatalf("Error head block after import: %d", bc.CurrentBlock().NumberU64())
+	}
+
+	// the state is pruned from the head block,a canonical hash left above it
+	// is ignored
+	if err := bc.Stop(); err != nil {
+		t.Fatalf("Failed to stop block chain: %v", err)
+	}
+	batch := db.NewBatch()
+	WriteCanonicalHash(batch, common.Hash{1}, 4)
+	batch.Commit()
+	if _, err := PruneState(db, 2); err != nil {
+		t.Fatalf("Failed to prune state: %v", err)
+	}
+	for _, block := range blocks[1:] {
+		if _, err := state.NewStatedb(block.Header.Root, trie.NewNodeDatabase(db)); err != nil {
+			t.Fatalf("Failed to open retained state of block %d: %v", block.NumberU64(), err)
+		}
+	}
+}
+
+func Test_BlockChain_InsertInvalid(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(1000000)})
+	gen := trie.NewNodeDatabase(db)
+	genesis := bc.CurrentBlock()
+	valid := makeTestBlock(t, gen, genesis, []*types.Transaction{newTestTransfer(t, key, to, 0)})
+
+	modify := func(fn func(header *types.Header)) *types.Block {
+		header := valid.Header.Clone()
+		fn(header)
+		return types.NewBlock(header, valid.Transactions())
+	}
+	tests := []struct {
+		block *types.Block
+		err   error
+	}{
+		{modify(func(h *types.Header) { h.ParentHash = common.Hash{} }), consensus.ErrUnknownAncestor},
+		{modify(func(h *types.Header) { h.Time = new(big.Int).Set(genesis.Header.Time) }), ErrInvalidTimestamp},
+		{modify(func(h *types.Header) { h.Extra = make([]byte, MaximumExtraDataSize+1) }), ErrExtraDataTooLong},
+		{modify(func(h *types.Header) { h.GasUsed = h.GasLimit + 1 }), ErrGasUsedExceedsLimit},
+		{modify(func(h *types.Header) { h.Extra = make([]byte, pow.MaximumExtraDataSize+1) }), pow.ErrExtraDataTooLong},
+		{modify(func(h *types.Header) { h.Root = common.Hash{} }), ErrInvalidStateRoot},
+		{modify(func(h *types.Header) { h.GasUsed = 0 }), ErrInvalidGasUsed},
+		{types.NewBlockWithHeader(valid.Header).WithBody(nil), ErrInvalidTxHash},
+		{makeTestBlock(t, gen, valid, nil), consensus.ErrUnknownAncestor},
+	}
+	for i, test := range tests {
+		if _, err := bc.InsertChain([]*types.Block{test.block}); err != test.err {
+			t.Fatalf("Error result of block %d: %v,want %v", i, err, test.err)
+		}
+	}
+	if bc.CurrentBlock().Hash() != genesis.Hash() {
+		t.Fatalf("Error: invalid blocks should not change the head")
+	}
+
+	// the chain checks the header even if the engine checks nothing
+	bc.engine = pow.NewFullFaker()
+	for i, test := range tests[1:4] {
+		if _, err := bc.InsertChain([]*types.Block{test.block}); err == nil {
+			t.Fatalf("Error: block %d inserted without verification of engine", i+1)
+		}
+	}
+	bc.engine = testEngine
+
+	next := makeTestBlock(t, gen, valid, nil)
+	if n, err := bc.InsertChain([]*types.Block{next, valid}); n != 1 || err != ErrNonContiguous {
+		t.Fatalf("Error result of non contiguous blocks: %d,%v", n, err)
+	}
+	if _, err := bc.InsertChain([]*types.Block{valid, next}); err != nil {
+		t.Fatalf("Failed to insert blocks: %v", err)
+	}
+}
+
+func Test_BlockChain_Reorg(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(1000000)})
+	// the difficulty of blocks is raised to reorganise the chain
+	bc.engine = pow.NewFullFaker()
+	gen := trie.NewNodeDatabase(db)
+	genesis := bc.CurrentBlock()
+
+	dispatcher := event.SharedDispatcher()
+	defer dispatcher.RemoveAll(event.EventChainHead)
+	defer dispatcher.RemoveAll(event.EventChainSide)
+	defer dispatcher.RemoveAll(event.EventTxsDropped)
+	pool := NewTxPool(&DefaultTxPoolConfig, bc)
+	defer pool.Stop()
+	heads, sides := []*types.Block{}, []*types.Block{}
+	dispatcher.AddListener(event.EventChainHead, event.Listener{Callable: func(e event.Event) {
+		block, _ := e.(*event.ParamsEvent).GetParam("block")
+		heads = append(heads, block.(*types.Block))
+	}})
+	dispatcher.AddListener(event.EventChainSide, event.Listener{Callable: func(e event.Event) {
+		block, _ := e.(*event.ParamsEvent).GetParam("block")
+		sides = append(sides, block.(*types.Block))
+	}})
+
+	tx0, tx1 := newTestTransfer(t, key, to, 0), newTestTransfer(t, key, to, 1)
+	a1 := makeTestBlock(t, gen, genesis, []*types.Transaction{tx0})
+	a2 := makeTestBlock(t, gen, a1, []*types.Transaction{tx1})
+	if _, err := bc.InsertChain([]*types.Block{a1, a2}); err != nil {
+		t.Fatalf("Failed to insert chain a: %v", err)
+	}
+	if len(heads) != 1 || heads[0].Hash() != a2.Hash() {
+		t.Fatalf("Error chain head events: %v", heads)
+	}
+
+	// side chain with lower or equal total difficulty
+	b1 := makeTestBlock(t, gen, genesis, nil)
+	b2 := makeTestBlock(t, gen, b1, []*types.Transaction{tx0})
+	b3 := makeTestBlock(t, gen, b2, nil)
+	if _, err := bc.InsertChain([]*types.Block{b1, b2}); err != nil {
+		t.Fatalf("Failed to insert chain b: %v", err)
+	}
+	if bc.CurrentBlock().Hash() != a2.Hash() || len(sides) != 2 {
+		t.Fatalf("Error: side chain should not change the head")
+	}
+	// known blocks dispatch no event
+	if _, err := bc.InsertChain([]*types.Block{a1, a2}); err != nil {
+		t.Fatalf("Failed to insert known blocks: %v", err)
+	}
+	if len(heads) != 1 || len(sides) != 2 {
+		t.Fatalf("Error events of known blocks: %d heads,%d sides", len(heads), len(sides))
+	}
+
+	// side chain with higher total difficulty becomes canonical
+	if _, err := bc.InsertChain([]*types.Block{b3}); err != nil {
+		t.Fatalf("Failed to insert chain b: %v", err)
+	}
+	if bc.CurrentBlock().Hash() != b3.Hash() || heads[len(heads)-1].Hash() != b3.Hash() {
+		t.Fatalf("Error head after reorg: %d", bc.CurrentBlock().NumberU64())
+	}
+	for _, block := range []*types.Block{genesis, b1, b2, b3} {
+		if GetCanonicalHash(db, block.NumberU64()) != block.Hash() {
+			t.Fatalf("Error canonical hash of block %d after reorg", block.NumberU64())
+		}
+	}
+	if len(pool.all) != 1 || pool.pending[from] == nil || pool.pending[from].Get(1) != tx1 {
+		t.Fatalf("Error: dropped transaction should be re-added to pool")
+	}
+
+	// reorg back to chain a with higher total difficulty
+	a3 := makeTestBlock(t, gen, a2, nil)
+	a3.Header.Difficulty.Mul(a3.Header.Difficulty, big.NewInt(3))
+	a3 = types.NewBlock(a3.Header, nil)
+	if _, err := bc.InsertChain([]*types.Block{a3}); err != nil {
+		t.Fatalf("Failed to insert chain a: %v", err)
+	}
+	if bc.CurrentBlock().Hash() != a3.Hash() || GetCanonicalHash(db, 3) != a3.Hash() {
+		t.Fatalf("Error head after reorg back: %d", bc.CurrentBlock().NumberU64())
+	}
+	if GetCanonicalHash(db, 1) != a1.Hash() || GetCanonicalHash(db, 2) != a2.Hash() {
+		t.Fatalf("Error canonical hash after reorg back")
+	}
+
+	b4 := makeTestBlock(t, gen, b3, nil)
+	b5 := makeTestBlock(t, gen, b4, nil)
+	b5.Header.Difficulty.Mul(b5.Header.Difficulty, big.NewInt(3))
+	b5 = types.NewBlock(b5.Header, nil)
+	if _, err := bc.InsertChain([]*types.Block{b4, b5}); err != nil {
+		t.Fatalf("Failed to insert chain b: %v", err)
+	}
+	if bc.CurrentBlock().Hash() != b5.Hash() || GetCanonicalHash(db, 5) != b5.Hash() {
+		t.Fatalf("Error head after reorg: %d", bc.CurrentBlock().NumberU64())
+	}
+
+	// reorg to a shorter chain with higher total difficulty
+	a4 := makeTestBlock(t, gen, a3, nil)
+	a4.Header.Difficulty.Mul(a4.Header.Difficulty, big.NewInt(10))
+	a4 = types.NewBlock(a4.Header, nil)
+	if _, err := bc.InsertChain([]*types.Block{a4}); err != nil {
+		t.Fatalf("Failed to insert chain a: %v", err)
+	}
+	if bc.CurrentBlock().Hash() != a4.Hash() || GetCanonicalHash(db, 4) != a4.Hash() {
+		t.Fatalf("Error head after reorg to shorter chain: %d", bc.CurrentBlock().NumberU64())
+	}
+	if GetCanonicalHash(db, 5) != (common.Hash{}) {
+		t.Fatalf("Error: canonical hash above the head should be deleted")
+	}
+}
+
+func Test_BlockChain_GetTransaction(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+	defer event.SharedDispatcher().RemoveAll(event.EventTxsDropped)
+
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(1000000)})
+	// the difficulty of blocks is raised to reorganise the chain
+	bc.engine = pow.NewFullFaker()
+	gen := trie.NewNodeDatabase(db)
+	genesis := bc.CurrentBlock()
+
+	tx0, tx1, tx2 := newTestTransfer(t, key, to, 0), newTestTransfer(t, key, to, 1), newTestTransfer(t, key, to, 2)
+	a1 := makeTestBlock(t, gen, genesis, []*types.Transaction{tx0, tx1})
+	if _, err := bc.InsertChain([]*types.Block{a1}); err != nil {
+		t.Fatalf("Failed to insert chain: %v", err)
+	}
+	tx, blockHash, number, index := bc.GetTransactionByHash(tx1.Hash())
+	if tx == nil || tx.Hash() != tx1.Hash() || blockHash != a1.Hash() || number != 1 || index != 1 {
+		t.Fatalf("Error transaction lookup: %v,%x,%d,%d", tx, blockHash, number, index)
+	}
+	if tx, _, _, _ := GetTransaction(db, tx0.Hash()); tx == nil || tx.Hash() != tx0.Hash() {
+		t.Fatalf("Error transaction lookup from database")
+	}
+
+	// side chain transactions are not indexed until it becomes canonical
+	b1 := makeTestBlock(t, gen, genesis, []*types.Transaction{tx0})
+	b2 := makeTestBlock(t, gen, b1, []*types.Transaction{tx1, tx2})
+	if _, err := bc.InsertChain([]*types.Block{b1}); err != nil {
+		t.Fatalf("Failed to insert side chain: %v", err)
+	}
+	if _, blockHash, _, _ := bc.GetTransactionByHash(tx0.Hash()); blockHash != a1.Hash() {
+		t.Fatalf("Error: side chain transaction should not be indexed")
+	}
+	if _, err := bc.InsertChain([]*types.Block{b2}); err != nil {
+		t.Fatalf("Failed to insert side chain: %v", err)
+	}
+	for _, test := range []struct {
+		tx    *types.Transaction
+		block *types.Block
+		index uint64
+	}{{tx0, b1, 0}, {tx1, b2, 0}, {tx2, b2, 1}} {
+		_, blockHash, number, index := bc.GetTransactionByHash(test.tx.Hash())
+		if blockHash != test.block.Hash() || number != test.block.NumberU64() || index != test.index {
+			t.Fatalf("Error transaction lookup after reorg: %x,%d,%d", blockHash, number, index)
+		}
+	}
+
+	// the lookup entries of dropped transactions are removed
+	c1 := makeTestBlock(t, gen, genesis, nil)
+	c1.Header.Difficulty.Mul(c1.Header.Difficulty, big.NewInt(10))
+	c1 = types.NewBlock(c1.Header, nil)
+	if _, err := bc.InsertChain([]*types.Block{c1}); err != nil {
+		t.Fatalf("Failed to insert chain: %v", err)
+	}
+	for _, hash := range []common.Hash{tx0.Hash(), tx1.Hash(), tx2.Hash()} {
+		if tx, _, _, _ := bc.GetTransactionByHash(hash); tx != nil {
+			t.Fatalf("Error: dropped transaction %x should not be found", hash)
+		}
+	}
+}
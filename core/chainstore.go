@@ -3,8 +3,10 @@ package core
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"math/big"
 	"seth/common"
+	"seth/config"
 	"seth/core/types"
 	"seth/database"
 	"seth/log"
@@ -20,10 +22,19 @@ var (
 	numSuffix       = []byte("n") // headerPrefix + num (uint64 big endian) + numSuffix -> hash
 	blockHashPrefix = []byte("H") // blockHashPrefix + hash -> num (uint64 big endian)
 	bodyPrefix      = []byte("b") // bodyPrefix + num (uint64 big endian) + hash -> block body
+	receiptsPrefix  = []byte("r") // receiptsPrefix + num (uint64 big endian) + hash -> block receipts
+	txLookupPrefix  = []byte("l") // txLookupPrefix + hash -> transaction lookup entry
 
 	configPrefix = []byte("seth-config-") // config prefix for the db
 )
 
+// txLookupEntry is the position of a transaction in canonical chain
+type txLookupEntry struct {
+	BlockHash  common.Hash
+	BlockIndex uint64
+	Index      uint64
+}
+
 // encodeBlockNumber encodes a block number as big endian uint64
 func encodeBlockNumber(number uint64) []byte {
 	enc := make([]byte, 8)
@@ -46,6 +57,26 @@ func WriteCanonicalHash(batch database.Batch, hash common.Hash, number uint64) {
 	batch.Put(key, hash.Bytes())
 }
 
+// GetTd retrieves the total difficulty of block,nil if not found
+func GetTd(db database.Database, hash common.Hash, number uint64) *big.Int {
+	key := append(append(append(headerPrefix, encodeBlockNumber(number)...), hash.Bytes()...), tdSuffix...)
+	data, _ := db.Get(key)
+	if len(data) == 0 {
+		return nil
+	}
+	td := new(big.Int)
+	if err := rlp.DecodeBytes(data, td); err != nil {
+		log.Error("Invalid block total difficulty RLP of block %s: %v", hash.Hex(), err)
+		return nil
+	}
+	return td
+}
+
+// DeleteCanonicalHash delete the canonical hash of block number
+func DeleteCanonicalHash(batch database.Batch, number uint64) {
+	batch.Delete(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
+}
+
 // WriteTd write total difficulty of block
 func WriteTd(batch database.Batch, hash common.Hash, number uint64, td *big.Int) error {
 	data, err := rlp.EncodeToBytes(td)
@@ -78,6 +109,44 @@ func WriteBody(batch database.Batch, hash common.Hash, number uint64, body *type
 	return nil
 }
 
+// WriteReceipts write the receipts of block
+func WriteReceipts(batch database.Batch, hash common.Hash, number uint64, receipts types.Receipts) error {
+	storageReceipts := make([]*types.ReceiptForStorage, len(receipts))
+	for i, receipt := range receipts {
+		storageReceipts[i] = (*types.ReceiptForStorage)(receipt)
+	}
+	data, err := rlp.EncodeToBytes(storageReceipts)
+	if err != nil {
+		return err
+	}
+
+	key := append(append(receiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+	batch.Put(key, data)
+	return nil
+}
+
+// WriteTxLookupEntries write the lookup entries of the transactions of block
+func WriteTxLookupEntries(batch database.Batch, block *types.Block) error {
+	for i, tx := range block.Transactions() {
+		entry := txLookupEntry{
+			BlockHash:  block.Hash(),
+			BlockIndex: block.NumberU64(),
+			Index:      uint64(i),
+		}
+		data, err := rlp.EncodeToBytes(entry)
+		if err != nil {
+			return err
+		}
+		batch.Put(append(txLookupPrefix, tx.Hash().Bytes()...), data)
+	}
+	return nil
+}
+
+// DeleteTxLookupEntry delete the lookup entry of transaction
+func DeleteTxLookupEntry(batch database.Batch, hash common.Hash) {
+	batch.Delete(append(txLookupPrefix, hash.Bytes()...))
+}
+
 // WriteHeader write block header
 func WriteHeader(batch database.Batch, header *types.Header) error {
 	data, err := rlp.EncodeToBytes(header)
@@ -96,14 +165,52 @@ func WriteHeader(batch database.Batch, header *types.Header) error {
 	return nil
 }
 
+// GetBlockNumber retrieves the number of block with hash
+func GetBlockNumber(db database.Database, hash common.Hash) (uint64, bool) {
+	data, _ := db.Get(append(blockHashPrefix, hash.Bytes()...))
+	if len(data) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(data), true
+}
+
+// ReadHeadBlockHash retrieves the hash of the head block of canonical chain
+func ReadHeadBlockHash(db database.Database) common.Hash {
+	data, _ := db.Get(headBlockKey)
+	if len(data) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
 // WriteHeadBlockHash write last block hash
 func WriteHeadBlockHash(batch database.Batch, hash common.Hash) {
 	batch.Put(headBlockKey, hash.Bytes())
 }
 
-// WriteChainConfig write chain config to db
-func WriteChainConfig(batch database.Batch, hash common.Hash, jsoncfg []byte) {
-	batch.Put(append(configPrefix, hash[:]...), jsoncfg)
+// WriteChainConfig write chain config of genesis block to db
+func WriteChainConfig(batch database.Batch, hash common.Hash, cfg *config.ChainConfig) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	batch.Put(append(configPrefix, hash[:]...), data)
+	return nil
+}
+
+// ReadChainConfig retrieves the chain config of genesis block,nil is returned
+// if it is not found or invalid
+func ReadChainConfig(db database.Database, hash common.Hash) *config.ChainConfig {
+	data, _ := db.Get(append(configPrefix, hash[:]...))
+	if len(data) == 0 {
+		return nil
+	}
+	var cfg config.ChainConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Error("Invalid chain config JSON of genesis %s: %v", hash.Hex(), err)
+		return nil
+	}
+	return &cfg
 }
 
 // GetHeader get block by hash&block number
@@ -117,7 +224,11 @@ func GetBlock(db database.Database, hash common.Hash, number uint64) *types.Bloc
 	if body == nil {
 		return nil
 	}
-	return types.NewBlock(header, body.Transactions)
+	if txHash := types.DeriveSha(types.Transactions(body.Transactions)); txHash != header.TxHash {
+		log.Error("Invalid transactions root of block %s: %s,want %s", hash.Hex(), txHash.Hex(), header.TxHash.Hex())
+		return nil
+	}
+	return types.NewBlockWithHeader(header).WithBody(body.Transactions)
 }
 
 // GetHeader get block header by hash&block number
@@ -149,3 +260,64 @@ func GetBody(db database.Database, hash common.Hash, number uint64) *types.Body
 	}
 	return body
 }
+
+// GetReceipts get the receipts of block by hash&block number,the derived
+// fields of receipts and logs are filled in
+func GetReceipts(db database.Database, hash common.Hash, number uint64) types.Receipts {
+	key := append(append(receiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+	data, _ := db.Get(key)
+	if len(data) == 0 {
+		return nil
+	}
+	storageReceipts := []*types.ReceiptForStorage{}
+	if err := rlp.DecodeBytes(data, &storageReceipts); err != nil {
+		log.Error("Invalid receipts RLP of block %s: %v", hash.Hex(), err)
+		return nil
+	}
+	receipts := make(types.Receipts, len(storageReceipts))
+	logIndex := uint(0)
+	for i, receipt := range storageReceipts {
+		receipt.BlockHash = hash
+		receipt.BlockNumber = number
+		receipt.TransactionIndex = uint(i)
+		for _, log := range receipt.Logs {
+			log.BlockHash = hash
+			log.BlockNumber = number
+			log.TxIndex = uint(i)
+			log.Index = logIndex
+			logIndex++
+		}
+		receipts[i] = (*types.Receipt)(receipt)
+	}
+	return receipts
+}
+
+// GetTxLookupEntry get the block hash,block number and index of transaction
+// in canonical chain
+func GetTxLookupEntry(db database.Database, hash common.Hash) (common.Hash, uint64, uint64) {
+	data, _ := db.Get(append(txLookupPrefix, hash.Bytes()...))
+	if len(data) == 0 {
+		return common.Hash{}, 0, 0
+	}
+	var entry txLookupEntry
+	if err := rlp.DecodeBytes(data, &entry); err != nil {
+		log.Error("Invalid transaction lookup entry RLP of %s: %v", hash.Hex(), err)
+		return common.Hash{}, 0, 0
+	}
+	return entry.BlockHash, entry.BlockIndex, entry.Index
+}
+
+// GetTransaction get the transaction of canonical chain with its block hash,
+// block number and index,the transaction is nil if not found
+func GetTransaction(db database.Database, hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64) {
+	blockHash, blockNumber, index := GetTxLookupEntry(db, hash)
+	if blockHash == (common.Hash{}) {
+		return nil, common.Hash{}, 0, 0
+	}
+	body := GetBody(db, blockHash, blockNumber)
+	if body == nil || uint64(len(body.Transactions)) <= index {
+		log.Error("Transaction %s referenced missing block %s", hash.Hex(), blockHash.Hex())
+		return nil, common.Hash{}, 0, 0
+	}
+	return body.Transactions[index], blockHash, blockNumber, index
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"math/big"
+	"seth/accounts"
+	"seth/common"
+	"seth/core/types"
+	"testing"
+)
+
+func Test_Chainstore_Receipts(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	receipts := types.Receipts{}
+	for i := 0; i < 3; i++ {
+		receipt := types.NewReceipt(uint64(i+1) * TxGas)
+		receipt.TxHash = common.BytesToHash([]byte{byte(i + 1)})
+		receipt.GasUsed = TxGas
+		receipt.Logs = []*types.Log{{
+			Address: common.BytesToAddress([]byte{byte(i)}),
+			Topics:  []common.Hash{TransferLogTopic},
+			Data:    big.NewInt(int64(i)).Bytes(),
+		}}
+		receipt.Bloom = types.LogsBloom(receipt.Logs)
+		receipts = append(receipts, receipt)
+	}
+	hash := common.BytesToHash([]byte("block"))
+	batch := db.NewBatch()
+	if err := WriteReceipts(batch, hash, 1, receipts); err != nil {
+		t.Fatalf("Failed to write receipts: %v", err)
+	}
+	batch.Commit()
+
+	stored := GetReceipts(db, hash, 1)
+	if len(stored) != len(receipts) {
+		t.Fatalf("Error count of receipts: %d", len(stored))
+	}
+	for i, receipt := range stored {
+		if receipt.TxHash != receipts[i].TxHash || receipt.CumulativeGasUsed != receipts[i].CumulativeGasUsed ||
+			receipt.Bloom != receipts[i].Bloom || receipt.Status != types.ReceiptStatusSuccessful {
+			t.Fatalf("Error receipt %d: %+v", i, receipt)
+		}
+		if receipt.BlockHash != hash || receipt.BlockNumber != 1 || receipt.TransactionIndex != uint(i) {
+			t.Fatalf("Error derived fields of receipt %d: %+v", i, receipt)
+		}
+		log := receipt.Logs[0]
+		if log.Address != receipts[i].Logs[0].Address || log.Index != uint(i) || log.TxHash != receipt.TxHash {
+			t.Fatalf("Error log of receipt %d: %+v", i, log)
+		}
+	}
+	if GetReceipts(db, common.Hash{}, 1) != nil {
+		t.Fatalf("Error: receipts of unknown block should be nil")
+	}
+}
+
+func Test_Chainstore_BlockTxHash(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	_, key := accounts.NewRandomAccount()
+	signer := types.NewSethSigner(big.NewInt(1))
+	txs := []*types.Transaction{}
+	for i := uint64(0); i < 3; i++ {
+		tx := types.NewTransaction(common.BytesToAddress([]byte{1}), big.NewInt(10), i, TxGas, big.NewInt(1))
+		tx.Sign(signer, key)
+		txs = append(txs, tx)
+	}
+	block := types.NewBlock(&types.Header{Number: big.NewInt(1)}, txs)
+	if block.Header.TxHash != types.DeriveSha(types.Transactions(txs)) || block.Header.TxHash == types.EmptyRootHash {
+		t.Fatalf("Error transactions root of block: %x", block.Header.TxHash)
+	}
+	if empty := types.NewBlock(&types.Header{Number: big.NewInt(1)}, nil); empty.Header.TxHash != types.EmptyRootHash {
+		t.Fatalf("Error transactions root of empty block: %x", empty.Header.TxHash)
+	}
+
+	batch := db.NewBatch()
+	WriteBlock(batch, block)
+	batch.Commit()
+	stored := GetBlock(db, block.Hash(), 1)
+	if stored == nil || stored.Hash() != block.Hash() || len(stored.Transactions()) != len(txs) {
+		t.Fatalf("Failed to get block")
+	}
+
+	// the body does not match the transactions root of header
+	batch = db.NewBatch()
+	WriteBody(batch, block.Hash(), 1, &types.Body{Transactions: txs[:2]})
+	batch.Commit()
+	if GetBlock(db, block.Hash(), 1) != nil {
+		t.Fatalf("Error: block with invalid body should not be returned")
+	}
+}
@@ -1,12 +1,23 @@
 package core
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"math/big"
 	"seth/common"
+	"seth/common/math"
+	"seth/config"
+	"seth/consensus"
+	"seth/core/state"
 	"seth/core/types"
 	"seth/database"
+	"seth/log"
+	"seth/trie"
+)
+
+const (
+	// GenesisGasLimit default gas limit of genesis block
+	GenesisGasLimit uint64 = 4712388
 )
 
 const (
@@ -19,71 +30,160 @@ const (
 )
 
 var (
-	// ErrHasGenesisBlock error has genesis block in blockchain
-	ErrHasGenesisBlock = errors.New("Found genesis block in blockchain")
+	// ErrGenesisNoConfig error genesis has no chain config
+	ErrGenesisNoConfig = errors.New("genesis has no chain configuration")
+	// ErrGenesisGasLimit error genesis gas limit is lower than the minimum,no
+	// child block could be accepted on it
+	ErrGenesisGasLimit = errors.New("genesis gas limit too low")
 )
 
+// GenesisMismatchError is returned if the genesis block in database is not the
+// genesis block to setup
+type GenesisMismatchError struct {
+	Stored, New common.Hash
+}
+
+func (e *GenesisMismatchError) Error() string {
+	return fmt.Sprintf("database already contains an incompatible genesis block (have %s, new %s)", e.Stored.Hex(), e.New.Hex())
+}
+
 // Genesis is genesis struct to
 type Genesis struct {
-	ChainID    *big.Int       `json:"chainId"`
-	Nonce      uint64         `json:"nonce"`
-	Timestamp  uint64         `json:"timestamp"`
-	ExtraData  []byte         `json:"extraData"`
-	Difficulty *big.Int       `json:"difficulty" gencodec:"required"`
-	Mixhash    common.Hash    `json:"mixHash"`
-	Coinbase   common.Address `json:"coinbase"`
+	Config     *config.ChainConfig `json:"config"`
+	Nonce      uint64              `json:"nonce"`
+	Timestamp  uint64              `json:"timestamp"`
+	ExtraData  []byte              `json:"extraData"`
+	GasLimit   uint64              `json:"gasLimit"`
+	Difficulty *big.Int            `json:"difficulty" gencodec:"required"`
+	Mixhash    common.Hash         `json:"mixHash"`
+	Coinbase   common.Address      `json:"coinbase"`
+	Alloc      GenesisAlloc        `json:"alloc"`
 
 	Number     uint64      `json:"number"`
 	ParentHash common.Hash `json:"parentHash"`
 }
 
+// GenesisAlloc specifies the initial state that is part of the genesis block
+type GenesisAlloc map[common.Address]GenesisAccount
+
+// GenesisAccount is an account in the state of the genesis block
+type GenesisAccount struct {
+	Balance *big.Int `json:"balance" gencodec:"required"`
+	Nonce   uint64   `json:"nonce,omitempty"`
+}
+
+// etherAmount return the amount of n ether in wei
+func etherAmount(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), math.BigPow(10, 18))
+}
+
 // DefaultGenesis is default main net genesis block info
 func DefaultGenesis() *Genesis {
 	return &Genesis{
-		ChainID:    big.NewInt(1),
+		Config:     config.MainnetChainConfig,
 		Nonce:      66,
+		GasLimit:   GenesisGasLimit,
 		ExtraData:  []byte("Mainnet Ethereum Genesis Block"),
 		Difficulty: big.NewInt(17179869184),
+		Alloc: GenesisAlloc{
+			common.HexToAddress("0x3282791d6fd713f1e94f4bfd565eaa78b3a0599d"): {Balance: etherAmount(50000000)},
+			common.HexToAddress("0x17961d633bcf20a7b029a7d94b7df4da2ec5427f"): {Balance: etherAmount(20000000)},
+			common.HexToAddress("0x493a2a0d7d3f5f8d6d2c3b3d1e8ed1e3ff6c6a49"): {Balance: etherAmount(10000000)},
+		},
 	}
 }
 
 // TestnetGenesis is test net genesis block info
 func TestnetGenesis() *Genesis {
 	return &Genesis{
-		ChainID:    big.NewInt(0),
+		Config:     config.TestnetChainConfig,
 		Nonce:      66,
+		GasLimit:   GenesisGasLimit,
 		ExtraData:  []byte("Testnet Ethereum Genesis Block"),
 		Difficulty: big.NewInt(1048576),
+		Alloc: GenesisAlloc{
+			common.HexToAddress("0x874b54a8bd152966d63f706bae1ffeb0411921e5"): {Balance: etherAmount(1000000000)},
+			common.HexToAddress("0x9c4a4b5c2e1c0a4ab0a1c2de5bf1d2fe6d4a0b7e"): {Balance: etherAmount(1000000000)},
+		},
 	}
 }
 
 // DevelopernetGenesis is test net genesis block info
 func DevelopernetGenesis() *Genesis {
 	return &Genesis{
-		ChainID:    big.NewInt(-1),
+		Config:     config.DevelopernetChainConfig,
 		Nonce:      66,
+		GasLimit:   GenesisGasLimit,
 		ExtraData:  []byte("Devnet Ethereum Genesis Block"),
 		Difficulty: big.NewInt(1048576),
+		Alloc: GenesisAlloc{
+			common.HexToAddress("0x71562b71999873db5b286df957af199ec94617f7"): {Balance: etherAmount(1000000000)},
+		},
 	}
 }
 
-// SetupGensisBlock setup genesis block
-func (g Genesis) SetupGensisBlock(db database.Database) (common.Hash, error) {
+// SetupGensisBlock setup genesis block,the genesis block is committed if the
+// database is empty. Otherwise the stored genesis block must be the same as g
+// and the stored chain config is checked and updated to the config of g.
+func (g Genesis) SetupGensisBlock(db database.Database) (*config.ChainConfig, common.Hash, error) {
+	if g.Config == nil {
+		return nil, common.Hash{}, ErrGenesisNoConfig
+	}
+	if g.GasLimit < consensus.MinGasLimit {
+		return nil, common.Hash{}, ErrGenesisGasLimit
+	}
 	stored := GetCanonicalHash(db, 0)
 	if (stored == common.Hash{}) {
 		block, err := g.Commit(db)
-		return block.Hash(), err
+		if err != nil {
+			return nil, common.Hash{}, err
+		}
+		return g.Config, block.Hash(), nil
+	}
+	if hash := g.ToBlock(nil).Hash(); hash != stored {
+		return nil, stored, &GenesisMismatchError{Stored: stored, New: hash}
+	}
+
+	storedcfg := ReadChainConfig(db, stored)
+	if storedcfg == nil {
+		log.Warn("Found genesis block without chain config")
+	} else {
+		var height uint64
+		if number, ok := GetBlockNumber(db, ReadHeadBlockHash(db)); ok {
+			height = number
+		}
+		if err := storedcfg.CheckCompatible(g.Config, height); err != nil {
+			return storedcfg, stored, err
+		}
+	}
+	batch := db.NewBatch()
+	if err := WriteChainConfig(batch, stored, g.Config); err != nil {
+		batch.Rollback()
+		return nil, stored, err
 	}
-	return stored, ErrHasGenesisBlock
+	return g.Config, stored, batch.Commit()
 }
 
 // Commit commit genesis block to blockchain
 func (g Genesis) Commit(db database.Database) (*types.Block, error) {
-	block := g.ToBlock(db)
+	if g.Config == nil {
+		return nil, ErrGenesisNoConfig
+	}
+	if g.GasLimit < consensus.MinGasLimit {
+		return nil, ErrGenesisGasLimit
+	}
+	block, statedb, err := g.toBlock(db)
+	if err != nil {
+		return nil, err
+	}
 	if block.Header.Number.Sign() != 0 {
 		//return nil, fmt.Errorf("can't commit genesis block with number > 0")
 	}
 	batch := db.NewBatch()
+	if _, err := statedb.Commit(batch); err != nil {
+		batch.Rollback()
+		return nil, err
+	}
 	if err := WriteTd(batch, block.Hash(), block.NumberU64(), g.Difficulty); err != nil {
 		batch.Rollback()
 		return nil, err
@@ -95,20 +195,37 @@ func (g Genesis) Commit(db database.Database) (*types.Block, error) {
 
 	WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
 	WriteHeadBlockHash(batch, block.Hash())
-
-	json, err := json.Marshal(g)
-	if err != nil {
+	if err := WriteChainConfig(batch, block.Hash(), g.Config); err != nil {
 		batch.Rollback()
 		return nil, err
 	}
-
-	WriteChainConfig(batch, block.Hash(), json)
 	err = batch.Commit()
 	return block, err
 }
 
-// ToBlock genesis to block
+// ToBlock genesis to block,the state root is computed from the alloc of
+// genesis without writing the state to db. db may be nil.
 func (g *Genesis) ToBlock(db database.Database) *types.Block {
+	block, _, err := g.toBlock(db)
+	if err != nil {
+		panic(err)
+	}
+	return block
+}
+
+// toBlock build the genesis block and the statedb holding its alloc
+func (g *Genesis) toBlock(db database.Database) (*types.Block, *state.Statedb, error) {
+	statedb, err := openState(g.Config, common.Hash{}, trie.NewNodeDatabase(db))
+	if err != nil {
+		return nil, nil, err
+	}
+	for addr, account := range g.Alloc {
+		if account.Balance != nil {
+			statedb.AddAmount(addr, account.Balance)
+		}
+		statedb.SetNonce(addr, account.Nonce)
+	}
+	root := statedb.IntermediateRoot()
 
 	head := &types.Header{
 		Number:     new(big.Int).SetUint64(g.Number),
@@ -116,11 +233,12 @@ func (g *Genesis) ToBlock(db database.Database) *types.Block {
 		Time:       new(big.Int).SetUint64(g.Timestamp),
 		ParentHash: g.ParentHash,
 		Extra:      g.ExtraData,
+		GasLimit:   g.GasLimit,
 		Difficulty: g.Difficulty,
 		MixDigest:  g.Mixhash,
 		Coinbase:   g.Coinbase,
-		//Root:       root,
+		Root:       root,
 	}
 
-	return types.NewBlock(head, nil)
+	return types.NewBlock(head, nil), statedb, nil
 }
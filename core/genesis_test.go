@@ -0,0 +1,149 @@
+package core
+
+import (
+	"encoding/json"
+	"math/big"
+	"seth/common"
+	"seth/config"
+	"seth/core/state"
+	"seth/trie"
+	"testing"
+)
+
+func Test_Genesis_Commit(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	addr1 := common.HexToAddress("0x71562b71999873db5b286df957af199ec94617f7")
+	addr2 := common.BytesToAddress([]byte("addr2"))
+	genesis := &Genesis{
+		Config:     testChainConfig,
+		Difficulty: big.NewInt(1),
+		GasLimit:   GenesisGasLimit,
+		Alloc: GenesisAlloc{
+			addr1: {Balance: big.NewInt(100)},
+			addr2: {Balance: big.NewInt(200), Nonce: 3},
+		},
+	}
+	block, err := genesis.Commit(db)
+	if err != nil {
+		t.Fatalf("Failed to commit genesis: %v", err)
+	}
+	if block.Header.Root != genesis.ToBlock(nil).Header.Root || block.Hash() != genesis.ToBlock(nil).Hash() {
+		t.Fatalf("Error root of genesis block: %x", block.Header.Root)
+	}
+	if (block.Header.Root == common.Hash{}) {
+		t.Fatalf("Error empty root of genesis block with alloc")
+	}
+
+	statedb, err := state.NewStatedb(block.Header.Root, trie.NewNodeDatabase(db))
+	if err != nil {
+		t.Fatalf("Failed to open genesis state: %v", err)
+	}
+	if amount := statedb.GetAmount(addr1); amount.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("Error amount of %x: %v", addr1, amount)
+	}
+	if amount, nonce := statedb.GetAmount(addr2), statedb.GetNonce(addr2); amount.Cmp(big.NewInt(200)) != 0 || nonce != 3 {
+		t.Fatalf("Error account of %x: %v %d", addr2, amount, nonce)
+	}
+}
+
+func Test_Genesis_AllocJSON(t *testing.T) {
+	for _, genesis := range []*Genesis{DefaultGenesis(), TestnetGenesis(), DevelopernetGenesis()} {
+		if len(genesis.Alloc) == 0 {
+			t.Fatalf("Error: preset %s funds no account", genesis.ExtraData)
+		}
+		data, err := json.Marshal(genesis)
+		if err != nil {
+			t.Fatalf("Failed to marshal genesis: %v", err)
+		}
+		decoded := new(Genesis)
+		if err := json.Unmarshal(data, decoded); err != nil {
+			t.Fatalf("Failed to unmarshal genesis: %v", err)
+		}
+		if len(decoded.Alloc) != len(genesis.Alloc) {
+			t.Fatalf("Error alloc of decoded genesis: %v", decoded.Alloc)
+		}
+		for addr, account := range genesis.Alloc {
+			if decoded.Alloc[addr].Balance.Cmp(account.Balance) != 0 {
+				t.Fatalf("Error balance of %x: %v", addr, decoded.Alloc[addr].Balance)
+			}
+		}
+		if decoded.ToBlock(nil).Hash() != genesis.ToBlock(nil).Hash() {
+			t.Fatalf("Error hash of decoded genesis")
+		}
+	}
+}
+
+func Test_Genesis_Setup(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	genesis := &Genesis{Config: &config.ChainConfig{ChainID: big.NewInt(1), EIP155Block: big.NewInt(10)}, Difficulty: big.NewInt(1), GasLimit: GenesisGasLimit}
+	cfg, hash, err := genesis.SetupGensisBlock(db)
+	if err != nil || hash != genesis.ToBlock(nil).Hash() || cfg != genesis.Config {
+		t.Fatalf("Failed to setup genesis: %v", err)
+	}
+	if stored := ReadChainConfig(db, hash); stored == nil || stored.ChainID.Cmp(big.NewInt(1)) != 0 || stored.EIP155Block.Cmp(big.NewInt(10)) != 0 {
+		t.Fatalf("Error stored chain config: %v", stored)
+	}
+
+	// the same genesis can be setup again and the fork not reached yet can be
+	// rescheduled
+	genesis.Config = &config.ChainConfig{ChainID: big.NewInt(1), EIP155Block: big.NewInt(5)}
+	if _, _, err := genesis.SetupGensisBlock(db); err != nil {
+		t.Fatalf("Failed to setup the same genesis: %v", err)
+	}
+	if stored := ReadChainConfig(db, hash); stored.EIP155Block.Cmp(big.NewInt(5)) != 0 {
+		t.Fatalf("Error rescheduled fork block: %v", stored.EIP155Block)
+	}
+
+	genesis.Config = &config.ChainConfig{ChainID: big.NewInt(2), EIP155Block: big.NewInt(5)}
+	if _, _, err := genesis.SetupGensisBlock(db); err == nil {
+		t.Fatalf("Error setup genesis with another chain id")
+	} else if compat, ok := err.(*config.ConfigCompatError); !ok || compat.What != "chain id" {
+		t.Fatalf("Error result of genesis with another chain id: %v", err)
+	}
+
+	lowGas := &Genesis{Config: testChainConfig, Difficulty: big.NewInt(1)}
+	if _, _, err := lowGas.SetupGensisBlock(db); err != ErrGenesisGasLimit {
+		t.Fatalf("expected error %v,got %v", ErrGenesisGasLimit, err)
+	}
+
+	other := &Genesis{Config: testChainConfig, Difficulty: big.NewInt(2), GasLimit: GenesisGasLimit}
+	_, stored, err := other.SetupGensisBlock(db)
+	mismatch, ok := err.(*GenesisMismatchError)
+	if !ok || mismatch.Stored != hash || mismatch.New != other.ToBlock(nil).Hash() || stored != hash {
+		t.Fatalf("Error result of mismatched genesis: %v", err)
+	}
+}
+
+func Test_Genesis_SecureTrie(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	addr := common.BytesToAddress([]byte("addr"))
+	plain := &Genesis{Config: &config.ChainConfig{ChainID: big.NewInt(1)}, Difficulty: big.NewInt(1), GasLimit: GenesisGasLimit, Alloc: GenesisAlloc{addr: {Balance: big.NewInt(100)}}}
+	secure := &Genesis{Config: &config.ChainConfig{ChainID: big.NewInt(1), SecureTrie: true}, Difficulty: big.NewInt(1), GasLimit: GenesisGasLimit, Alloc: plain.Alloc}
+	if plain.ToBlock(nil).Header.Root == secure.ToBlock(nil).Header.Root {
+		t.Fatalf("Error: secure trie should change the state root")
+	}
+
+	if _, _, err := secure.SetupGensisBlock(db); err != nil {
+		t.Fatalf("Failed to setup genesis: %v", err)
+	}
+	bc, err := NewBlockChain(db, testEngine)
+	if err != nil {
+		t.Fatalf("Failed to new block chain: %v", err)
+	}
+	statedb, err := bc.StateAt(bc.CurrentBlock().Header.Root)
+	if err != nil {
+		t.Fatalf("Failed to open genesis state: %v", err)
+	}
+	if amount := statedb.GetAmount(addr); amount.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("Error amount of secure genesis state: %v", amount)
+	}
+	if _, _, err := plain.SetupGensisBlock(db); err == nil {
+		t.Fatalf("Error: genesis with another trie should mismatch")
+	}
+}
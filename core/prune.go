@@ -0,0 +1,36 @@
+package core
+
+import (
+	"fmt"
+	"seth/common"
+	"seth/core/state"
+	"seth/database"
+)
+
+// PruneState delete the state trie nodes which are not reachable from the
+// state roots of the recent retain canonical blocks,it returns the count of
+// deleted nodes
+func PruneState(db database.Database, retain uint64) (int, error) {
+	hash := ReadHeadBlockHash(db)
+	if hash == (common.Hash{}) {
+		return 0, ErrNoGenesis
+	}
+	head, ok := GetBlockNumber(db, hash)
+	if !ok {
+		return 0, fmt.Errorf("missing number of head block %s", hash.Hex())
+	}
+
+	roots := []common.Hash{}
+	for number := head; uint64(len(roots)) < retain; number-- {
+		hash := GetCanonicalHash(db, number)
+		header := GetHeader(db, hash, number)
+		if header == nil {
+			return 0, fmt.Errorf("missing header of block %d", number)
+		}
+		roots = append(roots, header.Root)
+		if number == 0 {
+			break
+		}
+	}
+	return state.PruneState(db, roots)
+}
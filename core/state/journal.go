@@ -0,0 +1,60 @@
+package state
+
+import (
+	"math/big"
+	"seth/common"
+)
+
+// journalEntry is a modification of the state which can be reverted
+type journalEntry interface {
+	revert(*Statedb)
+}
+
+// journal is the list of state modifications since the last commit
+type journal struct {
+	entries []journalEntry
+}
+
+func (j *journal) append(entry journalEntry) {
+	j.entries = append(j.entries, entry)
+}
+
+// revert undo the modifications after the snapshot index in reverse order
+func (j *journal) revert(statedb *Statedb, snapshot int) {
+	for i := len(j.entries) - 1; i >= snapshot; i-- {
+		j.entries[i].revert(statedb)
+	}
+	j.entries = j.entries[:snapshot]
+}
+
+func (j *journal) length() int {
+	return len(j.entries)
+}
+
+type (
+	createObjectChange struct {
+		account common.Address
+	}
+	amountChange struct {
+		account common.Address
+		prev    *big.Int
+	}
+	nonceChange struct {
+		account common.Address
+		prev    uint64
+	}
+)
+
+func (ch createObjectChange) revert(s *Statedb) {
+	delete(s.stateObjects, ch.account)
+	// the account may be written to trie by IntermediateRoot
+	s.trie.Delete(ch.account[:])
+}
+
+func (ch amountChange) revert(s *Statedb) {
+	s.stateObjects[ch.account].account.Amount = ch.prev
+}
+
+func (ch nonceChange) revert(s *Statedb) {
+	s.stateObjects[ch.account].account.Nonce = ch.prev
+}
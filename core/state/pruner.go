@@ -0,0 +1,137 @@
+package state
+
+import (
+	"seth/common"
+	"seth/database"
+	"seth/log"
+	"seth/trie"
+)
+
+const (
+	// pruneBatchSize number of deleted nodes to write in one batch
+	pruneBatchSize = 10000
+)
+
+// Pruner keeps the state of the recent roots in memory of the node database,
+// the state of roots out of the retain window is dereferenced so that the
+// stale trie nodes are never written to disk.
+type Pruner struct {
+	db     *trie.NodeDatabase
+	retain int
+	roots  []common.Hash // retained roots,oldest first
+}
+
+// NewPruner new a pruner which retains the state of the last retain roots
+func NewPruner(db *trie.NodeDatabase, retain int) *Pruner {
+	if retain < 1 {
+		retain = 1
+	}
+	return &Pruner{
+		db:     db,
+		retain: retain,
+	}
+}
+
+// Commit commit the statedb to memory and retain the root,the oldest root
+// is dereferenced if the window is full. Dirty nodes are flushed to disk when
+// they exceed the memory limit of the node database.
+func (p *Pruner) Commit(statedb *Statedb) (common.Hash, error) {
+	root, err := statedb.Commit(nil)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	p.db.Reference(statePrefix, root)
+	p.roots = append(p.roots, root)
+	if len(p.roots) > p.retain {
+		p.db.Dereference(statePrefix, p.roots[0])
+		p.roots = p.roots[1:]
+	}
+	return root, p.db.Cap()
+}
+
+// Persist write the state of root to disk,it is called periodically so that
+// a crash loses only the blocks after the last persisted root. The nodes are
+// released from memory after they are written.
+func (p *Pruner) Persist(root common.Hash) error {
+	batch := p.db.DiskDB().NewBatch()
+	p.db.Write(statePrefix, root, batch)
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	p.db.Release(statePrefix, root)
+	return nil
+}
+
+// Flush write the state of all retained roots to disk,it should be called
+// before shutdown to persist the recent state
+func (p *Pruner) Flush() error {
+	batch := p.db.DiskDB().NewBatch()
+	for _, root := range p.roots {
+		p.db.Write(statePrefix, root, batch)
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	for _, root := range p.roots {
+		p.db.Release(statePrefix, root)
+	}
+	p.roots = nil
+	return nil
+}
+
+// PruneState delete the state trie nodes in database which are not reachable
+// from the given roots,it returns the count of deleted nodes
+func PruneState(db database.Database, roots []common.Hash) (int, error) {
+	// mark the nodes reachable from the retained roots
+	marked := make(map[common.Hash]struct{})
+	nodedb := trie.NewNodeDatabase(db)
+	for _, root := range roots {
+		if root == (common.Hash{}) {
+			continue
+		}
+		t, err := trie.NewTrie(root, statePrefix, nodedb)
+		if err != nil {
+			return 0, err
+		}
+		it := t.NodeIterator(nil)
+		descend := true
+		for it.Next(descend) {
+			hash := it.Hash()
+			// the subtree of a marked node is marked already
+			_, exists := marked[hash]
+			marked[hash] = struct{}{}
+			descend = !exists
+		}
+		if err := it.Error(); err != nil {
+			return 0, err
+		}
+		log.Info("marked state of root %s,%d nodes", root.Hex(), len(marked))
+	}
+
+	// sweep the nodes not marked
+	deleted := 0
+	batch := db.NewBatch()
+	it := db.NewIterator(statePrefix)
+	defer it.Release()
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(statePrefix)+common.HashLength {
+			continue
+		}
+		if _, ok := marked[common.BytesToHash(key[len(statePrefix):])]; ok {
+			continue
+		}
+		batch.Delete(append([]byte{}, key...))
+		deleted++
+		if deleted%pruneBatchSize == 0 {
+			if err := batch.Commit(); err != nil {
+				return deleted, err
+			}
+			batch = db.NewBatch()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return deleted, err
+	}
+	return deleted, batch.Commit()
+}
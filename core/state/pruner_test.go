@@ -0,0 +1,87 @@
+package state
+
+import (
+	"math/big"
+	"seth/common"
+	"seth/trie"
+	"testing"
+)
+
+func Test_Pruner_Commit(t *testing.T) {
+	db, remove := newTestStateDB()
+	defer remove()
+
+	nodedb := trie.NewNodeDatabase(db)
+	pruner := NewPruner(nodedb, 2)
+	statedb, _ := NewStatedb(common.Hash{}, nodedb)
+	roots := []common.Hash{}
+	for i := byte(0); i < 4; i++ {
+		statedb.AddAmount(common.BytesToAddress([]byte{i}), big.NewInt(int64(i)+1))
+		root, err := pruner.Commit(statedb)
+		if err != nil {
+			t.Fatalf("Failed to commit state: %v", err)
+		}
+		roots = append(roots, root)
+	}
+
+	// the state out of the retain window is removed from memory
+	if _, err := NewStatedb(roots[1], nodedb); err == nil {
+		t.Fatalf("Error: state of root %x should be pruned", roots[1])
+	}
+	if err := pruner.Flush(); err != nil {
+		t.Fatalf("Failed to flush state: %v", err)
+	}
+	for _, root := range roots[2:] {
+		if _, err := NewStatedb(root, trie.NewNodeDatabase(db)); err != nil {
+			t.Fatalf("Failed to open retained state %x: %v", root, err)
+		}
+	}
+}
+
+func Test_Statedb_PruneState(t *testing.T) {
+	db, remove := newTestStateDB()
+	defer remove()
+
+	roots := []common.Hash{}
+	statedb, _ := NewStatedb(common.Hash{}, trie.NewNodeDatabase(db))
+	for i := byte(0); i < 4; i++ {
+		for j := byte(0); j < 16; j++ {
+			statedb.AddAmount(common.BytesToAddress([]byte{j}), big.NewInt(int64(i)+1))
+		}
+		batch := db.NewBatch()
+		root, err := statedb.Commit(batch)
+		if err != nil {
+			t.Fatalf("Failed to commit state: %v", err)
+		}
+		batch.Commit()
+		roots = append(roots, root)
+	}
+
+	deleted, err := PruneState(db, roots[2:])
+	if err != nil {
+		t.Fatalf("Failed to prune state: %v", err)
+	}
+	if deleted == 0 {
+		t.Fatalf("Error: stale nodes should be deleted")
+	}
+	if _, err := NewStatedb(roots[0], trie.NewNodeDatabase(db)); err == nil {
+		t.Fatalf("Error: state of root %x should be pruned", roots[0])
+	}
+	for i, root := range roots[2:] {
+		statedb, err := NewStatedb(root, trie.NewNodeDatabase(db))
+		if err != nil {
+			t.Fatalf("Failed to open retained state %x: %v", root, err)
+		}
+		for j := byte(0); j < 16; j++ {
+			amount := statedb.GetAmount(common.BytesToAddress([]byte{j}))
+			if want := int64((i + 3) * (i + 4) / 2); amount.Cmp(big.NewInt(want)) != 0 {
+				t.Fatalf("Error amount of account %d: %v,want %d", j, amount, want)
+			}
+		}
+	}
+
+	// prune again delete nothing
+	if deleted, _ := PruneState(db, roots[2:]); deleted != 0 {
+		t.Fatalf("Error count of deleted nodes: %d", deleted)
+	}
+}
@@ -1,42 +1,124 @@
 package state
 
 import (
+	"fmt"
 	"math/big"
 	"seth/common"
 	"seth/database"
 	"seth/rlp"
 	"seth/trie"
+	"sort"
 )
 
+// statePrefix prefix of state trie nodes in database
+var statePrefix = []byte("S")
+
+// Trie is the merkle trie used by statedb
+type Trie interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) bool
+	Hash() common.Hash
+	Commit(batch database.Batch) (common.Hash, error)
+	NodeIterator(start []byte) trie.NodeIterator
+}
+
+// revision is a snapshot of statedb
+type revision struct {
+	id           int
+	journalIndex int
+}
+
 // Statedb use to store accout with the merkle trie
 type Statedb struct {
-	trie         *trie.Trie
+	db           *trie.NodeDatabase
+	secure       bool // whether the keys of state trie are hashed
+	trie         Trie
 	stateObjects map[common.Address]*stateObject
+
+	journal        journal
+	validRevisions []revision
+	nextRevisionID int
+}
+
+// NewStatedb new a statedb on the plain state trie,the node database can be
+// shared by statedbs
+func NewStatedb(root common.Hash, db *trie.NodeDatabase) (*Statedb, error) {
+	return newStatedb(root, db, false)
+}
+
+// NewSecureStatedb new a statedb on the secure state trie,the keys are hashed
+func NewSecureStatedb(root common.Hash, db *trie.NodeDatabase) (*Statedb, error) {
+	return newStatedb(root, db, true)
 }
 
-// NewStatedb new a statedb
-func NewStatedb(root common.Hash, db database.Database) (*Statedb, error) {
-	trie, err := trie.NewTrie(root, []byte("S"), db)
+func newStatedb(root common.Hash, db *trie.NodeDatabase, secure bool) (*Statedb, error) {
+	trie, err := openTrie(root, db, secure)
 	if err != nil {
 		return nil, err
 	}
 	return &Statedb{
+		db:           db,
+		secure:       secure,
 		trie:         trie,
 		stateObjects: make(map[common.Address]*stateObject),
 	}, nil
 }
 
 // ResetStatedb reset state db
-func (s *Statedb) ResetStatedb(root common.Hash, db database.Database) error {
-	trie, err := trie.NewTrie(root, []byte("S"), db)
+func (s *Statedb) ResetStatedb(root common.Hash, db *trie.NodeDatabase) error {
+	trie, err := openTrie(root, db, s.secure)
 	if err != nil {
 		return err
 	}
+	s.db = db
 	s.trie = trie
 	s.stateObjects = make(map[common.Address]*stateObject)
+	s.clearJournal()
 	return nil
 }
 
+// Database return the node database of statedb
+func (s *Statedb) Database() *trie.NodeDatabase {
+	return s.db
+}
+
+// Copy return an independent copy of statedb,the node database is shared
+func (s *Statedb) Copy() *Statedb {
+	cpy := &Statedb{
+		db:           s.db,
+		secure:       s.secure,
+		trie:         copyTrie(s.trie),
+		stateObjects: make(map[common.Address]*stateObject, len(s.stateObjects)),
+	}
+	for addr, object := range s.stateObjects {
+		cpy.stateObjects[addr] = object.deepCopy()
+	}
+	return cpy
+}
+
+// Snapshot return an identifier of the current revision of the state
+func (s *Statedb) Snapshot() int {
+	id := s.nextRevisionID
+	s.nextRevisionID++
+	s.validRevisions = append(s.validRevisions, revision{id, s.journal.length()})
+	return id
+}
+
+// RevertToSnapshot revert all state changes made since the given revision
+func (s *Statedb) RevertToSnapshot(revid int) {
+	idx := sort.Search(len(s.validRevisions), func(i int) bool {
+		return s.validRevisions[i].id >= revid
+	})
+	if idx == len(s.validRevisions) || s.validRevisions[idx].id != revid {
+		panic(fmt.Errorf("revision id %v cannot be reverted", revid))
+	}
+	snapshot := s.validRevisions[idx].journalIndex
+
+	s.journal.revert(s, snapshot)
+	s.validRevisions = s.validRevisions[:idx]
+}
+
 // GetAmount get amount of account
 func (s *Statedb) GetAmount(addr common.Address) *big.Int {
 	object := s.getStateObject(addr)
@@ -50,6 +132,7 @@ func (s *Statedb) GetAmount(addr common.Address) *big.Int {
 func (s *Statedb) SetAmount(addr common.Address, amount *big.Int) {
 	object := s.getStateObject(addr)
 	if object != nil {
+		s.journal.append(amountChange{account: addr, prev: object.GetAmount()})
 		object.SetAmount(amount)
 	}
 }
@@ -58,6 +141,7 @@ func (s *Statedb) SetAmount(addr common.Address, amount *big.Int) {
 func (s *Statedb) AddAmount(addr common.Address, amount *big.Int) {
 	object := s.getStateObject(addr)
 	if object != nil {
+		s.journal.append(amountChange{account: addr, prev: object.GetAmount()})
 		object.AddAmount(amount)
 	}
 }
@@ -66,6 +150,7 @@ func (s *Statedb) AddAmount(addr common.Address, amount *big.Int) {
 func (s *Statedb) SubAmount(addr common.Address, amount *big.Int) {
 	object := s.getStateObject(addr)
 	if object != nil {
+		s.journal.append(amountChange{account: addr, prev: object.GetAmount()})
 		object.SubAmount(amount)
 	}
 }
@@ -83,23 +168,49 @@ func (s *Statedb) GetNonce(addr common.Address) uint64 {
 func (s *Statedb) SetNonce(addr common.Address, nonce uint64) {
 	object := s.getStateObject(addr)
 	if object != nil {
+		s.journal.append(nonceChange{account: addr, prev: object.GetNonce()})
 		object.SetNonce(nonce)
 	}
 }
 
-// Commit commit memory state object to db
+// IntermediateRoot return the current root of state,the state is not
+// committed and can still be reverted
+func (s *Statedb) IntermediateRoot() common.Hash {
+	s.updateTrie()
+	return s.trie.Hash()
+}
+
+// Commit commit memory state object to node database,the state is flushed
+// to batch if batch is not nil
 func (s *Statedb) Commit(batch database.Batch) (root common.Hash, err error) {
+	if err := s.updateTrie(); err != nil {
+		return common.Hash{}, err
+	}
+	for _, object := range s.stateObjects {
+		object.dirty = false
+	}
+	s.clearJournal()
+	return s.trie.Commit(batch)
+}
+
+// updateTrie write the dirty state objects to trie
+func (s *Statedb) updateTrie() error {
 	for addr, object := range s.stateObjects {
 		if object.dirty {
 			data, err := rlp.EncodeToBytes(object.account)
 			if err != nil {
-				return common.Hash{}, err
+				return err
 			}
 			s.trie.Put(addr[:], data)
-			object.dirty = false
 		}
 	}
-	return s.trie.Commit(batch)
+	return nil
+}
+
+// clearJournal drop the journal and snapshots,the changes can not be reverted
+func (s *Statedb) clearJournal() {
+	s.journal = journal{}
+	s.validRevisions = s.validRevisions[:0]
 }
 
 func (s *Statedb) getStateObject(addr common.Address) *stateObject {
@@ -111,6 +222,7 @@ func (s *Statedb) getStateObject(addr common.Address) *stateObject {
 	if len(val) == 0 {
 		object.SetNonce(0)
 		s.stateObjects[addr] = object
+		s.journal.append(createObjectChange{account: addr})
 		return object
 	}
 	if err := rlp.DecodeBytes(val, &object.account); err != nil {
@@ -119,3 +231,23 @@ func (s *Statedb) getStateObject(addr common.Address) *stateObject {
 	s.stateObjects[addr] = object
 	return object
 }
+
+// openTrie open the state trie,the keys are hashed if secure is true
+func openTrie(root common.Hash, db *trie.NodeDatabase, secure bool) (Trie, error) {
+	if secure {
+		return trie.NewSecureTrie(root, statePrefix, db)
+	}
+	return trie.NewTrie(root, statePrefix, db)
+}
+
+// copyTrie return a copy of the state trie
+func copyTrie(t Trie) Trie {
+	switch t := t.(type) {
+	case *trie.SecureTrie:
+		return t.Copy()
+	case *trie.Trie:
+		return t.Copy()
+	default:
+		panic(fmt.Errorf("unknown trie type %T", t))
+	}
+}
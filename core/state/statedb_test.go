@@ -8,6 +8,7 @@ import (
 	"seth/common"
 	"seth/database"
 	"seth/database/leveldb"
+	"seth/trie"
 	"testing"
 )
 
@@ -30,7 +31,7 @@ func Test_Statedb_Operate(t *testing.T) {
 	db, remove := newTestStateDB()
 	defer remove()
 
-	statedb, err := NewStatedb(common.Hash{}, db)
+	statedb, err := NewStatedb(common.Hash{}, trie.NewNodeDatabase(db))
 	if err != nil {
 		panic(err)
 	}
@@ -46,7 +47,7 @@ func Test_Statedb_Operate(t *testing.T) {
 	}
 	batch.Commit()
 
-	statedb, err = NewStatedb(hash, db)
+	statedb, err = NewStatedb(hash, trie.NewNodeDatabase(db))
 	if err != nil {
 		panic(err)
 	}
@@ -66,7 +67,7 @@ func Test_Statedb_Reset(t *testing.T) {
 	db, remove := newTestStateDB()
 	defer remove()
 
-	statedb, err := NewStatedb(common.Hash{}, db)
+	statedb, err := NewStatedb(common.Hash{}, trie.NewNodeDatabase(db))
 	if err != nil {
 		panic(err)
 	}
@@ -82,7 +83,7 @@ func Test_Statedb_Reset(t *testing.T) {
 	}
 	batch.Commit()
 
-	err = statedb.ResetStatedb(hash, db)
+	err = statedb.ResetStatedb(hash, trie.NewNodeDatabase(db))
 	if err != nil {
 		panic(err)
 	}
@@ -99,3 +100,100 @@ func Test_Statedb_Reset(t *testing.T) {
 	}
 
 }
+
+func Test_Statedb_SecureTrie(t *testing.T) {
+	db, remove := newTestStateDB()
+	defer remove()
+	statedb, err := NewSecureStatedb(common.Hash{}, trie.NewNodeDatabase(db))
+	if err != nil {
+		t.Fatalf("Failed to new statedb: %v", err)
+	}
+	addr := common.BytesToAddress([]byte{1})
+	statedb.AddAmount(addr, big.NewInt(100))
+	batch := db.NewBatch()
+	hash, _ := statedb.Commit(batch)
+	batch.Commit()
+
+	plain, _ := trie.NewTrie(hash, statePrefix, trie.NewNodeDatabase(db))
+	if plain.Get(addr[:]) != nil {
+		t.Fatalf("Error: address should be hashed in secure trie")
+	}
+	statedb, err = NewSecureStatedb(hash, trie.NewNodeDatabase(db))
+	if err != nil {
+		t.Fatalf("Failed to open statedb: %v", err)
+	}
+	if amount := statedb.GetAmount(addr); amount.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("Error amount of account: %v", amount)
+	}
+}
+
+func Test_Statedb_Snapshot(t *testing.T) {
+	db, remove := newTestStateDB()
+	defer remove()
+
+	statedb, _ := NewStatedb(common.Hash{}, trie.NewNodeDatabase(db))
+	addr1 := common.BytesToAddress([]byte{1})
+	addr2 := common.BytesToAddress([]byte{2})
+	statedb.AddAmount(addr1, big.NewInt(100))
+	statedb.SetNonce(addr1, 1)
+
+	snap1 := statedb.Snapshot()
+	statedb.SubAmount(addr1, big.NewInt(30))
+	statedb.AddAmount(addr2, big.NewInt(30))
+	snap2 := statedb.Snapshot()
+	statedb.SetNonce(addr1, 2)
+	statedb.SetAmount(addr2, big.NewInt(50))
+
+	statedb.RevertToSnapshot(snap2)
+	if nonce := statedb.GetNonce(addr1); nonce != 1 {
+		t.Fatalf("Error nonce after revert: %d", nonce)
+	}
+	if amount := statedb.GetAmount(addr2); amount.Cmp(big.NewInt(30)) != 0 {
+		t.Fatalf("Error amount after revert: %v", amount)
+	}
+
+	statedb.RevertToSnapshot(snap1)
+	if amount := statedb.GetAmount(addr1); amount.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("Error amount after revert: %v", amount)
+	}
+	if _, exists := statedb.stateObjects[addr2]; exists {
+		t.Fatalf("Error: created account should be removed after revert")
+	}
+	hash, _ := statedb.Commit(nil)
+	other, _ := NewStatedb(common.Hash{}, trie.NewNodeDatabase(db))
+	other.AddAmount(addr1, big.NewInt(100))
+	other.SetNonce(addr1, 1)
+	if want, _ := other.Commit(nil); hash != want {
+		t.Fatalf("Error root after revert: %x,want %x", hash, want)
+	}
+}
+
+func Test_Statedb_Copy(t *testing.T) {
+	db, remove := newTestStateDB()
+	defer remove()
+
+	statedb, _ := NewStatedb(common.Hash{}, trie.NewNodeDatabase(db))
+	for i := byte(0); i < 16; i++ {
+		statedb.AddAmount(common.BytesToAddress([]byte{i}), big.NewInt(int64(i)))
+	}
+	root, _ := statedb.Commit(nil)
+
+	cpy := statedb.Copy()
+	for i := byte(0); i < 16; i++ {
+		cpy.AddAmount(common.BytesToAddress([]byte{i}), big.NewInt(1))
+	}
+	cpy.AddAmount(common.BytesToAddress([]byte{100}), big.NewInt(1))
+	cpyroot, _ := cpy.Commit(nil)
+
+	if hash, _ := statedb.Commit(nil); hash != root || hash == cpyroot {
+		t.Fatalf("Error: copy should not modify the original state")
+	}
+	for i := byte(0); i < 16; i++ {
+		if amount := statedb.GetAmount(common.BytesToAddress([]byte{i})); amount.Cmp(big.NewInt(int64(i))) != 0 {
+			t.Fatalf("Error amount of original state: %v", amount)
+		}
+		if amount := cpy.GetAmount(common.BytesToAddress([]byte{i})); amount.Cmp(big.NewInt(int64(i)+1)) != 0 {
+			t.Fatalf("Error amount of copied state: %v", amount)
+		}
+	}
+}
@@ -24,6 +24,17 @@ func newStateObject() *stateObject {
 	}
 }
 
+// deepCopy return a copy of state object
+func (s *stateObject) deepCopy() *stateObject {
+	return &stateObject{
+		account: Account{
+			Nonce:  s.account.Nonce,
+			Amount: new(big.Int).Set(s.account.Amount),
+		},
+		dirty: s.dirty,
+	}
+}
+
 // SetNonce set nonce of account
 func (s *stateObject) SetNonce(nonce uint64) {
 	s.account.Nonce = nonce
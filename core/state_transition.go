@@ -0,0 +1,175 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"seth/common"
+	"seth/core/state"
+	"seth/core/types"
+	"seth/crypto"
+)
+
+const (
+	// TxGas gas used by a value transfer transaction
+	TxGas uint64 = 21000
+)
+
+// TransferLogTopic the first topic of the log of value transfer,the sender and
+// recipient are the following topics
+var TransferLogTopic = common.BytesToHash(crypto.Keccak256([]byte("Transfer(address,address,uint256)")))
+
+var (
+	// ErrNonceTooLow is returned if the nonce of a transaction is lower than the
+	// one present in the state
+	ErrNonceTooLow = errors.New("nonce too low")
+	// ErrNonceTooHigh is returned if the nonce of a transaction is higher than
+	// the next one expected based on the state
+	ErrNonceTooHigh = errors.New("nonce too high")
+	// ErrInsufficientFunds is returned if the amount of sender is not enough
+	// for the transfer and the fee
+	ErrInsufficientFunds = errors.New("insufficient funds for gas * price + value")
+	// ErrIntrinsicGas is returned if the gas limit of transaction is lower than
+	// the intrinsic gas
+	ErrIntrinsicGas = errors.New("intrinsic gas too low")
+	// ErrGasLimitReached is returned if the gas limit of block is not enough
+	// for the transaction
+	ErrGasLimitReached = errors.New("gas limit reached")
+	// ErrNegativeAmount is returned if the transfer amount is negative
+	ErrNegativeAmount = errors.New("negative amount of transaction")
+	// ErrInvalidSignature is returned if the sender can not be recovered from
+	// the signature of transaction
+	ErrInvalidSignature = errors.New("invalid transaction signature")
+	// ErrInvalidChainID is returned if the transaction is signed for another chain
+	ErrInvalidChainID = errors.New("invalid chain id of transaction")
+	// ErrNilRecipient is returned if the transaction has no recipient
+	ErrNilRecipient = errors.New("transaction recipient is nil")
+	// ErrInvalidBloom is returned if the bloom of receipts is not equal to the
+	// bloom in block header
+	ErrInvalidBloom = errors.New("invalid bloom of block")
+	// ErrInvalidReceiptHash is returned if the root hash of receipts is not
+	// equal to the receipt hash in block header
+	ErrInvalidReceiptHash = errors.New("invalid receipts root of block")
+	// ErrInvalidGasUsed is returned if the gas used by the transactions of
+	// block is not equal to the gas used in block header
+	ErrInvalidGasUsed = errors.New("invalid gas used of block")
+	// ErrInvalidStateRoot is returned if the state root after applying the
+	// transactions of block is not equal to the root in block header
+	ErrInvalidStateRoot = errors.New("invalid state root of block")
+)
+
+// IntrinsicGas return the gas used by transaction before it is executed
+func IntrinsicGas(tx *types.Transaction) uint64 {
+	return TxGas
+}
+
+// ApplyTransaction apply the value transfer transaction to statedb,the fee is
+// credited to author,or the coinbase of header if author is nil,and the gas
+// used is added to usedGas. A transaction failing to execute returns error and
+// is never included in block,so the receipt is always successful. On error the
+// unknown sender may be left as a new empty account,the caller reverts to a
+// snapshot to discard it.
+func ApplyTransaction(statedb *state.Statedb, signer types.Signer, author *common.Address, header *types.Header, tx *types.Transaction, usedGas *uint64) (*types.Receipt, error) {
+	if tx.Data.Signature == nil {
+		return nil, ErrInvalidSignature
+	}
+	from, err := tx.Sender(signer)
+	if err == types.ErrInvalidChainID {
+		return nil, ErrInvalidChainID
+	}
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if tx.Data.To == nil {
+		return nil, ErrNilRecipient
+	}
+
+	nonce := statedb.GetNonce(from)
+	if tx.Data.AccountNonce < nonce {
+		return nil, ErrNonceTooLow
+	}
+	if tx.Data.AccountNonce > nonce {
+		return nil, ErrNonceTooHigh
+	}
+	amount := tx.Data.Amount
+	if amount.Sign() < 0 || tx.Data.GasPrice.Sign() < 0 {
+		return nil, ErrNegativeAmount
+	}
+	gas := IntrinsicGas(tx)
+	if tx.Data.GasLimit < gas {
+		return nil, ErrIntrinsicGas
+	}
+	if header.GasLimit < *usedGas+gas {
+		return nil, ErrGasLimitReached
+	}
+	if statedb.GetAmount(from).Cmp(tx.Cost()) < 0 {
+		return nil, ErrInsufficientFunds
+	}
+
+	fee := new(big.Int).Mul(tx.Data.GasPrice, new(big.Int).SetUint64(gas))
+	statedb.SubAmount(from, new(big.Int).Add(amount, fee))
+	statedb.AddAmount(*tx.Data.To, amount)
+	if author == nil {
+		author = &header.Coinbase
+	}
+	statedb.AddAmount(*author, fee)
+	statedb.SetNonce(from, nonce+1)
+	*usedGas += gas
+
+	receipt := types.NewReceipt(*usedGas)
+	receipt.TxHash = tx.Hash()
+	receipt.GasUsed = gas
+	receipt.Logs = []*types.Log{{
+		Address: from,
+		Topics:  []common.Hash{TransferLogTopic, addressTopic(from), addressTopic(*tx.Data.To)},
+		Data:    amount.Bytes(),
+		TxHash:  receipt.TxHash,
+	}}
+	receipt.Bloom = types.LogsBloom(receipt.Logs)
+	return receipt, nil
+}
+
+// addressTopic return the log topic of address
+func addressTopic(addr common.Address) common.Hash {
+	return common.BytesToHash(addr[:])
+}
+
+// ApplyBlock apply all transactions of block to statedb and verify the gas
+// used,the bloom,the receipts root and the state root against the block header.
+// The fees are credited to author,see ApplyTransaction.
+func ApplyBlock(statedb *state.Statedb, signer types.Signer, author *common.Address, block *types.Block) (types.Receipts, error) {
+	var (
+		usedGas  uint64
+		logIndex uint
+		receipts types.Receipts
+	)
+	for i, tx := range block.Transactions() {
+		receipt, err := ApplyTransaction(statedb, signer, author, block.Header, tx, &usedGas)
+		if err != nil {
+			return nil, err
+		}
+		receipt.BlockHash = block.Hash()
+		receipt.BlockNumber = block.NumberU64()
+		receipt.TransactionIndex = uint(i)
+		for _, log := range receipt.Logs {
+			log.BlockHash = receipt.BlockHash
+			log.BlockNumber = receipt.BlockNumber
+			log.TxIndex = uint(i)
+			log.Index = logIndex
+			logIndex++
+		}
+		receipts = append(receipts, receipt)
+	}
+	if usedGas != block.Header.GasUsed {
+		return nil, ErrInvalidGasUsed
+	}
+	if types.CreateBloom(receipts) != block.Header.Bloom {
+		return nil, ErrInvalidBloom
+	}
+	if types.DeriveSha(receipts) != block.Header.ReceiptHash {
+		return nil, ErrInvalidReceiptHash
+	}
+	if statedb.IntermediateRoot() != block.Header.Root {
+		return nil, ErrInvalidStateRoot
+	}
+	return receipts, nil
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"seth/accounts"
+	"seth/common"
+	"seth/core/state"
+	"seth/core/types"
+	"seth/database"
+	"seth/database/leveldb"
+	"seth/trie"
+	"testing"
+)
+
+func newTestCoreDB() (database.Database, func()) {
+	dir, err := ioutil.TempDir("", "testcoredb")
+	if err != nil {
+		panic(err)
+	}
+	db, err := leveldb.NewLevelDB(dir, 0, 0)
+	if err != nil {
+		panic(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_ApplyTransaction(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	signer := types.NewSethSigner(big.NewInt(1))
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	statedb, _ := state.NewStatedb(common.Hash{}, trie.NewNodeDatabase(db))
+	coinbase := common.BytesToAddress([]byte("coinbase"))
+	statedb.AddAmount(from, big.NewInt(100+2*int64(TxGas)))
+	header := &types.Header{Coinbase: coinbase, GasLimit: 3 * TxGas}
+
+	newTx := func(nonce uint64, amount int64, chainsigner types.Signer) *types.Transaction {
+		tx := types.NewTransaction(to, big.NewInt(amount), nonce, TxGas, big.NewInt(1))
+		tx.Sign(chainsigner, key)
+		return tx
+	}
+	lowGasTx := types.NewTransaction(to, big.NewInt(30), 1, TxGas-1, big.NewInt(1))
+	lowGasTx.Sign(signer, key)
+	tests := []struct {
+		tx  *types.Transaction
+		err error
+	}{
+		{newTx(0, 30, signer), nil},
+		{newTx(0, 30, signer), ErrNonceTooLow},
+		{newTx(2, 30, signer), ErrNonceTooHigh},
+		{newTx(1, 80, signer), ErrInsufficientFunds},
+		{newTx(1, 30, types.NewSethSigner(big.NewInt(2))), ErrInvalidChainID},
+		{types.NewTransaction(to, big.NewInt(30), 1, TxGas, big.NewInt(1)), ErrInvalidSignature},
+		{lowGasTx, ErrIntrinsicGas},
+		{newTx(1, 70, signer), nil},
+	}
+	var usedGas uint64
+	for i, test := range tests {
+		receipt, err := ApplyTransaction(statedb, signer, nil, header, test.tx, &usedGas)
+		if err != test.err {
+			t.Fatalf("Error result of tx %d: %v,want %v", i, err, test.err)
+		}
+		// the failed transaction has no receipt since it is never included
+		if err != nil && receipt != nil {
+			t.Fatalf("Error: failed tx %d should have no receipt", i)
+		}
+		if err == nil && (receipt.Status != types.ReceiptStatusSuccessful || receipt.CumulativeGasUsed != usedGas || !receipt.Bloom.Test(addressTopic(to).Bytes())) {
+			t.Fatalf("Error receipt of tx %d: %+v", i, receipt)
+		}
+	}
+	if amount := statedb.GetAmount(from); amount.Sign() != 0 {
+		t.Fatalf("Error amount of sender: %v", amount)
+	}
+	if amount := statedb.GetAmount(to); amount.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("Error amount of recipient: %v", amount)
+	}
+	if nonce := statedb.GetNonce(from); nonce != 2 {
+		t.Fatalf("Error nonce of sender: %d", nonce)
+	}
+	if amount := statedb.GetAmount(coinbase); amount.Cmp(new(big.Int).SetUint64(2*TxGas)) != 0 {
+		t.Fatalf("Error fee of coinbase: %v", amount)
+	}
+	if usedGas != 2*TxGas {
+		t.Fatalf("Error used gas: %d", usedGas)
+	}
+
+	// gas limit of block is used up,the fee is credited to the given author
+	author := common.BytesToAddress([]byte("author"))
+	statedb.AddAmount(from, big.NewInt(100+2*int64(TxGas)))
+	if _, err := ApplyTransaction(statedb, signer, &author, header, newTx(2, 10, signer), &usedGas); err != nil {
+		t.Fatalf("Failed to apply tx: %v", err)
+	}
+	if amount := statedb.GetAmount(author); amount.Cmp(new(big.Int).SetUint64(TxGas)) != 0 {
+		t.Fatalf("Error fee of author: %v", amount)
+	}
+	if _, err := ApplyTransaction(statedb, signer, nil, header, newTx(3, 10, signer), &usedGas); err != ErrGasLimitReached {
+		t.Fatalf("Error result of tx exceeding block gas limit: %v", err)
+	}
+}
+
+func Test_ApplyBlock(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+
+	signer := types.NewSethSigner(big.NewInt(1))
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	statedb, _ := state.NewStatedb(common.Hash{}, trie.NewNodeDatabase(db))
+	statedb.AddAmount(from, big.NewInt(100+3*int64(TxGas)))
+	root, _ := statedb.Commit(nil)
+
+	txs := []*types.Transaction{}
+	for i := uint64(0); i < 3; i++ {
+		tx := types.NewTransaction(to, big.NewInt(10), i, TxGas, big.NewInt(1))
+		tx.Sign(signer, key)
+		txs = append(txs, tx)
+	}
+	header := &types.Header{Number: big.NewInt(1), GasLimit: GenesisGasLimit}
+	expect := statedb.Copy()
+	expectReceipts := types.Receipts{}
+	for _, tx := range txs {
+		receipt, _ := ApplyTransaction(expect, signer, nil, header, tx, &header.GasUsed)
+		expectReceipts = append(expectReceipts, receipt)
+	}
+	header.Bloom = types.CreateBloom(expectReceipts)
+	header.ReceiptHash = types.DeriveSha(expectReceipts)
+	header.Root = expect.IntermediateRoot()
+
+	block := types.NewBlock(header, txs)
+	receipts, err := ApplyBlock(statedb, signer, nil, block)
+	if err != nil {
+		t.Fatalf("Failed to apply block: %v", err)
+	}
+	if len(receipts) != len(txs) || receipts[2].TransactionIndex != 2 || receipts[2].Logs[0].Index != 2 {
+		t.Fatalf("Error receipts of block: %v", receipts)
+	}
+
+	statedb, _ = state.NewStatedb(root, statedb.Database())
+	header.GasUsed = 0
+	block = types.NewBlock(header, txs)
+	if _, err := ApplyBlock(statedb, signer, nil, block); err != ErrInvalidGasUsed {
+		t.Fatalf("Error result of block with invalid gas used: %v", err)
+	}
+
+	statedb, _ = state.NewStatedb(root, statedb.Database())
+	header.GasUsed = 3 * TxGas
+	header.ReceiptHash = types.EmptyRootHash
+	block = types.NewBlock(header, txs)
+	if _, err := ApplyBlock(statedb, signer, nil, block); err != ErrInvalidReceiptHash {
+		t.Fatalf("Error result of block with invalid receipts root: %v", err)
+	}
+
+	statedb, _ = state.NewStatedb(root, statedb.Database())
+	header.ReceiptHash = types.DeriveSha(expectReceipts)
+	header.Root = common.Hash{}
+	block = types.NewBlock(header, txs)
+	if _, err := ApplyBlock(statedb, signer, nil, block); err != ErrInvalidStateRoot {
+		t.Fatalf("Error result of block with invalid root: %v", err)
+	}
+}
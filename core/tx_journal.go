@@ -0,0 +1,118 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"os"
+	"seth/common"
+	"seth/core/types"
+	"seth/log"
+	"seth/rlp"
+)
+
+// errNoActiveJournal is returned if the transaction is inserted while the
+// journal is not open for writing
+var errNoActiveJournal = errors.New("no active journal")
+
+// txJournal is the RLP journal of local transactions,they are kept across
+// restarts of node
+type txJournal struct {
+	path   string
+	writer io.WriteCloser
+}
+
+// newTxJournal creates the journal of path,it is opened for writing after
+// loaded and rotated
+func newTxJournal(path string) *txJournal {
+	return &txJournal{path: path}
+}
+
+// load replays the transactions of journal with add,the broken tail of file is
+// ignored
+func (journal *txJournal) load(add func(tx *types.Transaction) error) error {
+	file, err := os.Open(journal.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var (
+		stream = rlp.NewStream(file, 0)
+		total  int
+		failed int
+	)
+	for {
+		tx := new(types.Transaction)
+		if err = stream.Decode(tx); err != nil {
+			break
+		}
+		total++
+		if err := add(tx); err != nil {
+			log.Debug("Failed to add journaled transaction %s: %v", tx.Hash().Hex(), err)
+			failed++
+		}
+	}
+	log.Info("Loaded local transaction journal,transactions %d dropped %d", total, failed)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// insert appends the transaction to journal
+func (journal *txJournal) insert(tx *types.Transaction) error {
+	if journal.writer == nil {
+		return errNoActiveJournal
+	}
+	return rlp.Encode(journal.writer, tx)
+}
+
+// rotate regenerates the journal with the transactions and opens it for
+// appending
+func (journal *txJournal) rotate(all map[common.Address]types.Transactions) error {
+	if journal.writer != nil {
+		if err := journal.writer.Close(); err != nil {
+			return err
+		}
+		journal.writer = nil
+	}
+	replacement, err := os.OpenFile(journal.path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, txs := range all {
+		for _, tx := range txs {
+			if err = rlp.Encode(replacement, tx); err != nil {
+				replacement.Close()
+				return err
+			}
+		}
+		count += len(txs)
+	}
+	replacement.Close()
+
+	if err = os.Rename(journal.path+".new", journal.path); err != nil {
+		return err
+	}
+	sink, err := os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	journal.writer = sink
+	log.Info("Regenerated local transaction journal,transactions %d accounts %d", count, len(all))
+	return nil
+}
+
+// close closes the file of journal
+func (journal *txJournal) close() error {
+	var err error
+	if journal.writer != nil {
+		err = journal.writer.Close()
+		journal.writer = nil
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"container/heap"
+	"math/big"
+	"seth/common"
+	"seth/core/types"
+	"sort"
+)
+
+// txList is the transactions of an account indexed by nonce,it is used for
+// both the executable and the future transactions of pool
+type txList struct {
+	strict bool // whether nonces are strictly continuous
+	txs    map[uint64]*types.Transaction
+}
+
+// newTxList create a new transaction list,the nonces of strict list are
+// continuous,so removing a transaction invalidates all the ones after it
+func newTxList(strict bool) *txList {
+	return &txList{
+		strict: strict,
+		txs:    make(map[uint64]*types.Transaction),
+	}
+}
+
+// Get returns the transaction of nonce
+func (l *txList) Get(nonce uint64) *types.Transaction {
+	return l.txs[nonce]
+}
+
+// Overlaps returns whether the list contains a transaction with the same
+// nonce as tx
+func (l *txList) Overlaps(tx *types.Transaction) bool {
+	return l.txs[tx.Data.AccountNonce] != nil
+}
+
+// Add inserts the transaction,it returns false if a transaction with the same
+// nonce exists
+func (l *txList) Add(tx *types.Transaction) bool {
+	if l.Overlaps(tx) {
+		return false
+	}
+	l.txs[tx.Data.AccountNonce] = tx
+	return true
+}
+
+// Replace replaces the transaction of the same nonce if the gas price of tx is
+// higher than the old one by priceBump percent,it returns the replaced
+// transaction. The gas limit is ignored since the fee is charged on the
+// intrinsic gas.
+func (l *txList) Replace(tx *types.Transaction, priceBump uint64) (*types.Transaction, bool) {
+	old := l.txs[tx.Data.AccountNonce]
+	if old == nil {
+		return nil, false
+	}
+	oldPrice, newPrice := old.Data.GasPrice, tx.Data.GasPrice
+	threshold := new(big.Int).Mul(oldPrice, big.NewInt(int64(100+priceBump)))
+	threshold.Div(threshold, big.NewInt(100))
+	if newPrice.Cmp(oldPrice) <= 0 || newPrice.Cmp(threshold) < 0 {
+		return nil, false
+	}
+	l.txs[tx.Data.AccountNonce] = tx
+	return old, true
+}
+
+// Remove deletes the transaction from list,it returns whether the transaction
+// is found and the transactions invalidated by the removal in strict mode
+func (l *txList) Remove(tx *types.Transaction) (bool, types.Transactions) {
+	nonce := tx.Data.AccountNonce
+	if l.txs[nonce] == nil {
+		return false, nil
+	}
+	delete(l.txs, nonce)
+	if !l.strict {
+		return true, nil
+	}
+	return true, l.filter(func(tx *types.Transaction) bool { return tx.Data.AccountNonce > nonce })
+}
+
+// Forward removes the transactions whose nonce is lower than threshold
+func (l *txList) Forward(threshold uint64) types.Transactions {
+	return l.filter(func(tx *types.Transaction) bool { return tx.Data.AccountNonce < threshold })
+}
+
+// Filter removes the transactions costing more than costLimit,in strict mode
+// the transactions after the lowest removed one are returned as invalids
+func (l *txList) Filter(costLimit *big.Int) (types.Transactions, types.Transactions) {
+	removed := l.filter(func(tx *types.Transaction) bool { return tx.Cost().Cmp(costLimit) > 0 })
+	if !l.strict || len(removed) == 0 {
+		return removed, nil
+	}
+	lowest := removed[0].Data.AccountNonce
+	invalids := l.filter(func(tx *types.Transaction) bool { return tx.Data.AccountNonce > lowest })
+	return removed, invalids
+}
+
+// Ready removes and returns the transactions with continuous nonces from start
+func (l *txList) Ready(start uint64) types.Transactions {
+	var ready types.Transactions
+	for nonce := start; l.txs[nonce] != nil; nonce++ {
+		ready = append(ready, l.txs[nonce])
+		delete(l.txs, nonce)
+	}
+	return ready
+}
+
+// Len returns the number of transactions
+func (l *txList) Len() int {
+	return len(l.txs)
+}
+
+// Empty returns whether the list is empty
+func (l *txList) Empty() bool {
+	return len(l.txs) == 0
+}
+
+// Flatten returns the transactions sorted by nonce
+func (l *txList) Flatten() types.Transactions {
+	txs := make(types.Transactions, 0, len(l.txs))
+	for _, tx := range l.txs {
+		txs = append(txs, tx)
+	}
+	sort.Sort(types.TxByNonce(txs))
+	return txs
+}
+
+// filter removes the transactions satisfying fn,they are returned in nonce
+// order
+func (l *txList) filter(fn func(tx *types.Transaction) bool) types.Transactions {
+	var removed types.Transactions
+	for nonce, tx := range l.txs {
+		if fn(tx) {
+			removed = append(removed, tx)
+			delete(l.txs, nonce)
+		}
+	}
+	sort.Sort(types.TxByNonce(removed))
+	return removed
+}
+
+// priceHeap is a heap of transactions with the lowest price first
+type priceHeap []*types.Transaction
+
+func (h priceHeap) Len() int           { return len(h) }
+func (h priceHeap) Less(i, j int) bool { return h[i].Data.GasPrice.Cmp(h[j].Data.GasPrice) < 0 }
+func (h priceHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *priceHeap) Push(x interface{}) {
+	*h = append(*h, x.(*types.Transaction))
+}
+
+func (h *priceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// txPricedList is the remote transactions of pool sorted by price,the ones
+// removed from pool are discarded lazily
+type txPricedList struct {
+	all    map[common.Hash]*types.Transaction // all transactions of pool
+	items  *priceHeap
+	stales int // number of removed transactions still in heap
+}
+
+// newTxPricedList creates a price sorted list on the transactions of pool
+func newTxPricedList(all map[common.Hash]*types.Transaction) *txPricedList {
+	return &txPricedList{
+		all:   all,
+		items: new(priceHeap),
+	}
+}
+
+// Put inserts the remote transaction
+func (l *txPricedList) Put(tx *types.Transaction) {
+	heap.Push(l.items, tx)
+}
+
+// Removed notifies that a transaction is removed from pool,the heap is
+// rebuilt once a quarter of it is stale
+func (l *txPricedList) Removed() {
+	l.stales++
+	if l.stales <= len(*l.items)/4 {
+		return
+	}
+	reheap := make(priceHeap, 0, len(*l.items))
+	for _, tx := range *l.items {
+		if l.all[tx.Hash()] == tx {
+			reheap = append(reheap, tx)
+		}
+	}
+	heap.Init(&reheap)
+	l.items, l.stales = &reheap, 0
+}
+
+// Cheapest returns the transaction of the lowest price satisfying remote,the
+// ones failing remote and the stale ones are discarded
+func (l *txPricedList) Cheapest(remote func(tx *types.Transaction) bool) *types.Transaction {
+	for len(*l.items) > 0 {
+		tx := (*l.items)[0]
+		if !remote(tx) {
+			// the sender turned local,its removals are not counted
+			heap.Pop(l.items)
+			continue
+		}
+		if l.all[tx.Hash()] != tx {
+			heap.Pop(l.items)
+			l.stales--
+			continue
+		}
+		return tx
+	}
+	return nil
+}
@@ -4,53 +4,675 @@ import (
 	"errors"
 	"seth/common"
 	"seth/config"
+	"seth/core/state"
 	"seth/core/types"
+	"seth/crypto"
+	"seth/event"
+	"seth/log"
 	"sync"
+	"time"
+)
+
+const (
+	// maxTxSize is the maximum size of the RLP encoding of transaction
+	maxTxSize = 32 * 1024
+
+	// evictionInterval is the interval to check the expired queued transactions
+	evictionInterval = time.Minute
 )
 
 var (
+	// ErrOversizedData is returned if the encoding of transaction is larger
+	// than the maximum size
+	ErrOversizedData = errors.New("oversized transaction data")
+	// ErrAccountLimitExceeded is returned if the sender has the maximum number
+	// of transactions in pool
+	ErrAccountLimitExceeded = errors.New("too many transactions of account in pool")
+	// ErrTxPoolFull is returned if the pool is full and the price of
+	// transaction is not higher than the cheapest remote one
+	ErrTxPoolFull = errors.New("transaction pool is full")
+	// ErrReplaceUnderpriced is returned if the gas price of transaction is not high
+	// enough to replace the pooled one of the same nonce
+	ErrReplaceUnderpriced = errors.New("replacement transaction underpriced")
+
 	errTxHashExists = errors.New("transaction hash already exists")
-	errTxPoolFull   = errors.New("transaction pool is full")
 )
 
-// TxPool transaction pool
+// TxPoolConfig is the config of transaction pool
+type TxPoolConfig struct {
+	Locals []common.Address // accounts exempt from the limits and eviction
+
+	AccountSlots uint64        // maximum number of transactions of an account
+	GlobalSlots  uint64        // maximum number of transactions of all accounts
+	Lifetime     time.Duration // maximum time an account is queued without new transactions
+
+	PriceBump uint64 // minimum gas price bump percentage to replace a transaction of the same nonce
+
+	Journal   string        // journal of local transactions,resolved by config.ResolvePath
+	Rejournal time.Duration // interval to regenerate the journal
+}
+
+// DefaultTxPoolConfig is the default config of transaction pool
+var DefaultTxPoolConfig = TxPoolConfig{
+	AccountSlots: 64,
+	GlobalSlots:  4096,
+	Lifetime:     3 * time.Hour,
+	PriceBump:    10,
+	Journal:      "transactions.rlp",
+	Rejournal:    time.Hour,
+}
+
+// sanitize replaces the invalid values with the default ones
+func (config *TxPoolConfig) sanitize() TxPoolConfig {
+	conf := *config
+	if conf.AccountSlots < 1 {
+		log.Warn("Invalid tx pool account slots %d,use default %d", conf.AccountSlots, DefaultTxPoolConfig.AccountSlots)
+		conf.AccountSlots = DefaultTxPoolConfig.AccountSlots
+	}
+	if conf.GlobalSlots < 1 {
+		log.Warn("Invalid tx pool global slots %d,use default %d", conf.GlobalSlots, DefaultTxPoolConfig.GlobalSlots)
+		conf.GlobalSlots = DefaultTxPoolConfig.GlobalSlots
+	}
+	if conf.Lifetime <= 0 {
+		log.Warn("Invalid tx pool lifetime %v,use default %v", conf.Lifetime, DefaultTxPoolConfig.Lifetime)
+		conf.Lifetime = DefaultTxPoolConfig.Lifetime
+	}
+	if conf.PriceBump < 1 {
+		log.Warn("Invalid tx pool price bump %d,use default %d", conf.PriceBump, DefaultTxPoolConfig.PriceBump)
+		conf.PriceBump = DefaultTxPoolConfig.PriceBump
+	}
+	if conf.Rejournal < time.Second {
+		log.Warn("Invalid tx pool rejournal %v,use default %v", conf.Rejournal, DefaultTxPoolConfig.Rejournal)
+		conf.Rejournal = DefaultTxPoolConfig.Rejournal
+	}
+	return conf
+}
+
+// TxPoolCounters is the counters of pool for monitoring
+type TxPoolCounters struct {
+	Pending int    // number of executable transactions
+	Queued  int    // number of future transactions
+	Dropped uint64 // number of transactions dropped by limits,eviction and expiration
+}
+
+// blockChain is the chain the transactions of pool are validated against
+type blockChain interface {
+	Config() *config.ChainConfig
+	CurrentBlock() *types.Block
+	StateAt(root common.Hash) (*state.Statedb, error)
+}
+
+// TxPool transaction pool,the transactions executable on the current state
+// are pending and the ones with future nonces are queued
 type TxPool struct {
-	mutex   sync.RWMutex
-	pending map[common.Hash]*types.Transaction
-	signer  types.Signer
+	config TxPoolConfig
+	mutex  sync.RWMutex
+	chain  blockChain
+	signer types.Signer
+
+	head         *types.Header  // head of chain the pool is reset to
+	currentState *state.Statedb // state of head
+
+	pending map[common.Address]*txList         // executable transactions by sender
+	queue   map[common.Address]*txList         // future transactions by sender
+	all     map[common.Hash]*types.Transaction // all transactions by hash
+	priced  *txPricedList                      // remote transactions by price for eviction
+
+	reinject types.Transactions // transactions dropped by reorganisation,reinjected on the next head
+
+	locals  map[common.Address]bool      // accounts exempt from the limits and eviction
+	beats   map[common.Address]time.Time // last time a transaction of account is added
+	dropped uint64                       // number of dropped transactions
+	journal *txJournal                   // journal of local transactions,nil if disabled
+
+	quit chan struct{}
+	wg   sync.WaitGroup
 }
 
-// NewTxPool new Tx Pool
-func NewTxPool() *TxPool {
+// NewTxPool new Tx Pool on the head of chain
+func NewTxPool(config *TxPoolConfig, chain blockChain) *TxPool {
 	pool := &TxPool{
-		signer:  types.NewSethSigner(config.Config.ChainID),
-		pending: make(map[common.Hash]*types.Transaction),
+		config:  config.sanitize(),
+		chain:   chain,
+		signer:  types.NewSethSigner(chain.Config().ChainID),
+		pending: make(map[common.Address]*txList),
+		queue:   make(map[common.Address]*txList),
+		all:     make(map[common.Hash]*types.Transaction),
+		locals:  make(map[common.Address]bool),
+		beats:   make(map[common.Address]time.Time),
+		quit:    make(chan struct{}),
 	}
+	pool.priced = newTxPricedList(pool.all)
+	for _, addr := range config.Locals {
+		pool.locals[addr] = true
+	}
+	pool.reset(chain.CurrentBlock().Header)
+	pool.openJournal()
+
+	// the transactions dropped by chain reorganisation are reinjected on the
+	// new head,the chain dispatches them before the head
+	dispatcher := event.SharedDispatcher()
+	dispatcher.AddListener(event.EventTxsDropped, event.Listener{Callable: pool.onTxsDropped})
+	dispatcher.AddListener(event.EventChainHead, event.Listener{Callable: pool.onChainHead})
+
+	pool.wg.Add(1)
+	go pool.loop()
 
 	return pool
 }
 
-// AddTx add transaction to pool
+// stopped reports whether the pool is stopped,the lock must be held
+func (pool *TxPool) stopped() bool {
+	select {
+	case <-pool.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// openJournal replays the journal of local transactions and regenerates it,
+// the journal is disabled if its path can not be resolved
+func (pool *TxPool) openJournal() {
+	if pool.config.Journal == "" {
+		return
+	}
+	path := config.ResolvePath(pool.config.Journal)
+	if path == "" {
+		log.Warn("Tx pool journal is disabled,no data dir for %s", pool.config.Journal)
+		return
+	}
+	pool.journal = newTxJournal(path)
+	if err := pool.journal.load(pool.AddLocal); err != nil {
+		log.Warn("Failed to load tx pool journal: %v", err)
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if err := pool.journal.rotate(pool.localTxs()); err != nil {
+		log.Warn("Failed to rotate tx pool journal: %v", err)
+	}
+}
+
+// Stop stops the pool from following the chain and evicting transactions.
+// The listeners stay in the dispatcher,which removes listeners by the code
+// pointer shared by the method values of all pools,they return on the stopped
+// pool instead.
+func (pool *TxPool) Stop() {
+	// the quit channel is closed under the lock,so that no listener resets
+	// the pool after Stop returns
+	pool.mutex.Lock()
+	close(pool.quit)
+	pool.mutex.Unlock()
+	pool.wg.Wait()
+
+	if pool.journal != nil {
+		pool.mutex.Lock()
+		pool.journal.close()
+		pool.mutex.Unlock()
+	}
+}
+
+// loop drops the expired queued transactions and regenerates the journal
+// periodically
+func (pool *TxPool) loop() {
+	defer pool.wg.Done()
+
+	ticker := time.NewTicker(evictionInterval)
+	defer ticker.Stop()
+	journal := time.NewTicker(pool.config.Rejournal)
+	defer journal.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pool.mutex.Lock()
+			pool.expire()
+			pool.mutex.Unlock()
+		case <-journal.C:
+			pool.mutex.Lock()
+			if pool.journal != nil {
+				if err := pool.journal.rotate(pool.localTxs()); err != nil {
+					log.Warn("Failed to rotate tx pool journal: %v", err)
+				}
+			}
+			pool.mutex.Unlock()
+		case <-pool.quit:
+			return
+		}
+	}
+}
+
+// localTxs returns the transactions of local accounts not yet included in
+// chain,both pending and queued
+func (pool *TxPool) localTxs() map[common.Address]types.Transactions {
+	txs := make(map[common.Address]types.Transactions)
+	for addr := range pool.locals {
+		if list := pool.pending[addr]; list != nil {
+			txs[addr] = append(txs[addr], list.Flatten()...)
+		}
+		if list := pool.queue[addr]; list != nil {
+			txs[addr] = append(txs[addr], list.Flatten()...)
+		}
+	}
+	return txs
+}
+
+// expire drops the queued transactions of the remote accounts which have no
+// new transactions within the lifetime
+func (pool *TxPool) expire() {
+	for addr, list := range pool.queue {
+		if pool.locals[addr] || time.Since(pool.beats[addr]) <= pool.config.Lifetime {
+			continue
+		}
+		for _, tx := range list.Flatten() {
+			log.Debug("Remove expired queued transaction %s", tx.Hash().Hex())
+			pool.drop(tx)
+		}
+		delete(pool.queue, addr)
+	}
+	for addr := range pool.beats {
+		if pool.pending[addr] == nil && pool.queue[addr] == nil {
+			delete(pool.beats, addr)
+		}
+	}
+}
+
+// onTxsDropped buffers the transactions dropped by chain reorganisation until
+// the pool is reset to the new head
+func (pool *TxPool) onTxsDropped(e event.Event) {
+	params, ok := e.(*event.ParamsEvent)
+	if !ok {
+		return
+	}
+	value, _ := params.GetParam("txs")
+	txs, ok := value.(types.Transactions)
+	if !ok {
+		return
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.stopped() {
+		return
+	}
+	pool.reinject = append(pool.reinject, txs...)
+}
+
+// onChainHead resets the pool to the new head of chain
+func (pool *TxPool) onChainHead(e event.Event) {
+	params, ok := e.(*event.ParamsEvent)
+	if !ok {
+		return
+	}
+	value, _ := params.GetParam("block")
+	block, ok := value.(*types.Block)
+	if !ok {
+		return
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.stopped() {
+		return
+	}
+	pool.reset(block.Header)
+}
+
+// reset updates the state of pool to newHead,the buffered transactions dropped
+// by reorganisation are reinjected,then the pending and queued transactions
+// are moved according to the new state
+func (pool *TxPool) reset(newHead *types.Header) {
+	reinject := pool.reinject
+	pool.reinject = nil
+
+	statedb, err := pool.chain.StateAt(newHead.Root)
+	if err != nil {
+		log.Error("Failed to reset tx pool state: %v", err)
+		return
+	}
+	pool.head = newHead
+	pool.currentState = statedb
+
+	// the reinjected transactions keep the origin of sender,the journal has
+	// the local ones already
+	for _, tx := range reinject {
+		from, _ := tx.Sender(pool.signer)
+		if _, err := pool.insert(tx, pool.locals[from]); err != nil {
+			log.Debug("Reinject transaction %s error: %v", tx.Hash().Hex(), err)
+		}
+	}
+	pool.demoteUnexecutables()
+
+	accounts := make([]common.Address, 0, len(pool.queue))
+	for addr := range pool.queue {
+		accounts = append(accounts, addr)
+	}
+	pool.promoteExecutables(accounts)
+}
+
+// AddTx add remote transaction to pool
 func (pool *TxPool) AddTx(tx *types.Transaction) error {
+	return pool.addTx(tx, false)
+}
+
+// AddLocal add transaction to pool,the sender becomes local and is exempt
+// from the limits and eviction
+func (pool *TxPool) AddLocal(tx *types.Transaction) error {
+	return pool.addTx(tx, true)
+}
+
+func (pool *TxPool) addTx(tx *types.Transaction, local bool) error {
 	if tx == nil {
 		return nil
 	}
-	err := pool.validateTx(tx)
+
+	pool.mutex.Lock()
+	replaced, err := pool.insert(tx, local)
 	if err != nil {
+		pool.mutex.Unlock()
 		return err
 	}
+	// the transactions of local senders are journaled,the reinjected ones are
+	// not inserted here since they are journaled already
+	if from, _ := tx.Sender(pool.signer); pool.locals[from] && pool.journal != nil {
+		if err := pool.journal.insert(tx); err != nil && err != errNoActiveJournal {
+			log.Warn("Failed to journal local transaction %s: %v", tx.Hash().Hex(), err)
+		}
+	}
+	pool.mutex.Unlock()
 
-	pool.mutex.Lock()
-	defer pool.mutex.Unlock()
+	// the event is dispatched after the pool is unlocked,so that the listeners
+	// can access the pool
+	if replaced != nil {
+		event.SharedDispatcher().Dispatch(event.NewParamsEvent(event.EventTxReplaced).SetParam("old", replaced).SetParam("new", tx))
+	}
+	return nil
+}
 
-	hash := tx.Hash()
-	if pool.pending[hash] != nil {
-		return errTxHashExists
+// insert validates and adds the transaction,it returns the replaced one of
+// the same nonce
+func (pool *TxPool) insert(tx *types.Transaction, local bool) (*types.Transaction, error) {
+	if err := pool.validateTx(tx, local); err != nil {
+		return nil, err
+	}
+	from, _ := tx.Sender(pool.signer)
+	local = local || pool.locals[from]
+
+	// make room for the remote transaction by evicting the cheapest one
+	if !local && uint64(len(pool.all)) >= pool.config.GlobalSlots && pool.all[tx.Hash()] == nil && pool.get(from, tx.Data.AccountNonce) == nil {
+		cheapest := pool.cheapestRemote()
+		if cheapest == nil || cheapest.Data.GasPrice.Cmp(tx.Data.GasPrice) >= 0 {
+			return nil, ErrTxPoolFull
+		}
+		log.Debug("Evict cheapest transaction %s", cheapest.Hash().Hex())
+		pool.removeTx(cheapest)
+	}
+	replaced, err := pool.add(tx, local)
+	if err != nil {
+		return nil, err
+	}
+	if local {
+		pool.locals[from] = true
+	}
+	pool.promoteExecutables([]common.Address{from})
+	return replaced, nil
+}
+
+// get returns the pooled transaction of sender and nonce
+func (pool *TxPool) get(from common.Address, nonce uint64) *types.Transaction {
+	if list := pool.pending[from]; list != nil && list.Get(nonce) != nil {
+		return list.Get(nonce)
+	}
+	if list := pool.queue[from]; list != nil {
+		return list.Get(nonce)
 	}
-	pool.pending[hash] = tx
 	return nil
 }
 
-func (pool *TxPool) validateTx(tx *types.Transaction) error {
+// cheapestRemote returns the transaction of the lowest price from the remote
+// accounts,the account added as local later is skipped
+func (pool *TxPool) cheapestRemote() *types.Transaction {
+	return pool.priced.Cheapest(func(tx *types.Transaction) bool {
+		from, _ := tx.Sender(pool.signer)
+		return !pool.locals[from]
+	})
+}
+
+// removeTx drops the transaction from pool,the pending transactions after it
+// are moved back to queue
+func (pool *TxPool) removeTx(tx *types.Transaction) {
+	from, _ := tx.Sender(pool.signer)
+	pool.drop(tx)
+	if list := pool.pending[from]; list != nil {
+		if removed, invalids := list.Remove(tx); removed {
+			for _, invalid := range invalids {
+				pool.enqueue(from, invalid)
+			}
+			if list.Empty() {
+				delete(pool.pending, from)
+			}
+			return
+		}
+	}
+	if list := pool.queue[from]; list != nil {
+		list.Remove(tx)
+		if list.Empty() {
+			delete(pool.queue, from)
+		}
+	}
+}
+
+// drop deletes the transaction from the index of pool and counts it
+func (pool *TxPool) drop(tx *types.Transaction) {
+	pool.forget(tx)
+	pool.dropped++
+}
+
+// forget deletes the transaction from the index of pool,the price list is
+// notified if the transaction is remote
+func (pool *TxPool) forget(tx *types.Transaction) {
+	delete(pool.all, tx.Hash())
+	if from, _ := tx.Sender(pool.signer); !pool.locals[from] {
+		pool.priced.Removed()
+	}
+}
+
+// enqueue inserts the transaction into the queue of sender
+func (pool *TxPool) enqueue(from common.Address, tx *types.Transaction) bool {
+	if pool.queue[from] == nil {
+		pool.queue[from] = newTxList(false)
+	}
+	return pool.queue[from].Add(tx)
+}
+
+// add inserts the transaction into the queue of its sender,or replaces the
+// pooled one of the same nonce in place if the gas price is bumped enough. The
+// remote transaction is tracked by price for eviction.
+func (pool *TxPool) add(tx *types.Transaction, local bool) (*types.Transaction, error) {
+	hash := tx.Hash()
+	if pool.all[hash] != nil {
+		return nil, errTxHashExists
+	}
+	from, err := tx.Sender(pool.signer)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	var replaced *types.Transaction
+	if old := pool.get(from, tx.Data.AccountNonce); old != nil {
+		list := pool.queue[from]
+		if pending := pool.pending[from]; pending != nil && pending.Overlaps(tx) {
+			list = pending
+		}
+		var ok bool
+		if replaced, ok = list.Replace(tx, pool.config.PriceBump); !ok {
+			return nil, ErrReplaceUnderpriced
+		}
+		log.Debug("Replace transaction %s with %s", replaced.Hash().Hex(), hash.Hex())
+		pool.forget(replaced)
+	} else {
+		pool.enqueue(from, tx)
+	}
+	pool.all[hash] = tx
+	if !local {
+		pool.priced.Put(tx)
+	}
+	pool.beats[from] = time.Now()
+	return replaced, nil
+}
+
+// pendingNonce returns the next nonce of sender after the pending
+// transactions,they are continuous from the nonce of state
+func (pool *TxPool) pendingNonce(addr common.Address) uint64 {
+	nonce := pool.currentState.GetNonce(addr)
+	if list := pool.pending[addr]; list != nil {
+		nonce += uint64(list.Len())
+	}
+	return nonce
+}
+
+// promoteExecutables moves the queued transactions of accounts which become
+// executable to pending,the stale and unaffordable ones are dropped
+func (pool *TxPool) promoteExecutables(accounts []common.Address) {
+	for _, addr := range accounts {
+		list := pool.queue[addr]
+		if list == nil {
+			continue
+		}
+		for _, tx := range list.Forward(pool.currentState.GetNonce(addr)) {
+			log.Debug("Remove stale queued transaction %s", tx.Hash().Hex())
+			pool.forget(tx)
+		}
+		drops, _ := list.Filter(pool.currentState.GetAmount(addr))
+		for _, tx := range drops {
+			log.Debug("Remove unaffordable queued transaction %s", tx.Hash().Hex())
+			pool.drop(tx)
+		}
+		if ready := list.Ready(pool.pendingNonce(addr)); len(ready) > 0 {
+			if pool.pending[addr] == nil {
+				pool.pending[addr] = newTxList(true)
+			}
+			for _, tx := range ready {
+				pool.pending[addr].Add(tx)
+			}
+		}
+		if list.Empty() {
+			delete(pool.queue, addr)
+		}
+	}
+}
+
+// demoteUnexecutables removes the pending transactions included in chain or
+// unaffordable,the following ones are moved back to queue
+func (pool *TxPool) demoteUnexecutables() {
+	for addr, list := range pool.pending {
+		nonce := pool.currentState.GetNonce(addr)
+		for _, tx := range list.Forward(nonce) {
+			pool.forget(tx)
+		}
+		drops, invalids := list.Filter(pool.currentState.GetAmount(addr))
+		for _, tx := range drops {
+			log.Debug("Remove unaffordable pending transaction %s", tx.Hash().Hex())
+			pool.drop(tx)
+		}
+		// the pending transactions must start from the nonce of state
+		if list.Len() > 0 && list.Get(nonce) == nil {
+			invalids = append(invalids, list.filter(func(*types.Transaction) bool { return true })...)
+		}
+		for _, tx := range invalids {
+			pool.enqueue(addr, tx)
+		}
+		if list.Empty() {
+			delete(pool.pending, addr)
+		}
+	}
+}
+
+// Get returns the transaction of hash in pool
+func (pool *TxPool) Get(hash common.Hash) *types.Transaction {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	return pool.all[hash]
+}
+
+// Pending returns the executable transactions in pool grouped by sender in
+// nonce order
+func (pool *TxPool) Pending() (map[common.Address]types.Transactions, error) {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	pending := make(map[common.Address]types.Transactions, len(pool.pending))
+	for addr, list := range pool.pending {
+		pending[addr] = list.Flatten()
+	}
+	return pending, nil
+}
+
+// Counters returns the counters of pool
+func (pool *TxPool) Counters() TxPoolCounters {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	counters := TxPoolCounters{Dropped: pool.dropped}
+	for _, list := range pool.pending {
+		counters.Pending += list.Len()
+	}
+	for _, list := range pool.queue {
+		counters.Queued += list.Len()
+	}
+	return counters
+}
+
+// validateTx checks the transaction against the rules of chain and the current
+// state,it must be called with the lock of pool held
+func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
+	if tx.Size() > maxTxSize {
+		return ErrOversizedData
+	}
+	if tx.Data.To == nil {
+		return ErrNilRecipient
+	}
+	if tx.Data.Amount.Sign() < 0 || tx.Data.GasPrice.Sign() < 0 {
+		return ErrNegativeAmount
+	}
+	if tx.Data.GasLimit < IntrinsicGas(tx) {
+		return ErrIntrinsicGas
+	}
+
+	if tx.Data.Signature == nil {
+		return ErrInvalidSignature
+	}
+	// the malleable signatures are rejected,the recovery id is checked when
+	// the sender is recovered
+	r, s, _ := tx.Data.Signature.RSV()
+	if !crypto.ValidateSignatureValues(0, r, s) {
+		return ErrInvalidSignature
+	}
+	from, err := tx.Sender(pool.signer)
+	if err == types.ErrInvalidChainID {
+		return ErrInvalidChainID
+	}
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if tx.Data.AccountNonce < pool.currentState.GetNonce(from) {
+		return ErrNonceTooLow
+	}
+	if pool.currentState.GetAmount(from).Cmp(tx.Cost()) < 0 {
+		return ErrInsufficientFunds
+	}
+	if local || pool.locals[from] {
+		return nil
+	}
+	count := 0
+	if list := pool.pending[from]; list != nil {
+		count += list.Len()
+	}
+	if list := pool.queue[from]; list != nil {
+		count += list.Len()
+	}
+	if uint64(count) >= pool.config.AccountSlots && pool.get(from, tx.Data.AccountNonce) == nil {
+		return ErrAccountLimitExceeded
+	}
 	return nil
 }
@@ -0,0 +1,449 @@
+package core
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"seth/accounts"
+	"seth/common"
+	"seth/common/math"
+	"seth/core/types"
+	"seth/crypto"
+	"seth/event"
+	"seth/trie"
+	"testing"
+	"time"
+)
+
+func newTestPoolTx(t *testing.T, key *crypto.PrivateKey, to common.Address, nonce uint64, amount, price int64) *types.Transaction {
+	tx := types.NewTransaction(to, big.NewInt(amount), nonce, TxGas, big.NewInt(price))
+	if err := tx.Sign(testSigner, key); err != nil {
+		t.Fatalf("Failed to sign tx: %v", err)
+	}
+	return tx
+}
+
+func Test_TxPool_Promote(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+	defer event.SharedDispatcher().RemoveAll(event.EventChainHead)
+	defer event.SharedDispatcher().RemoveAll(event.EventTxsDropped)
+
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(1000000)})
+	pool := NewTxPool(&DefaultTxPoolConfig, bc)
+	defer pool.Stop()
+
+	txs := []*types.Transaction{
+		newTestTransfer(t, key, to, 1),
+		newTestTransfer(t, key, to, 2),
+		newTestTransfer(t, key, to, 0),
+	}
+	for _, tx := range txs[:2] {
+		if err := pool.AddTx(tx); err != nil {
+			t.Fatalf("Failed to add tx: %v", err)
+		}
+	}
+	if c := pool.Counters(); c.Pending != 0 || c.Queued != 2 {
+		t.Fatalf("Error stats with nonce gap,pending %d queued %d", c.Pending, c.Queued)
+	}
+	if err := pool.AddTx(txs[0]); err != errTxHashExists {
+		t.Fatalf("expected error %v,got %v", errTxHashExists, err)
+	}
+
+	// the gap is filled
+	if err := pool.AddTx(txs[2]); err != nil {
+		t.Fatalf("Failed to add tx: %v", err)
+	}
+	if c := pool.Counters(); c.Pending != 3 || c.Queued != 0 {
+		t.Fatalf("Error stats after gap filled,pending %d queued %d", c.Pending, c.Queued)
+	}
+	pending, _ := pool.Pending()
+	if len(pending) != 1 || len(pending[from]) != 3 {
+		t.Fatalf("Error pending transactions: %v", pending)
+	}
+	for i, tx := range pending[from] {
+		if tx.Data.AccountNonce != uint64(i) {
+			t.Fatalf("Error nonce of pending transaction %d,got %d", i, tx.Data.AccountNonce)
+		}
+	}
+	if err := pool.AddTx(newTestPoolTx(t, key, to, 1, 20, 1)); err != ErrReplaceUnderpriced {
+		t.Fatalf("expected error %v,got %v", ErrReplaceUnderpriced, err)
+	}
+}
+
+func Test_TxPool_Stop(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+	defer event.SharedDispatcher().RemoveAll(event.EventChainHead)
+	defer event.SharedDispatcher().RemoveAll(event.EventTxsDropped)
+
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(1000000)})
+	pool := NewTxPool(&DefaultTxPoolConfig, bc)
+	tx := newTestTransfer(t, key, to, 0)
+	if err := pool.AddTx(tx); err != nil {
+		t.Fatalf("Failed to add tx: %v", err)
+	}
+	pool.Stop()
+
+	// the stopped pool ignores the events of chain
+	genesis := bc.CurrentBlock()
+	block := makeTestBlock(t, trie.NewNodeDatabase(db), genesis, []*types.Transaction{tx})
+	if _, err := bc.InsertChain([]*types.Block{block}); err != nil {
+		t.Fatalf("Failed to insert block: %v", err)
+	}
+	event.SharedDispatcher().Dispatch(event.NewParamsEvent(event.EventTxsDropped).SetParam("txs", types.Transactions{tx}))
+	if pool.head.Hash() != genesis.Hash() || pool.Get(tx.Hash()) == nil || len(pool.reinject) != 0 {
+		t.Fatalf("Error: the stopped pool should not follow the chain")
+	}
+}
+
+func Test_TxPool_Demote(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+	defer event.SharedDispatcher().RemoveAll(event.EventChainHead)
+	defer event.SharedDispatcher().RemoveAll(event.EventTxsDropped)
+
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(1000000)})
+	gen := trie.NewNodeDatabase(db)
+	pool := NewTxPool(&DefaultTxPoolConfig, bc)
+	defer pool.Stop()
+
+	txs := []*types.Transaction{
+		newTestPoolTx(t, key, to, 0, 10, 1),
+		newTestPoolTx(t, key, to, 1, 500000, 1),
+		newTestPoolTx(t, key, to, 2, 10, 1),
+	}
+	for _, tx := range txs {
+		if err := pool.AddTx(tx); err != nil {
+			t.Fatalf("Failed to add tx: %v", err)
+		}
+	}
+
+	// another transaction of nonce 0 spends most of the balance,the pending
+	// transaction of nonce 1 becomes unaffordable and nonce 2 is not executable
+	spend := newTestPoolTx(t, key, to, 0, 900000, 1)
+	block := makeTestBlock(t, gen, bc.CurrentBlock(), []*types.Transaction{spend})
+	if _, err := bc.InsertChain([]*types.Block{block}); err != nil {
+		t.Fatalf("Failed to insert block: %v", err)
+	}
+	if pool.head.Hash() != block.Hash() {
+		t.Fatalf("Error: pool is not reset to the new head")
+	}
+	if c := pool.Counters(); c.Pending != 0 || c.Queued != 1 || c.Dropped != 1 {
+		t.Fatalf("Error counters after new head: %+v", c)
+	}
+	if pool.Get(txs[0].Hash()) != nil || pool.Get(txs[1].Hash()) != nil {
+		t.Fatalf("Error: invalidated transactions should be removed")
+	}
+	if pool.queue[from] == nil || pool.queue[from].Get(2) != txs[2] {
+		t.Fatalf("Error: transaction after the gap should be queued")
+	}
+
+	// the gap is filled again
+	if err := pool.AddTx(newTestPoolTx(t, key, to, 1, 10, 1)); err != nil {
+		t.Fatalf("Failed to add tx: %v", err)
+	}
+	if c := pool.Counters(); c.Pending != 2 || c.Queued != 0 {
+		t.Fatalf("Error stats after gap filled,pending %d queued %d", c.Pending, c.Queued)
+	}
+}
+
+func Test_TxPool_Validate(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+	defer event.SharedDispatcher().RemoveAll(event.EventChainHead)
+	defer event.SharedDispatcher().RemoveAll(event.EventTxsDropped)
+
+	from, key := accounts.NewRandomAccount()
+	poor, poorKey := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(1000000000), poor: big.NewInt(1)})
+	gen := trie.NewNodeDatabase(db)
+	block := makeTestBlock(t, gen, bc.CurrentBlock(), []*types.Transaction{newTestTransfer(t, key, to, 0)})
+	if _, err := bc.InsertChain([]*types.Block{block}); err != nil {
+		t.Fatalf("Failed to insert block: %v", err)
+	}
+	pool := NewTxPool(&DefaultTxPoolConfig, bc)
+	defer pool.Stop()
+
+	otherChain := types.NewTransaction(to, big.NewInt(10), 1, TxGas, big.NewInt(1))
+	if err := otherChain.Sign(types.NewSethSigner(big.NewInt(2)), key); err != nil {
+		t.Fatalf("Failed to sign tx: %v", err)
+	}
+	// the signature with s in the upper range is malleable
+	highS := newTestTransfer(t, key, to, 1)
+	s := new(big.Int).SetBytes(highS.Data.Signature[32:64])
+	s.Sub(crypto.S256().Params().N, s)
+	copy(highS.Data.Signature[32:64], math.PaddedBigBytes(s, 32))
+	unsigned := types.NewTransaction(to, big.NewInt(10), 1, TxGas, big.NewInt(1))
+	lowGas := types.NewTransaction(to, big.NewInt(10), 1, TxGas-1, big.NewInt(1))
+	if err := lowGas.Sign(testSigner, key); err != nil {
+		t.Fatalf("Failed to sign tx: %v", err)
+	}
+
+	tests := []struct {
+		tx  *types.Transaction
+		err error
+	}{
+		{newTestTransfer(t, key, to, 0), ErrNonceTooLow},
+		{newTestTransfer(t, poorKey, to, 0), ErrInsufficientFunds},
+		{otherChain, ErrInvalidChainID},
+		{highS, ErrInvalidSignature},
+		{unsigned, ErrInvalidSignature},
+		{lowGas, ErrIntrinsicGas},
+		{newTestTransfer(t, key, to, 1), nil},
+	}
+	for i, test := range tests {
+		if err := pool.AddTx(test.tx); err != test.err {
+			t.Fatalf("Error validation of tx %d,got %v,expected %v", i, err, test.err)
+		}
+	}
+
+	// the number of transactions of an account is limited
+	for nonce := uint64(2); nonce <= DefaultTxPoolConfig.AccountSlots; nonce++ {
+		if err := pool.AddTx(newTestTransfer(t, key, to, nonce)); err != nil {
+			t.Fatalf("Failed to add tx %d: %v", nonce, err)
+		}
+	}
+	if err := pool.AddTx(newTestTransfer(t, key, to, DefaultTxPoolConfig.AccountSlots+1)); err != ErrAccountLimitExceeded {
+		t.Fatalf("expected error %v,got %v", ErrAccountLimitExceeded, err)
+	}
+}
+
+func Test_TxPool_Limits(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+	defer event.SharedDispatcher().RemoveAll(event.EventChainHead)
+	defer event.SharedDispatcher().RemoveAll(event.EventTxsDropped)
+
+	var (
+		addrs = make([]common.Address, 4)
+		keys  = make([]*crypto.PrivateKey, 4)
+		alloc = make(map[common.Address]*big.Int)
+	)
+	for i := range keys {
+		addrs[i], keys[i] = accounts.NewRandomAccount()
+		alloc[addrs[i]] = big.NewInt(1000000)
+	}
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, alloc)
+	pool := NewTxPool(&TxPoolConfig{Locals: []common.Address{addrs[3]}, AccountSlots: 2, GlobalSlots: 4, Lifetime: time.Millisecond}, bc)
+	defer pool.Stop()
+
+	// the amounts are different since the hash of transaction excludes the
+	// signature
+	add := func(i int, nonce uint64, price int64, expected error) *types.Transaction {
+		tx := newTestPoolTx(t, keys[i], to, nonce, int64(10+i), price)
+		if err := pool.AddTx(tx); err != expected {
+			t.Fatalf("Error adding tx of nonce %d price %d,got %v,expected %v", nonce, price, err, expected)
+		}
+		return tx
+	}
+	cheapest := add(0, 0, 1, nil)
+	next := add(0, 1, 2, nil)
+	add(0, 2, 2, ErrAccountLimitExceeded)
+	add(1, 0, 3, nil)
+	add(1, 1, 3, nil)
+
+	// the pool is full,only the transaction pricier than the cheapest remote
+	// one is accepted
+	add(2, 0, 1, ErrTxPoolFull)
+	add(2, 0, 2, nil)
+	if pool.Get(cheapest.Hash()) != nil {
+		t.Fatalf("Error: the cheapest transaction should be evicted")
+	}
+	if pool.queue[addrs[0]] == nil || pool.queue[addrs[0]].Get(1) != next {
+		t.Fatalf("Error: the transaction after the evicted one should be queued")
+	}
+	if c := pool.Counters(); c.Pending != 3 || c.Queued != 1 || c.Dropped != 1 {
+		t.Fatalf("Error counters after eviction: %+v", c)
+	}
+
+	// the local account is exempt from the limits
+	for _, nonce := range []uint64{0, 1, 2, 5} {
+		add(3, nonce, 1, nil)
+	}
+	if c := pool.Counters(); c.Pending != 6 || c.Queued != 2 {
+		t.Fatalf("Error counters after local transactions: %+v", c)
+	}
+
+	// the queued remote transactions expire
+	time.Sleep(10 * time.Millisecond)
+	pool.mutex.Lock()
+	pool.expire()
+	pool.mutex.Unlock()
+	if pool.Get(next.Hash()) != nil {
+		t.Fatalf("Error: the queued remote transaction should expire")
+	}
+	if c := pool.Counters(); c.Pending != 6 || c.Queued != 1 || c.Dropped != 2 {
+		t.Fatalf("Error counters after expiration: %+v", c)
+	}
+
+	// the transaction reinjected after reorganisation is subject to the limits
+	reinjected := newTestPoolTx(t, keys[2], to, 1, 12, 1)
+	pool.mutex.Lock()
+	pool.reinject = types.Transactions{reinjected}
+	pool.reset(bc.CurrentBlock().Header)
+	pool.mutex.Unlock()
+	if pool.Get(reinjected.Hash()) != nil {
+		t.Fatalf("Error: the reinjected transaction should be rejected by the full pool")
+	}
+	// the reinjected transaction of local account stays local
+	reinjected = newTestPoolTx(t, keys[3], to, 3, 13, 1)
+	pool.mutex.Lock()
+	items, stales := len(*pool.priced.items), pool.priced.stales
+	pool.reinject = types.Transactions{reinjected}
+	pool.reset(bc.CurrentBlock().Header)
+	pool.mutex.Unlock()
+	if pool.Get(reinjected.Hash()) == nil || len(*pool.priced.items) != items {
+		t.Fatalf("Error: the reinjected local transaction should be accepted out of the price list")
+	}
+	// the removal of local transaction is not counted by the price list,the
+	// evicted transactions are pushed back so that one removal does not
+	// rebuild the list
+	pool.mutex.Lock()
+	for i := 0; i < 4; i++ {
+		pool.priced.Put(cheapest)
+	}
+	pool.removeTx(reinjected)
+	pool.mutex.Unlock()
+	if pool.priced.stales != stales {
+		t.Fatalf("Error stales of price list,got %d,expected %d", pool.priced.stales, stales)
+
+	}
+}
+
+func Test_TxPool_Replace(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+	dispatcher := event.SharedDispatcher()
+	defer dispatcher.RemoveAll(event.EventChainHead)
+	defer dispatcher.RemoveAll(event.EventTxsDropped)
+	defer dispatcher.RemoveAll(event.EventTxReplaced)
+
+	from, key := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{from: big.NewInt(100000000)})
+	pool := NewTxPool(&DefaultTxPoolConfig, bc)
+	defer pool.Stop()
+
+	var replaced [][2]*types.Transaction
+	dispatcher.AddListener(event.EventTxReplaced, event.Listener{Callable: func(e event.Event) {
+		params := e.(*event.ParamsEvent)
+		old, _ := params.GetParam("old")
+		tx, _ := params.GetParam("new")
+		replaced = append(replaced, [2]*types.Transaction{old.(*types.Transaction), tx.(*types.Transaction)})
+	}})
+
+	for _, nonce := range []uint64{0, 2} {
+		old := newTestPoolTx(t, key, to, nonce, 10, 10)
+		if err := pool.AddTx(old); err != nil {
+			t.Fatalf("Failed to add tx: %v", err)
+		}
+		// the fee must be higher than the old one by the price bump
+		if err := pool.AddTx(newTestPoolTx(t, key, to, nonce, 20, 10)); err != ErrReplaceUnderpriced {
+			t.Fatalf("expected error %v,got %v", ErrReplaceUnderpriced, err)
+		}
+		// raising only the gas limit does not pay more
+		spam := types.NewTransaction(to, big.NewInt(10), nonce, 2*TxGas, big.NewInt(10))
+		if err := spam.Sign(testSigner, key); err != nil {
+			t.Fatalf("Failed to sign tx: %v", err)
+		}
+		if err := pool.AddTx(spam); err != ErrReplaceUnderpriced {
+			t.Fatalf("expected error %v for raised gas limit,got %v", ErrReplaceUnderpriced, err)
+		}
+		tx := newTestPoolTx(t, key, to, nonce, 10, 11)
+		if err := pool.AddTx(tx); err != nil {
+			t.Fatalf("Failed to replace tx of nonce %d: %v", nonce, err)
+		}
+		if pool.Get(old.Hash()) != nil || pool.get(from, nonce) != tx {
+			t.Fatalf("Error: tx of nonce %d is not replaced", nonce)
+		}
+		if len(replaced) == 0 || replaced[len(replaced)-1] != [2]*types.Transaction{old, tx} {
+			t.Fatalf("Error replacement events: %v", replaced)
+		}
+	}
+	if pool.pending[from].Get(0) == nil || pool.queue[from].Get(2) == nil {
+		t.Fatalf("Error: the replacements should stay pending and queued")
+	}
+	if c := pool.Counters(); c.Pending != 1 || c.Queued != 1 || c.Dropped != 0 {
+		t.Fatalf("Error counters after replacement: %+v", c)
+	}
+}
+
+func Test_TxPool_Journal(t *testing.T) {
+	db, remove := newTestCoreDB()
+	defer remove()
+	defer event.SharedDispatcher().RemoveAll(event.EventChainHead)
+	defer event.SharedDispatcher().RemoveAll(event.EventTxsDropped)
+	dir, err := ioutil.TempDir("", "testtxjournal")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	local, localKey := accounts.NewRandomAccount()
+	remote, remoteKey := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	bc := newTestBlockChain(t, db, map[common.Address]*big.Int{local: big.NewInt(1000000), remote: big.NewInt(1000000)})
+	conf := DefaultTxPoolConfig
+	conf.Journal = filepath.Join(dir, "transactions.rlp")
+
+	pool := NewTxPool(&conf, bc)
+	txs := []*types.Transaction{
+		newTestPoolTx(t, localKey, to, 0, 10, 1),
+		newTestPoolTx(t, localKey, to, 1, 10, 1),
+		newTestPoolTx(t, localKey, to, 3, 10, 1),
+	}
+	for _, tx := range txs {
+		if err := pool.AddLocal(tx); err != nil {
+			t.Fatalf("Failed to add local tx: %v", err)
+		}
+	}
+	if err := pool.AddTx(newTestPoolTx(t, remoteKey, to, 0, 20, 1)); err != nil {
+		t.Fatalf("Failed to add remote tx: %v", err)
+	}
+	pool.Stop()
+
+	// only the local transactions are restored
+	pool = NewTxPool(&conf, bc)
+	if c := pool.Counters(); c.Pending != 2 || c.Queued != 1 || !pool.locals[local] || pool.locals[remote] {
+		t.Fatalf("Error pool restored from journal: %+v", c)
+	}
+
+	// the included transaction is removed from journal by rotation
+	gen := trie.NewNodeDatabase(db)
+	block := makeTestBlock(t, gen, bc.CurrentBlock(), txs[:1])
+	if _, err := bc.InsertChain([]*types.Block{block}); err != nil {
+		t.Fatalf("Failed to insert block: %v", err)
+	}
+	pool.mutex.Lock()
+	if err := pool.journal.rotate(pool.localTxs()); err != nil {
+		t.Fatalf("Failed to rotate journal: %v", err)
+	}
+	pool.mutex.Unlock()
+	pool.Stop()
+
+	var journaled []*types.Transaction
+	if err := newTxJournal(conf.Journal).load(func(tx *types.Transaction) error {
+		journaled = append(journaled, tx)
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to load journal: %v", err)
+	}
+	if len(journaled) != 2 {
+		t.Fatalf("Error count of journaled transactions,got %d,expected 2", len(journaled))
+	}
+	for _, tx := range journaled {
+		if tx.Hash() == txs[0].Hash() {
+			t.Fatalf("Error: included transaction should not be journaled")
+		}
+	}
+}
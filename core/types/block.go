@@ -26,16 +26,20 @@ func (n BlockNonce) Uint64() uint64 {
 
 // Header Block Header
 type Header struct {
-	ParentHash common.Hash    `json:"parentHash"       gencodec:"required"`
-	Coinbase   common.Address `json:"miner"            gencodec:"required"`
-	Root       common.Hash    `json:"stateRoot"        gencodec:"required"`
-	TxHash     common.Hash    `json:"transactionsRoot" gencodec:"required"`
-	Difficulty *big.Int       `json:"difficulty"       gencodec:"required"`
-	Number     *big.Int       `json:"number"           gencodec:"required"`
-	Time       *big.Int       `json:"timestamp"        gencodec:"required"`
-	Extra      []byte         `json:"extraData"        gencodec:"required"`
-	MixDigest  common.Hash    `json:"mixHash"          gencodec:"required"`
-	Nonce      BlockNonce     `json:"nonce"            gencodec:"required"`
+	ParentHash  common.Hash    `json:"parentHash"       gencodec:"required"`
+	Coinbase    common.Address `json:"miner"            gencodec:"required"`
+	Root        common.Hash    `json:"stateRoot"        gencodec:"required"`
+	TxHash      common.Hash    `json:"transactionsRoot" gencodec:"required"`
+	ReceiptHash common.Hash    `json:"receiptsRoot"     gencodec:"required"`
+	Bloom       Bloom          `json:"logsBloom"        gencodec:"required"`
+	Difficulty  *big.Int       `json:"difficulty"       gencodec:"required"`
+	Number      *big.Int       `json:"number"           gencodec:"required"`
+	GasLimit    uint64         `json:"gasLimit"         gencodec:"required"`
+	GasUsed     uint64         `json:"gasUsed"          gencodec:"required"`
+	Time        *big.Int       `json:"timestamp"        gencodec:"required"`
+	Extra       []byte         `json:"extraData"        gencodec:"required"`
+	MixDigest   common.Hash    `json:"mixHash"          gencodec:"required"`
+	Nonce       BlockNonce     `json:"nonce"            gencodec:"required"`
 }
 
 // Clone  clone block header
@@ -62,6 +66,25 @@ func (h *Header) Hash() common.Hash {
 	return crypto.RlpHash(h)
 }
 
+// HashNoNonce returns the hash which is used as input for the proof-of-work
+// search,the nonce and mix digest are not included
+func (h *Header) HashNoNonce() common.Hash {
+	return crypto.RlpHash([]interface{}{
+		h.ParentHash,
+		h.Coinbase,
+		h.Root,
+		h.TxHash,
+		h.ReceiptHash,
+		h.Bloom,
+		h.Difficulty,
+		h.Number,
+		h.GasLimit,
+		h.GasUsed,
+		h.Time,
+		h.Extra,
+	})
+}
+
 // Body block body struct
 type Body struct {
 	Transactions []*Transaction
@@ -91,11 +114,9 @@ func NewBlock(header *Header, txs []*Transaction) *Block {
 	block := &Block{Header: header.Clone(), td: new(big.Int)}
 
 	if len(txs) == 0 {
-		// TODO add calc TxHash by trie
-		//block.header.TxHash = EmptyRootHash
+		block.Header.TxHash = EmptyRootHash
 	} else {
-		// TODO add calc TxHash by trie
-		//block.header.TxHash = DeriveSha(Transactions(txs))
+		block.Header.TxHash = DeriveSha(Transactions(txs))
 		block.transactions = make(Transactions, len(txs))
 		copy(block.transactions, txs)
 	}
@@ -103,10 +124,31 @@ func NewBlock(header *Header, txs []*Transaction) *Block {
 	return block
 }
 
+// NewBlockWithHeader new block with the header,the header is not modified
 func NewBlockWithHeader(header *Header) *Block {
 	return &Block{Header: header.Clone(), td: new(big.Int)}
 }
 
+// WithBody return a new block with the header of b and the transactions
+func (b *Block) WithBody(transactions []*Transaction) *Block {
+	block := &Block{
+		Header:       b.Header.Clone(),
+		transactions: make(Transactions, len(transactions)),
+		td:           new(big.Int),
+	}
+	copy(block.transactions, transactions)
+	return block
+}
+
+// WithSeal return a new block with the sealed header and the transactions of b
+func (b *Block) WithSeal(header *Header) *Block {
+	return &Block{
+		Header:       header.Clone(),
+		transactions: b.transactions,
+		td:           new(big.Int),
+	}
+}
+
 // Hash returns the keccak256 hash of block's header.
 func (b *Block) Hash() common.Hash {
 	if hash := b.hash.Load(); hash != nil {
@@ -127,6 +169,9 @@ func (b *Block) FindTransaction(hash common.Hash) *Transaction {
 	return nil
 }
 
+// Transactions return the transactions of block
+func (b *Block) Transactions() Transactions { return b.transactions }
+
 // NumberU64 return the number of block
 func (b *Block) NumberU64() uint64 { return b.Header.Number.Uint64() }
 
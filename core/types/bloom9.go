@@ -0,0 +1,92 @@
+package types
+
+import (
+	"math/big"
+	"seth/crypto"
+)
+
+const (
+	// BloomByteLength represents the number of bytes used in a header log bloom.
+	BloomByteLength = 256
+	// BloomBitLength represents the number of bits used in a header log bloom.
+	BloomBitLength = 8 * BloomByteLength
+)
+
+// Bloom represents a 2048 bit bloom filter of log addresses and topics
+type Bloom [BloomByteLength]byte
+
+// BytesToBloom converts a byte slice to a bloom filter.
+func BytesToBloom(b []byte) Bloom {
+	var bloom Bloom
+	bloom.SetBytes(b)
+	return bloom
+}
+
+// SetBytes sets the content of b to the given bytes,b is cropped from the left
+// if it is larger than bloom
+func (b *Bloom) SetBytes(d []byte) {
+	if len(d) > len(b) {
+		d = d[len(d)-BloomByteLength:]
+	}
+	copy(b[BloomByteLength-len(d):], d)
+}
+
+// Add adds d to the filter.
+func (b *Bloom) Add(d []byte) {
+	hash := crypto.Keccak256(d)
+	// set 3 bits of 2048 with the first 3 pairs of bytes of hash
+	for i := 0; i < 6; i += 2 {
+		bit := (uint(hash[i])<<8 | uint(hash[i+1])) & (BloomBitLength - 1)
+		b[BloomByteLength-1-bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Or merges the bits of other bloom into b
+func (b *Bloom) Or(other Bloom) {
+	for i := range b {
+		b[i] |= other[i]
+	}
+}
+
+// Test checks if d may be in the filter
+func (b Bloom) Test(d []byte) bool {
+	var test Bloom
+	test.Add(d)
+	for i := range test {
+		if test[i]&b[i] != test[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Big converts b to a big integer.
+func (b Bloom) Big() *big.Int {
+	return new(big.Int).SetBytes(b[:])
+}
+
+// Bytes return bloom bytes
+func (b Bloom) Bytes() []byte {
+	return b[:]
+}
+
+// LogsBloom create the bloom of the addresses and topics of logs
+func LogsBloom(logs []*Log) Bloom {
+	var bloom Bloom
+	for _, log := range logs {
+		bloom.Add(log.Address[:])
+		for _, topic := range log.Topics {
+			bloom.Add(topic[:])
+		}
+	}
+	return bloom
+}
+
+// CreateBloom create the bloom of the logs of receipts
+func CreateBloom(receipts Receipts) Bloom {
+	var bloom Bloom
+	for _, receipt := range receipts {
+		bloom.Or(LogsBloom(receipt.Logs))
+	}
+	return bloom
+}
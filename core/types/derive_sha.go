@@ -0,0 +1,27 @@
+package types
+
+import (
+	"seth/common"
+	"seth/rlp"
+	"seth/trie"
+)
+
+// EmptyRootHash is the root hash of an empty list,the hash of empty trie is zero
+var EmptyRootHash = common.Hash{}
+
+// DerivableList is the list whose root hash can be derived
+type DerivableList interface {
+	Len() int
+	GetRlp(i int) []byte
+}
+
+// DeriveSha return the root hash of the ephemeral trie of list,the key is
+// RLP(index) and the value is the RLP of item
+func DeriveSha(list DerivableList) common.Hash {
+	t, _ := trie.NewTrie(common.Hash{}, nil, nil)
+	for i := 0; i < list.Len(); i++ {
+		key, _ := rlp.EncodeToBytes(uint(i))
+		t.Put(key, list.GetRlp(i))
+	}
+	return t.Hash()
+}
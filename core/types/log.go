@@ -0,0 +1,45 @@
+package types
+
+import (
+	"io"
+	"seth/common"
+	"seth/rlp"
+)
+
+// Log represents a log event of transaction,the address and topics are
+// indexed by the bloom of receipt and header
+type Log struct {
+	// Consensus fields:
+	Address common.Address `json:"address" gencodec:"required"`
+	Topics  []common.Hash  `json:"topics"  gencodec:"required"`
+	Data    []byte         `json:"data"    gencodec:"required"`
+
+	// Derived fields. These fields are filled in by the node
+	// but not secured by consensus.
+	BlockNumber uint64      `json:"blockNumber"`
+	TxHash      common.Hash `json:"transactionHash" gencodec:"required"`
+	TxIndex     uint        `json:"transactionIndex" gencodec:"required"`
+	BlockHash   common.Hash `json:"blockHash"`
+	Index       uint        `json:"logIndex" gencodec:"required"`
+}
+
+type rlpLog struct {
+	Address common.Address
+	Topics  []common.Hash
+	Data    []byte
+}
+
+// EncodeRLP implements rlp.Encoder,only the consensus fields are encoded
+func (l *Log) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, rlpLog{Address: l.Address, Topics: l.Topics, Data: l.Data})
+}
+
+// DecodeRLP implements rlp.Decoder
+func (l *Log) DecodeRLP(s *rlp.Stream) error {
+	var dec rlpLog
+	err := s.Decode(&dec)
+	if err == nil {
+		l.Address, l.Topics, l.Data = dec.Address, dec.Topics, dec.Data
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package types
+
+import (
+	"io"
+	"seth/common"
+	"seth/rlp"
+)
+
+// ReceiptStatusSuccessful is the status code of a transaction if execution
+// succeeded,it is the only status since a failed transaction is never included
+// in block
+const ReceiptStatusSuccessful = uint64(1)
+
+// Receipt represents the results of a transaction.
+type Receipt struct {
+	// Consensus fields
+	Status            uint64 `json:"status"`
+	CumulativeGasUsed uint64 `json:"cumulativeGasUsed" gencodec:"required"`
+	Bloom             Bloom  `json:"logsBloom"         gencodec:"required"`
+	Logs              []*Log `json:"logs"              gencodec:"required"`
+
+	// Implementation fields (stored in database but not secured by consensus)
+	TxHash  common.Hash `json:"transactionHash" gencodec:"required"`
+	GasUsed uint64      `json:"gasUsed" gencodec:"required"`
+
+	// Derived fields,filled in when the receipts of block are loaded
+	BlockHash        common.Hash `json:"blockHash,omitempty"`
+	BlockNumber      uint64      `json:"blockNumber,omitempty"`
+	TransactionIndex uint        `json:"transactionIndex"`
+}
+
+// receiptRLP is the consensus encoding of a receipt.
+type receiptRLP struct {
+	Status            uint64
+	CumulativeGasUsed uint64
+	Bloom             Bloom
+	Logs              []*Log
+}
+
+// receiptStorageRLP is the storage encoding of a receipt.
+type receiptStorageRLP struct {
+	Status            uint64
+	CumulativeGasUsed uint64
+	Bloom             Bloom
+	TxHash            common.Hash
+	GasUsed           uint64
+	Logs              []*Log
+}
+
+// NewReceipt creates a receipt of the successful transaction.
+func NewReceipt(cumulativeGasUsed uint64) *Receipt {
+	return &Receipt{Status: ReceiptStatusSuccessful, CumulativeGasUsed: cumulativeGasUsed}
+}
+
+// EncodeRLP implements rlp.Encoder,only the consensus fields are encoded
+func (r *Receipt) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, &receiptRLP{r.Status, r.CumulativeGasUsed, r.Bloom, r.Logs})
+}
+
+// DecodeRLP implements rlp.Decoder
+func (r *Receipt) DecodeRLP(s *rlp.Stream) error {
+	var dec receiptRLP
+	if err := s.Decode(&dec); err != nil {
+		return err
+	}
+	r.Status, r.CumulativeGasUsed, r.Bloom, r.Logs = dec.Status, dec.CumulativeGasUsed, dec.Bloom, dec.Logs
+	return nil
+}
+
+// ReceiptForStorage is a wrapper around a Receipt that flattens and parses the
+// entire content of a receipt, as opposed to only the consensus fields.
+type ReceiptForStorage Receipt
+
+// EncodeRLP implements rlp.Encoder
+func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, &receiptStorageRLP{
+		Status:            r.Status,
+		CumulativeGasUsed: r.CumulativeGasUsed,
+		Bloom:             r.Bloom,
+		TxHash:            r.TxHash,
+		GasUsed:           r.GasUsed,
+		Logs:              r.Logs,
+	})
+}
+
+// DecodeRLP implements rlp.Decoder
+func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
+	var dec receiptStorageRLP
+	if err := s.Decode(&dec); err != nil {
+		return err
+	}
+	r.Status, r.CumulativeGasUsed, r.Bloom = dec.Status, dec.CumulativeGasUsed, dec.Bloom
+	r.TxHash, r.GasUsed, r.Logs = dec.TxHash, dec.GasUsed, dec.Logs
+	for _, log := range r.Logs {
+		log.TxHash = r.TxHash
+	}
+	return nil
+}
+
+// Receipts is a wrapper around a Receipt array
+type Receipts []*Receipt
+
+// Len returns the number of receipts in this list.
+func (r Receipts) Len() int { return len(r) }
+
+// GetRlp returns the RLP encoding of one receipt from the list.
+func (r Receipts) GetRlp(i int) []byte {
+	bytes, err := rlp.EncodeToBytes(r[i])
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
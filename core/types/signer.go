@@ -104,6 +104,8 @@ func (ss SethSigner) PublicKey(tx *Transaction) (*crypto.PublicKey, error) {
 func (ss SethSigner) Hash(tx *Transaction) common.Hash {
 	return crypto.RlpHash([]interface{}{
 		tx.Data.AccountNonce,
+		tx.Data.GasPrice,
+		tx.Data.GasLimit,
 		tx.Data.To,
 		tx.Data.Amount,
 		ss.chainID,
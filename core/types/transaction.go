@@ -1,9 +1,11 @@
 package types
 
 import (
+	"container/heap"
 	"math/big"
 	"seth/common"
 	"seth/crypto"
+	"seth/rlp"
 	"sync/atomic"
 )
 
@@ -19,20 +21,27 @@ type Transaction struct {
 type txData struct {
 	To           *common.Address   `json:"to"       rlp:"nil"` // nil means contract creation
 	AccountNonce uint64            `json:"nonce"    gencodec:"required"`
+	GasPrice     *big.Int          `json:"gasPrice" gencodec:"required"`
+	GasLimit     uint64            `json:"gas"      gencodec:"required"`
 	Amount       *big.Int          `json:"value"    gencodec:"required"`
 	Signature    *crypto.Signature `json:"signature"    gencodec:"required"`
 }
 
 // NewTransaction creates a new transaction to transfer asset.
-func NewTransaction(to common.Address, amount *big.Int, nonce uint64) *Transaction {
+func NewTransaction(to common.Address, amount *big.Int, nonce uint64, gasLimit uint64, gasPrice *big.Int) *Transaction {
 	txdata := &txData{
 		To:           &to,
 		Amount:       new(big.Int),
 		AccountNonce: nonce,
+		GasPrice:     new(big.Int),
+		GasLimit:     gasLimit,
 	}
 	if amount != nil {
 		txdata.Amount.Set(amount)
 	}
+	if gasPrice != nil {
+		txdata.GasPrice.Set(gasPrice)
+	}
 
 	return &Transaction{Data: txdata}
 }
@@ -45,6 +54,8 @@ func (tx *Transaction) Hash() common.Hash {
 	}
 	v := crypto.RlpHash([]interface{}{
 		tx.Data.AccountNonce,
+		tx.Data.GasPrice,
+		tx.Data.GasLimit,
 		tx.Data.To,
 		tx.Data.Amount,
 	})
@@ -52,6 +63,19 @@ func (tx *Transaction) Hash() common.Hash {
 	return v
 }
 
+// Cost returns amount + gasprice * gaslimit.
+func (tx *Transaction) Cost() *big.Int {
+	total := new(big.Int).Mul(tx.Data.GasPrice, new(big.Int).SetUint64(tx.Data.GasLimit))
+	total.Add(total, tx.Data.Amount)
+	return total
+}
+
+// Size returns the length of the RLP encoding of transaction
+func (tx *Transaction) Size() int {
+	enc, _ := rlp.EncodeToBytes(tx)
+	return len(enc)
+}
+
 // Sign sign transaction
 func (tx *Transaction) Sign(signer Signer, privatekey *crypto.PrivateKey) error {
 	h := signer.Hash(tx)
@@ -94,3 +118,99 @@ func (tx *Transaction) ChainID() *big.Int {
 
 // Transactions is a Transaction slice type
 type Transactions []*Transaction
+
+// Len returns the length of s.
+func (s Transactions) Len() int { return len(s) }
+
+// GetRlp returns the RLP encoding of the i'th transaction.
+func (s Transactions) GetRlp(i int) []byte {
+	enc, _ := rlp.EncodeToBytes(s[i])
+	return enc
+}
+
+// TxByNonce implements the sort interface to allow sorting a list of
+// transactions by their nonces
+type TxByNonce Transactions
+
+func (s TxByNonce) Len() int           { return len(s) }
+func (s TxByNonce) Less(i, j int) bool { return s[i].Data.AccountNonce < s[j].Data.AccountNonce }
+func (s TxByNonce) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// TxByPrice implements both the sort and the heap interface,making it useful
+// for all at once sorting as well as individually adding and removing elements
+type TxByPrice Transactions
+
+func (s TxByPrice) Len() int           { return len(s) }
+func (s TxByPrice) Less(i, j int) bool { return s[i].Data.GasPrice.Cmp(s[j].Data.GasPrice) > 0 }
+func (s TxByPrice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Push implements heap.Interface
+func (s *TxByPrice) Push(x interface{}) {
+	*s = append(*s, x.(*Transaction))
+}
+
+// Pop implements heap.Interface
+func (s *TxByPrice) Pop() interface{} {
+	old := *s
+	n := len(old)
+	x := old[n-1]
+	*s = old[0 : n-1]
+	return x
+}
+
+// TransactionsByPriceAndNonce represents a set of transactions that can return
+// transactions in a profit-maximizing sorted order,while supporting removing
+// entire batches of transactions for non-executable accounts
+type TransactionsByPriceAndNonce struct {
+	txs    map[common.Address]Transactions // per account nonce-sorted list of transactions
+	heads  TxByPrice                       // next transaction for each unique account (price heap)
+	signer Signer                          // signer for the set of transactions
+}
+
+// NewTransactionsByPriceAndNonce creates a transaction set that can retrieve
+// price sorted transactions in a nonce-honouring way. The txs of each account
+// must be sorted by nonce,the map is modified.
+func NewTransactionsByPriceAndNonce(signer Signer, txs map[common.Address]Transactions) *TransactionsByPriceAndNonce {
+	heads := make(TxByPrice, 0, len(txs))
+	for from, accTxs := range txs {
+		if len(accTxs) == 0 {
+			delete(txs, from)
+			continue
+		}
+		heads = append(heads, accTxs[0])
+		txs[from] = accTxs[1:]
+	}
+	heap.Init(&heads)
+
+	return &TransactionsByPriceAndNonce{
+		txs:    txs,
+		heads:  heads,
+		signer: signer,
+	}
+}
+
+// Peek returns the next transaction by price
+func (t *TransactionsByPriceAndNonce) Peek() *Transaction {
+	if len(t.heads) == 0 {
+		return nil
+	}
+	return t.heads[0]
+}
+
+// Shift replaces the current best head with the next one from the same account
+func (t *TransactionsByPriceAndNonce) Shift() {
+	acc, _ := t.heads[0].Sender(t.signer)
+	if txs, ok := t.txs[acc]; ok && len(txs) > 0 {
+		t.heads[0], t.txs[acc] = txs[0], txs[1:]
+		heap.Fix(&t.heads, 0)
+	} else {
+		heap.Pop(&t.heads)
+	}
+}
+
+// Pop removes the best transaction,*not* replacing it with the next one from
+// the same account. This should be used when a transaction cannot be executed
+// and hence all subsequent ones should be discarded from the same account.
+func (t *TransactionsByPriceAndNonce) Pop() {
+	heap.Pop(&t.heads)
+}
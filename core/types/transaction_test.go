@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/big"
 	"seth/accounts"
+	"seth/common"
 	"testing"
 )
 
@@ -12,7 +13,7 @@ func Test_Transaction_SignVerify(t *testing.T) {
 	toaddress, _ := accounts.NewRandomAccount()
 
 	signer := NewSethSigner(big.NewInt(1))
-	tx := NewTransaction(toaddress, big.NewInt(10), 0)
+	tx := NewTransaction(toaddress, big.NewInt(10), 0, 21000, big.NewInt(1))
 	err := tx.Sign(signer, fromprivatekey)
 	if err != nil {
 		t.Fatalf("Failed to sign a tx!")
@@ -40,7 +41,7 @@ func Test_Transaction_Hash(t *testing.T) {
 	_, fromprivatekey := accounts.NewRandomAccount()
 	toaddress, _ := accounts.NewRandomAccount()
 	signer := NewSethSigner(big.NewInt(1))
-	tx := NewTransaction(toaddress, big.NewInt(10), 0)
+	tx := NewTransaction(toaddress, big.NewInt(10), 0, 21000, big.NewInt(1))
 	hashBeforeSign := tx.Hash()
 	err := tx.Sign(signer, fromprivatekey)
 	if err != nil {
@@ -52,3 +53,42 @@ func Test_Transaction_Hash(t *testing.T) {
 	}
 
 }
+
+func Test_Transaction_PriceAndNonce(t *testing.T) {
+	signer := NewSethSigner(big.NewInt(1))
+	toaddress, _ := accounts.NewRandomAccount()
+	groups := make(map[common.Address]Transactions)
+	for i := 0; i < 5; i++ {
+		from, key := accounts.NewRandomAccount()
+		for nonce := uint64(0); nonce < 3; nonce++ {
+			// the price is descending with the nonce to check the nonce order is kept
+			tx := NewTransaction(toaddress, big.NewInt(1), nonce, 21000, big.NewInt(int64(10*i+10-int(nonce))))
+			if err := tx.Sign(signer, key); err != nil {
+				t.Fatalf("Failed to sign tx: %v", err)
+			}
+			groups[from] = append(groups[from], tx)
+		}
+	}
+
+	txs := NewTransactionsByPriceAndNonce(signer, groups)
+	nonces := make(map[common.Address]uint64)
+	count := 0
+	var last *Transaction
+	for tx := txs.Peek(); tx != nil; tx = txs.Peek() {
+		from, _ := tx.Sender(signer)
+		if tx.Data.AccountNonce != nonces[from] {
+			t.Fatalf("Error nonce of %s,got %d,expected %d", from.Hex(), tx.Data.AccountNonce, nonces[from])
+		}
+		// a lower price is only allowed if the higher one is blocked by nonce
+		if last != nil && tx.Data.GasPrice.Cmp(last.Data.GasPrice) > 0 && tx.Data.AccountNonce == 0 {
+			t.Fatalf("Error price order,%v after %v", tx.Data.GasPrice, last.Data.GasPrice)
+		}
+		nonces[from]++
+		last = tx
+		count++
+		txs.Shift()
+	}
+	if count != 15 {
+		t.Fatalf("Error count of transactions,got %d,expected 15", count)
+	}
+}
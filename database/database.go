@@ -24,6 +24,16 @@ type Database interface {
 	Delete(key []byte) error
 	DeleteSring(key string) error
 	NewBatch() Batch
+	NewIterator(prefix []byte) Iterator
+}
+
+// Iterator iterface of iterator for database,it iterates the keys in order
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Release()
+	Error() error
 }
 
 // Batch iterface of batch for database
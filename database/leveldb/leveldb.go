@@ -7,6 +7,7 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const (
@@ -132,3 +133,8 @@ func (db *levelDB) NewBatch() database.Batch {
 	}
 	return batch
 }
+
+// NewIterator new a iterator over the keys with prefix
+func (db *levelDB) NewIterator(prefix []byte) database.Iterator {
+	return db.db.NewIterator(util.BytesPrefix(prefix), nil)
+}
@@ -193,3 +193,24 @@ func Test_levelDB_Newbatch(t *testing.T) {
 		t.Fatal("new level batch error")
 	}
 }
+
+func Test_levelDB_Iterator(t *testing.T) {
+	// Init levelDB
+	db, remove := newTestLevelDB()
+	defer remove()
+
+	db.PutString("a1", "1")
+	db.PutString("b2", "2")
+	db.PutString("b1", "3")
+	db.PutString("c1", "4")
+
+	// check only keys with prefix are iterated in order
+	it := db.NewIterator([]byte("b"))
+	defer it.Release()
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	assert.Equal(t, it.Error(), nil)
+	assert.Equal(t, keys, []string{"b1", "b2"})
+}
@@ -77,6 +77,7 @@ func (d *eventDispatcher) AddListener(eventType TypeEvent, listener Listener) {
 	d.RWMutex.Lock()
 	defer d.RWMutex.Unlock()
 	d.listeners[eventType] = append(d.listeners[eventType], listener)
+	delete(d.sorted, eventType)
 }
 
 // AddListenerExecOnce registers a listener to be executed only once.
@@ -116,6 +117,7 @@ func (d *eventDispatcher) RemoveListener(eventType TypeEvent, listener Listener)
 			d.listeners[eventType] = append(listeners[:i], listeners[i+1:]...)
 		}
 	}
+	delete(d.sorted, eventType)
 }
 
 // RemoveAll removes all listeners for given type.
@@ -127,6 +129,7 @@ func (d *eventDispatcher) RemoveAll(eventType TypeEvent) {
 	if ok != false {
 		delete(d.listeners, eventType)
 	}
+	delete(d.sorted, eventType)
 }
 
 // HasListeners returns true if any listener for given event type
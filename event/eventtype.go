@@ -10,4 +10,14 @@ const (
 	EventNewMinedBlock TypeEvent = 1
 	// EventNodeDisconnect event for node disconnect
 	EventNodeDisconnect TypeEvent = 2
+	// EventChainHead event for new head block of canonical chain,param "block"
+	EventChainHead TypeEvent = 3
+	// EventChainSide event for new block of side chain,param "block"
+	EventChainSide TypeEvent = 4
+	// EventTxsDropped event for transactions removed from canonical chain by
+	// reorganisation,param "txs"
+	EventTxsDropped TypeEvent = 5
+	// EventTxReplaced event for pooled transaction replaced by the one of the
+	// same sender and nonce with higher fee,param "old" and "new"
+	EventTxReplaced TypeEvent = 6
 )
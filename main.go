@@ -61,6 +61,18 @@ func Commands() []cli.Command {
 			ShortName: "n",
 			Usage:     "genesis mainnet/testnet/devnet to create genesis block",
 		},
+		cli.Command{
+			Name:   "prune-state",
+			Before: n.init,
+			Action: n.PruneState,
+			Usage:  "prune-state --retain N to delete the state not reachable from the recent N blocks",
+			Flags: []cli.Flag{
+				cli.Uint64Flag{
+					Name:  "retain",
+					Usage: "number of recent blocks whose state is retained",
+				},
+			},
+		},
 		cli.Command{
 			Name:      "start",
 			Before:    n.init,
@@ -0,0 +1,261 @@
+package miner
+
+import (
+	"math/big"
+	"seth/common"
+	"seth/consensus"
+	"seth/core"
+	"seth/core/state"
+	"seth/core/types"
+	"seth/event"
+	"seth/log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// retryInterval is the time to wait before retrying a failed block
+	retryInterval = time.Second
+)
+
+// Config is the config of miner
+type Config struct {
+	Coinbase common.Address // address credited with the transaction fees,the signer is credited under PoA and BFT
+	Extra    []byte         // extra data of the mined blocks
+	Interval time.Duration  // minimum time between the starts of two blocks
+}
+
+// signerEngine is implemented by the engines sealing blocks with a local key,
+// the chain credits the fees to the signer instead of the coinbase
+type signerEngine interface {
+	Signer() common.Address
+}
+
+// Miner assembles the executable transactions of pool into new blocks on the
+// head of chain,the blocks are sealed by the consensus engine of chain
+type Miner struct {
+	config     Config
+	chain      *core.BlockChain
+	pool       *core.TxPool
+	engine     consensus.Engine
+	signer     types.Signer
+	dispatcher event.Dispatcher
+
+	mu       sync.RWMutex // protects coinbase and extra
+	coinbase common.Address
+	extra    []byte
+
+	running int32
+	newHead chan struct{} // aborts the block being sealed when a new head arrives
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New creates a miner on chain with the transactions of pool
+func New(config *Config, chain *core.BlockChain, pool *core.TxPool) *Miner {
+	m := &Miner{
+		config:     *config,
+		chain:      chain,
+		pool:       pool,
+		engine:     chain.Engine(),
+		signer:     types.NewSethSigner(chain.Config().ChainID),
+		dispatcher: event.SharedDispatcher(),
+		coinbase:   config.Coinbase,
+		extra:      config.Extra,
+		newHead:    make(chan struct{}, 1),
+	}
+	m.dispatcher.AddListener(event.EventChainHead, event.Listener{Callable: m.onChainHead})
+	return m
+}
+
+// SetCoinbase sets the address credited with the fees of following blocks
+func (m *Miner) SetCoinbase(coinbase common.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.coinbase = coinbase
+}
+
+// SetExtra sets the extra data of following blocks
+func (m *Miner) SetExtra(extra []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.extra = append([]byte(nil), extra...)
+}
+
+// Mining returns whether the miner is running
+func (m *Miner) Mining() bool {
+	return atomic.LoadInt32(&m.running) == 1
+}
+
+// Start starts mining blocks in background
+func (m *Miner) Start() {
+	if !atomic.CompareAndSwapInt32(&m.running, 0, 1) {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.wg.Add(1)
+	go m.loop(m.stop)
+	log.Info("Miner started")
+}
+
+// Stop stops mining and waits for the block being sealed to be aborted
+func (m *Miner) Stop() {
+	if !atomic.CompareAndSwapInt32(&m.running, 1, 0) {
+		return
+	}
+	close(m.stop)
+	m.wg.Wait()
+	log.Info("Miner stopped")
+}
+
+// onChainHead aborts the block being sealed since its parent is not the head
+func (m *Miner) onChainHead(e event.Event) {
+	select {
+	case m.newHead <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Miner) loop(stop chan struct{}) {
+	defer m.wg.Done()
+
+	for {
+		// the heads before the block is started are not relevant
+		select {
+		case <-m.newHead:
+		default:
+		}
+		start := time.Now()
+		abort, done := make(chan struct{}), make(chan struct{})
+		go func() {
+			select {
+			case <-stop:
+			case <-m.newHead:
+			case <-done:
+				return
+			}
+			close(abort)
+		}()
+		_, err := m.mine(abort)
+		close(done)
+
+		wait := m.config.Interval - time.Since(start)
+		if err != nil {
+			log.Warn("Failed to mine block: %v", err)
+			if wait < retryInterval {
+				wait = retryInterval
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// mine assembles a block on the current head,seals and inserts it. It
+// returns nil if abort is closed before the block is sealed.
+func (m *Miner) mine(abort <-chan struct{}) (*types.Block, error) {
+	parent := m.chain.CurrentBlock()
+
+	// the block in the future is rejected,wait until it is later than parent
+	timestamp := time.Now().Unix()
+	if timestamp <= parent.Header.Time.Int64() {
+		timestamp = parent.Header.Time.Int64() + 1
+		select {
+		case <-abort:
+			return nil, nil
+		case <-time.After(time.Until(time.Unix(timestamp, 0))):
+		}
+	}
+
+	m.mu.RLock()
+	coinbase, extra := m.coinbase, append([]byte(nil), m.extra...)
+	m.mu.RUnlock()
+
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Header.Number, common.Big1),
+		GasLimit:   parent.Header.GasLimit,
+		Coinbase:   coinbase,
+		Extra:      extra,
+		Time:       big.NewInt(timestamp),
+	}
+	if err := m.engine.Prepare(m.chain, header); err != nil {
+		return nil, err
+	}
+
+	statedb, err := m.chain.StateAt(parent.Header.Root)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := m.pool.Pending()
+	if err != nil {
+		return nil, err
+	}
+	author := m.author(coinbase)
+	txs, receipts := m.commitTransactions(statedb, header, author, types.NewTransactionsByPriceAndNonce(m.signer, pending))
+	block, err := m.engine.Finalize(m.chain, header, statedb, txs, receipts)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := m.engine.Seal(m.chain, block, abort)
+	if err != nil || sealed == nil {
+		return nil, err
+	}
+	if _, err := m.chain.InsertChain([]*types.Block{sealed}); err != nil {
+		return nil, err
+	}
+	// the block committed by BFT may be proposed by the other validator
+	if sealer, err := m.engine.Author(sealed.Header); err != nil || sealer != author {
+		return sealed, nil
+	}
+	log.Info("Mined new block,number %d hash %s txs %d", sealed.NumberU64(), sealed.Hash().Hex(), len(sealed.Transactions()))
+	m.dispatcher.Dispatch(event.NewParamsEvent(event.EventNewMinedBlock).SetParam("block", sealed))
+	return sealed, nil
+}
+
+// author returns the address the chain credits the fees of the mined block to,
+// it is the same one engine.Author recovers from the sealed block
+func (m *Miner) author(coinbase common.Address) common.Address {
+	if engine, ok := m.engine.(signerEngine); ok {
+		return engine.Signer()
+	}
+	return coinbase
+}
+
+// commitTransactions applies the transactions to statedb in order of price
+// and nonce,the failed transactions are skipped with the following ones of
+// the same sender. The fees are credited to author.
+func (m *Miner) commitTransactions(statedb *state.Statedb, header *types.Header, author common.Address, txs *types.TransactionsByPriceAndNonce) (types.Transactions, []*types.Receipt) {
+	var (
+		committed types.Transactions
+		receipts  []*types.Receipt
+	)
+	for tx := txs.Peek(); tx != nil; tx = txs.Peek() {
+		if header.GasLimit < header.GasUsed+core.TxGas {
+			break
+		}
+		snap := statedb.Snapshot()
+		receipt, err := core.ApplyTransaction(statedb, m.signer, &author, header, tx, &header.GasUsed)
+		if err != nil {
+			statedb.RevertToSnapshot(snap)
+		}
+		switch err {
+		case nil:
+			committed = append(committed, tx)
+			receipts = append(receipts, receipt)
+			txs.Shift()
+		case core.ErrNonceTooLow:
+			// the transaction is already in chain
+			txs.Shift()
+		default:
+			log.Debug("Skip transaction %s: %v", tx.Hash().Hex(), err)
+			txs.Pop()
+		}
+	}
+	return committed, receipts
+}
@@ -0,0 +1,248 @@
+package miner
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"seth/accounts"
+	"seth/common"
+	"seth/config"
+	"seth/consensus"
+	"seth/consensus/poa"
+	"seth/consensus/pow"
+	"seth/core"
+	"seth/core/types"
+	"seth/crypto"
+	"seth/database"
+	"seth/database/leveldb"
+	"seth/event"
+	"testing"
+	"time"
+)
+
+func newTestMinerDB() (database.Database, func()) {
+	dir, err := ioutil.TempDir("", "testminerdb")
+	if err != nil {
+		panic(err)
+	}
+	db, err := leveldb.NewLevelDB(dir, 0, 0)
+	if err != nil {
+		panic(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+var testChainID = big.NewInt(1)
+
+func newTestChain(t *testing.T, db database.Database, alloc core.GenesisAlloc) *core.BlockChain {
+	return newTestEngineChain(t, db, alloc, pow.NewFaker(), nil)
+}
+
+func newTestEngineChain(t *testing.T, db database.Database, alloc core.GenesisAlloc, engine consensus.Engine, extra []byte) *core.BlockChain {
+	genesis := &core.Genesis{
+		Config:     &config.ChainConfig{ChainID: testChainID},
+		Difficulty: big.NewInt(1),
+		GasLimit:   core.GenesisGasLimit,
+		ExtraData:  extra,
+		Alloc:      alloc,
+	}
+	if _, err := genesis.Commit(db); err != nil {
+		t.Fatalf("Failed to commit genesis: %v", err)
+	}
+	chain, err := core.NewBlockChain(db, engine)
+	if err != nil {
+		t.Fatalf("Failed to new block chain: %v", err)
+	}
+	return chain
+}
+
+func newTestTransfer(t *testing.T, key *crypto.PrivateKey, to common.Address, nonce uint64, price int64) *types.Transaction {
+	tx := types.NewTransaction(to, big.NewInt(10), nonce, core.TxGas, big.NewInt(price))
+	if err := tx.Sign(types.NewSethSigner(testChainID), key); err != nil {
+		t.Fatalf("Failed to sign tx: %v", err)
+	}
+	return tx
+}
+
+func Test_Miner_Mine(t *testing.T) {
+	db, remove := newTestMinerDB()
+	defer remove()
+	dispatcher := event.SharedDispatcher()
+	defer dispatcher.RemoveAll(event.EventChainHead)
+	defer dispatcher.RemoveAll(event.EventTxsDropped)
+	defer dispatcher.RemoveAll(event.EventNewMinedBlock)
+
+	from, key := accounts.NewRandomAccount()
+	poor, poorKey := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	coinbase, _ := accounts.NewRandomAccount()
+	chain := newTestChain(t, db, core.GenesisAlloc{from: {Balance: big.NewInt(1000000)}, poor: {Balance: big.NewInt(1)}})
+	pool := core.NewTxPool(&core.DefaultTxPoolConfig, chain)
+	defer pool.Stop()
+
+	txs := []*types.Transaction{
+		newTestTransfer(t, key, to, 2, 3),
+		newTestTransfer(t, key, to, 0, 1),
+		newTestTransfer(t, key, to, 1, 2),
+		newTestTransfer(t, key, to, 4, 1), // nonce gap
+	}
+	for _, tx := range txs {
+		if err := pool.AddTx(tx); err != nil {
+			t.Fatalf("Failed to add tx: %v", err)
+		}
+	}
+	if err := pool.AddTx(newTestTransfer(t, poorKey, to, 0, 5)); err != core.ErrInsufficientFunds {
+		t.Fatalf("expected error %v,got %v", core.ErrInsufficientFunds, err)
+	}
+
+	var mined []*types.Block
+	dispatcher.AddListener(event.EventNewMinedBlock, event.Listener{Callable: func(e event.Event) {
+		block, _ := e.(*event.ParamsEvent).GetParam("block")
+		mined = append(mined, block.(*types.Block))
+	}})
+
+	miner := New(&Config{Coinbase: coinbase, Extra: []byte("seth")}, chain, pool)
+	block, err := miner.mine(nil)
+	if err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	if len(mined) != 1 || mined[0].Hash() != block.Hash() || chain.CurrentBlock().Hash() != block.Hash() {
+		t.Fatalf("Error: mined block is not the head")
+	}
+	if block.NumberU64() != 1 || block.Header.Coinbase != coinbase || string(block.Header.Extra) != "seth" {
+		t.Fatalf("Error header of mined block: %+v", block.Header)
+	}
+	if len(block.Transactions()) != 3 {
+		t.Fatalf("Error count of transactions,got %d,expected 3", len(block.Transactions()))
+	}
+	for i, tx := range block.Transactions() {
+		if tx.Data.AccountNonce != uint64(i) {
+			t.Fatalf("Error nonce of transaction %d,got %d", i, tx.Data.AccountNonce)
+		}
+	}
+
+	statedb, err := chain.StateAt(block.Header.Root)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	if fee := statedb.GetAmount(coinbase); fee.Cmp(big.NewInt(6*int64(core.TxGas))) != 0 {
+		t.Fatalf("Error fee of coinbase,got %v", fee)
+	}
+	if nonce := statedb.GetNonce(from); nonce != 3 {
+		t.Fatalf("Error nonce of sender,got %d,expected 3", nonce)
+	}
+
+	// the transactions in chain are skipped
+	miner.SetExtra([]byte("next"))
+	block, err = miner.mine(nil)
+	if err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	if block.NumberU64() != 2 || len(block.Transactions()) != 0 || string(block.Header.Extra) != "next" {
+		t.Fatalf("Error second mined block")
+	}
+
+	// the failed transaction of unknown sender leaves no account in state
+	unknown, unknownKey := accounts.NewRandomAccount()
+	statedb, err = chain.StateAt(block.Header.Root)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	root := statedb.IntermediateRoot()
+	header := &types.Header{Number: big.NewInt(3), GasLimit: block.Header.GasLimit}
+	pending := map[common.Address]types.Transactions{unknown: {newTestTransfer(t, unknownKey, to, 0, 1)}}
+	if txs, _ := miner.commitTransactions(statedb, header, coinbase, types.NewTransactionsByPriceAndNonce(miner.signer, pending)); len(txs) != 0 {
+		t.Fatalf("Error: transaction of unknown sender committed")
+	}
+	if statedb.IntermediateRoot() != root {
+		t.Fatalf("Error: state modified by failed transaction")
+	}
+}
+
+func Test_Miner_PoAFees(t *testing.T) {
+	db, remove := newTestMinerDB()
+	defer remove()
+	dispatcher := event.SharedDispatcher()
+	defer dispatcher.RemoveAll(event.EventChainHead)
+	defer dispatcher.RemoveAll(event.EventTxsDropped)
+	defer dispatcher.RemoveAll(event.EventNewMinedBlock)
+
+	from, key := accounts.NewRandomAccount()
+	signer, signerKey := accounts.NewRandomAccount()
+	to, _ := accounts.NewRandomAccount()
+	coinbase, _ := accounts.NewRandomAccount()
+	engine := poa.New(&config.PoAConfig{Epoch: 30000}, db)
+	engine.Authorize(signerKey)
+	chain := newTestEngineChain(t, db, core.GenesisAlloc{from: {Balance: big.NewInt(1000000)}}, engine, poa.GenesisExtra([]common.Address{signer}))
+	pool := core.NewTxPool(&core.DefaultTxPoolConfig, chain)
+	defer pool.Stop()
+	if err := pool.AddTx(newTestTransfer(t, key, to, 0, 2)); err != nil {
+		t.Fatalf("Failed to add tx: %v", err)
+	}
+
+	mined := 0
+	dispatcher.AddListener(event.EventNewMinedBlock, event.Listener{Callable: func(e event.Event) { mined++ }})
+
+	// the configured coinbase is not the signer,the fees go to the signer
+	miner := New(&Config{Coinbase: coinbase}, chain, pool)
+	block, err := miner.mine(nil)
+	if err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	if len(block.Transactions()) != 1 || chain.CurrentBlock().Hash() != block.Hash() || mined != 1 {
+		t.Fatalf("Error: mined block is not the head")
+	}
+	statedb, err := chain.StateAt(block.Header.Root)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	if fee := statedb.GetAmount(signer); fee.Cmp(big.NewInt(2*int64(core.TxGas))) != 0 {
+		t.Fatalf("Error fee of signer,got %v", fee)
+	}
+	if fee := statedb.GetAmount(coinbase); fee.Sign() != 0 {
+		t.Fatalf("Error fee of coinbase,got %v", fee)
+	}
+}
+
+func Test_Miner_StartStop(t *testing.T) {
+	db, remove := newTestMinerDB()
+	defer remove()
+	dispatcher := event.SharedDispatcher()
+	defer dispatcher.RemoveAll(event.EventChainHead)
+	defer dispatcher.RemoveAll(event.EventTxsDropped)
+	defer dispatcher.RemoveAll(event.EventNewMinedBlock)
+
+	chain := newTestChain(t, db, nil)
+	mined := make(chan *types.Block, 16)
+	dispatcher.AddListener(event.EventNewMinedBlock, event.Listener{Callable: func(e event.Event) {
+		block, _ := e.(*event.ParamsEvent).GetParam("block")
+		mined <- block.(*types.Block)
+	}})
+
+	pool := core.NewTxPool(&core.DefaultTxPoolConfig, chain)
+	defer pool.Stop()
+	miner := New(&Config{Interval: 10 * time.Millisecond}, chain, pool)
+	miner.Start()
+	for i := 1; i <= 2; i++ {
+		select {
+		case block := <-mined:
+			if block.NumberU64() != uint64(i) {
+				t.Fatalf("Error number of mined block,got %d,expected %d", block.NumberU64(), i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Block %d is not mined", i)
+		}
+	}
+	miner.Stop()
+	if miner.Mining() {
+		t.Fatalf("Error: miner is running after stop")
+	}
+	head := chain.CurrentBlock().NumberU64()
+	time.Sleep(50 * time.Millisecond)
+	if chain.CurrentBlock().NumberU64() != head {
+		t.Fatalf("Error: block mined after stop")
+	}
+}
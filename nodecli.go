@@ -76,12 +76,44 @@ func (n *NodeCli) InitGenesis(c *cli.Context) error {
 		return err
 	}
 	defer db.Close()
-	hash, err := genesis.SetupGensisBlock(db)
-	log.Info("genesis block hash:%s", hash.Hex())
-	if err == core.ErrHasGenesisBlock {
-		log.Error("error: %v;exist genesis block hash:%s", err, hash.Hex())
+	chainConfig, hash, err := genesis.SetupGensisBlock(db)
+	if err != nil {
+		log.Error("Failed to setup genesis block: %v", err)
+		return err
+	}
+	log.Info("genesis block hash:%s;chain config:%v", hash.Hex(), chainConfig)
+	return nil
+}
+
+// PruneState delete the stale state which is not reachable from the recent
+// canonical blocks
+func (n *NodeCli) PruneState(c *cli.Context) error {
+	retain := c.Uint64("retain")
+	if retain == 0 {
+		retain = config.Config.StateRetain
 	}
-	return err
+	if retain == 0 {
+		retain = core.DefaultStateRetain
+	}
+	datapath := config.ResolvePath("chaindata")
+	db, err := database.GetDatabase(database.LevelDBName)
+	if err != nil {
+		log.Fatal("get database error: %v", err)
+		return err
+	}
+	err = db.Open(datapath, 0, 0)
+	if err != nil {
+		log.Fatal("open database error: %v", err)
+		return err
+	}
+	defer db.Close()
+	deleted, err := core.PruneState(db, retain)
+	if err != nil {
+		log.Error("prune state error: %v", err)
+		return err
+	}
+	log.Info("pruned state of recent %d blocks,deleted %d nodes", retain, deleted)
+	return nil
 }
 
 // Start start cmd for nodecli
@@ -0,0 +1,339 @@
+package trie
+
+import (
+	"container/list"
+	"seth/common"
+	"seth/config"
+	"seth/database"
+	"sync"
+
+	"github.com/hashicorp/golang-lru"
+)
+
+const (
+	// defaultCleanCache default number of clean nodes cached in memory
+	defaultCleanCache int = 65536
+	// defaultDirtyCache default megabytes of dirty nodes kept in memory
+	defaultDirtyCache int = 64
+	// cachedNodeSize approximate memory used by a cached node besides key&blob
+	cachedNodeSize int = 64
+)
+
+// secureKeyPrefix prefix of the preimages of secure trie keys in database
+var secureKeyPrefix = []byte("secure-key-")
+
+// NodeDatabase is an intermediate write layer between the trie and the disk
+// database,it can be shared by multiple tries. Clean nodes are cached in a
+// LRU cache,dirty nodes of the committed tries are kept in memory with
+// reference counting until they are flushed to the disk database.
+type NodeDatabase struct {
+	diskdb database.Database
+
+	lock       sync.RWMutex
+	cleans     *lru.Cache             // clean nodes cache by database key
+	dirties    map[string]*cachedNode // dirty nodes by database key
+	flushlist  *list.List             // keys of dirty nodes,children before parents
+	dirtySize  int                    // memory used by dirty nodes
+	dirtyLimit int                    // memory limit of dirty nodes
+
+	preimages     map[common.Hash][]byte // preimages of secure trie keys not flushed
+	preimagesSize int                    // memory used by preimages
+}
+
+// cachedNode is a dirty node with the reference information
+type cachedNode struct {
+	blob     []byte        // rlp encoded node
+	children []string      // database key of children
+	parents  int           // number of live references to the node
+	element  *list.Element // element in the flush list
+}
+
+// NewNodeDatabase new a node database on the disk database,the cache limits
+// are loaded from config
+func NewNodeDatabase(diskdb database.Database) *NodeDatabase {
+	cleans := config.Config.TrieCleanCache
+	if cleans <= 0 {
+		cleans = defaultCleanCache
+	}
+	dirties := config.Config.TrieDirtyCache
+	if dirties <= 0 {
+		dirties = defaultDirtyCache
+	}
+	return NewNodeDatabaseWithCache(diskdb, cleans, dirties*1024*1024)
+}
+
+// NewNodeDatabaseWithCache new a node database with the count of clean nodes
+// cache and the bytes limit of dirty nodes
+func NewNodeDatabaseWithCache(diskdb database.Database, cleans int, dirtyLimit int) *NodeDatabase {
+	cache, _ := lru.New(cleans)
+	return &NodeDatabase{
+		diskdb:     diskdb,
+		cleans:     cache,
+		dirties:    make(map[string]*cachedNode),
+		flushlist:  list.New(),
+		dirtyLimit: dirtyLimit,
+		preimages:  make(map[common.Hash][]byte),
+	}
+}
+
+// DiskDB return the disk database of node database
+func (db *NodeDatabase) DiskDB() database.Database {
+	return db.diskdb
+}
+
+// Size return the memory used by dirty nodes
+func (db *NodeDatabase) Size() int {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.dirtySize
+}
+
+// node get node blob from memory cache or disk database
+func (db *NodeDatabase) node(key []byte) ([]byte, error) {
+	if blob, ok := db.cleans.Get(string(key)); ok {
+		return blob.([]byte), nil
+	}
+	db.lock.RLock()
+	dirty := db.dirties[string(key)]
+	db.lock.RUnlock()
+	if dirty != nil {
+		return dirty.blob, nil
+	}
+
+	if db.diskdb == nil {
+		return nil, errNodeNotExist
+	}
+	blob, err := db.diskdb.Get(key)
+	if err != nil || len(blob) == 0 {
+		return nil, errNodeNotExist
+	}
+	db.cleans.Add(string(key), blob)
+	return blob, nil
+}
+
+// insert insert a committed node into dirty nodes,the children of the node
+// must be inserted before
+func (db *NodeDatabase) insert(key []byte, blob []byte, children [][]byte) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.dirties[string(key)]; ok {
+		return
+	}
+	node := &cachedNode{
+		blob: append([]byte{}, blob...),
+	}
+	for _, child := range children {
+		if dirty := db.dirties[string(child)]; dirty != nil {
+			dirty.parents++
+			node.children = append(node.children, string(child))
+		}
+	}
+	node.element = db.flushlist.PushBack(string(key))
+	db.dirties[string(key)] = node
+	db.dirtySize += len(key) + len(blob) + cachedNodeSize
+}
+
+// insertPreimages insert the preimages of secure trie keys
+func (db *NodeDatabase) insertPreimages(preimages map[common.Hash][]byte) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for hash, key := range preimages {
+		if _, ok := db.preimages[hash]; ok {
+			continue
+		}
+		db.preimages[hash] = key
+		db.preimagesSize += common.HashLength + len(key)
+	}
+}
+
+// preimage get the preimage of a secure trie key from memory or disk database
+func (db *NodeDatabase) preimage(hash common.Hash) []byte {
+	db.lock.RLock()
+	key := db.preimages[hash]
+	db.lock.RUnlock()
+	if key != nil {
+		return key
+	}
+
+	if db.diskdb == nil {
+		return nil
+	}
+	key, _ = db.diskdb.Get(secureKey(hash))
+	return key
+}
+
+// Reference add a live reference to the root node of a trie,the dirty nodes
+// of the trie are kept until it is dereferenced
+func (db *NodeDatabase) Reference(prefix []byte, root common.Hash) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if node := db.dirties[string(nodeKey(prefix, root[:]))]; node != nil {
+		node.parents++
+	}
+}
+
+// Dereference remove a live reference to the root node of a trie,the dirty
+// nodes which are not referenced any more are removed from memory
+func (db *NodeDatabase) Dereference(prefix []byte, root common.Hash) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.dereference(string(nodeKey(prefix, root[:])))
+}
+
+func (db *NodeDatabase) dereference(key string) {
+	node := db.dirties[key]
+	if node == nil {
+		return
+	}
+	if node.parents > 0 {
+		node.parents--
+	}
+	if node.parents > 0 {
+		return
+	}
+	for _, child := range node.children {
+		db.dereference(child)
+	}
+	db.remove(key, node)
+}
+
+// Commit write the dirty nodes of trie with root to batch,the nodes are moved
+// to the clean cache. The caller must commit the batch to persist the nodes.
+func (db *NodeDatabase) Commit(prefix []byte, root common.Hash, batch database.Batch) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.commitPreimages(batch)
+	db.commit(string(nodeKey(prefix, root[:])), batch)
+}
+
+// Write write the dirty nodes of trie with root and the preimages to batch,
+// the nodes are kept in memory. The caller must Release the root after the
+// batch is committed.
+func (db *NodeDatabase) Write(prefix []byte, root common.Hash, batch database.Batch) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	for hash, key := range db.preimages {
+		batch.Put(secureKey(hash), key)
+	}
+	db.write(string(nodeKey(prefix, root[:])), batch, make(map[string]struct{}))
+}
+
+func (db *NodeDatabase) write(key string, batch database.Batch, written map[string]struct{}) {
+	node := db.dirties[key]
+	if node == nil {
+		return
+	}
+	if _, ok := written[key]; ok {
+		return
+	}
+	written[key] = struct{}{}
+	for _, child := range node.children {
+		db.write(child, batch, written)
+	}
+	batch.Put([]byte(key), node.blob)
+}
+
+// Release move the dirty nodes of trie with root to the clean cache,they must
+// be persisted by Write already. The preimages are kept until they are
+// flushed by Cap or Commit.
+func (db *NodeDatabase) Release(prefix []byte, root common.Hash) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.release(string(nodeKey(prefix, root[:])))
+}
+
+func (db *NodeDatabase) release(key string) {
+	node := db.dirties[key]
+	if node == nil {
+		return
+	}
+	for _, child := range node.children {
+		db.release(child)
+	}
+	db.remove(key, node)
+	db.cleans.Add(key, node.blob)
+}
+
+// commitPreimages write all preimages to batch
+func (db *NodeDatabase) commitPreimages(batch database.Batch) {
+	for hash, key := range db.preimages {
+		batch.Put(secureKey(hash), key)
+	}
+	db.preimages = make(map[common.Hash][]byte)
+	db.preimagesSize = 0
+}
+
+func (db *NodeDatabase) commit(key string, batch database.Batch) {
+	node := db.dirties[key]
+	if node == nil {
+		return
+	}
+	for _, child := range node.children {
+		db.commit(child, batch)
+	}
+	batch.Put([]byte(key), node.blob)
+	db.remove(key, node)
+	db.cleans.Add(key, node.blob)
+}
+
+// Cap flush the preimages and the oldest dirty nodes to the disk database
+// until the memory used by dirty nodes is under the limit
+func (db *NodeDatabase) Cap() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.dirtySize+db.preimagesSize <= db.dirtyLimit {
+		return nil
+	}
+	batch := db.diskdb.NewBatch()
+	for hash, key := range db.preimages {
+		batch.Put(secureKey(hash), key)
+	}
+	flushed := []string{}
+	size := db.dirtySize
+	for element := db.flushlist.Front(); element != nil && size > db.dirtyLimit; element = element.Next() {
+		key := element.Value.(string)
+		node := db.dirties[key]
+		batch.Put([]byte(key), node.blob)
+		size -= len(key) + len(node.blob) + cachedNodeSize
+		flushed = append(flushed, key)
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	db.preimages = make(map[common.Hash][]byte)
+	db.preimagesSize = 0
+	for _, key := range flushed {
+		node := db.dirties[key]
+		db.remove(key, node)
+		db.cleans.Add(key, node.blob)
+	}
+	return nil
+}
+
+// remove remove the node from dirty nodes
+func (db *NodeDatabase) remove(key string, node *cachedNode) {
+	db.flushlist.Remove(node.element)
+	delete(db.dirties, key)
+	db.dirtySize -= len(key) + len(node.blob) + cachedNodeSize
+}
+
+// nodeKey return the database key of node with hash
+func nodeKey(prefix, hash []byte) []byte {
+	key := make([]byte, len(prefix)+len(hash))
+	copy(key, prefix)
+	copy(key[len(prefix):], hash)
+	return key
+}
+
+// secureKey return the database key of preimage with hash
+func secureKey(hash common.Hash) []byte {
+	return nodeKey(secureKeyPrefix, hash[:])
+}
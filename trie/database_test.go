@@ -0,0 +1,173 @@
+package trie
+
+import (
+	"bytes"
+	"seth/common"
+	"testing"
+)
+
+func Test_NodeDatabase_Share(t *testing.T) {
+	diskdb, remove := newTestTrieDB()
+	defer remove()
+	db := NewNodeDatabase(diskdb)
+
+	trie, _ := NewTrie(common.Hash{}, []byte("trietest"), db)
+	for i, key := range proofTestKeys {
+		trie.Put([]byte(key), []byte{byte(i + 1)})
+	}
+	root, err := trie.Commit(nil)
+	if err != nil {
+		t.Fatalf("Failed to commit trie: %v", err)
+	}
+	if db.Size() == 0 {
+		t.Fatalf("Error: committed nodes should be kept in memory")
+	}
+	if has, _ := diskdb.Has(append([]byte("trietest"), root[:]...)); has {
+		t.Fatalf("Error: committed nodes should not be written to disk")
+	}
+
+	// another trie shares the dirty nodes
+	shared, err := NewTrie(root, []byte("trietest"), db)
+	if err != nil {
+		t.Fatalf("Failed to open trie from node database: %v", err)
+	}
+	for i, key := range proofTestKeys {
+		if value := shared.Get([]byte(key)); !bytes.Equal(value, []byte{byte(i + 1)}) {
+			t.Fatalf("Error value of key %s: %v", key, value)
+		}
+	}
+
+	// flush to disk
+	batch := diskdb.NewBatch()
+	db.Commit([]byte("trietest"), root, batch)
+	batch.Commit()
+	if db.Size() != 0 {
+		t.Fatalf("Error size of dirty nodes after flush: %d", db.Size())
+	}
+	ondisk, err := NewTrie(root, []byte("trietest"), NewNodeDatabase(diskdb))
+	if err != nil {
+		t.Fatalf("Failed to open trie from disk: %v", err)
+	}
+	if value := ondisk.Get([]byte(proofTestKeys[0])); !bytes.Equal(value, []byte{1}) {
+		t.Fatalf("Error value of key %s: %v", proofTestKeys[0], value)
+	}
+}
+
+func Test_NodeDatabase_Dereference(t *testing.T) {
+	diskdb, remove := newTestTrieDB()
+	defer remove()
+	db := NewNodeDatabase(diskdb)
+
+	trie, _ := NewTrie(common.Hash{}, []byte("trietest"), db)
+	for i, key := range proofTestKeys {
+		trie.Put([]byte(key), []byte{byte(i + 1)})
+	}
+	root1, _ := trie.Commit(nil)
+	db.Reference([]byte("trietest"), root1)
+	size1 := db.Size()
+
+	trie.Put([]byte(proofTestKeys[0]), []byte("new"))
+	root2, _ := trie.Commit(nil)
+	db.Reference([]byte("trietest"), root2)
+	size2 := db.Size()
+	if size2 <= size1 {
+		t.Fatalf("Error: new nodes should be added to node database")
+	}
+
+	// the nodes only referenced by root1 are removed,shared nodes are kept
+	db.Dereference([]byte("trietest"), root1)
+	if db.Size() >= size2 {
+		t.Fatalf("Error: size %d after dereference should be less than %d", db.Size(), size2)
+	}
+	if _, err := NewTrie(root1, []byte("trietest"), db); err == nil {
+		t.Fatalf("Error: dereferenced root should be removed")
+	}
+	trie2, err := NewTrie(root2, []byte("trietest"), db)
+	if err != nil {
+		t.Fatalf("Failed to open referenced root: %v", err)
+	}
+	for _, key := range proofTestKeys[1:] {
+		if trie2.Get([]byte(key)) == nil {
+			t.Fatalf("Error: shared node of key %s is removed", key)
+		}
+	}
+
+	db.Dereference([]byte("trietest"), root2)
+	if db.Size() != 0 {
+		t.Fatalf("Error size of dirty nodes after dereference: %d", db.Size())
+	}
+}
+
+func Test_NodeDatabase_Cap(t *testing.T) {
+	diskdb, remove := newTestTrieDB()
+	defer remove()
+	db := NewNodeDatabaseWithCache(diskdb, 16, 256)
+
+	trie, _ := NewTrie(common.Hash{}, []byte("trietest"), db)
+	for i, key := range proofTestKeys {
+		trie.Put([]byte(key), []byte{byte(i + 1)})
+	}
+	root, _ := trie.Commit(nil)
+	if err := db.Cap(); err != nil {
+		t.Fatalf("Failed to cap node database: %v", err)
+	}
+	if db.Size() > 256 {
+		t.Fatalf("Error size of dirty nodes after cap: %d", db.Size())
+	}
+	batch := diskdb.NewBatch()
+	db.Commit([]byte("trietest"), root, batch)
+	batch.Commit()
+
+	ondisk, err := NewTrie(root, []byte("trietest"), NewNodeDatabase(diskdb))
+	if err != nil {
+		t.Fatalf("Failed to open trie from disk: %v", err)
+	}
+	for i, key := range proofTestKeys {
+		if value := ondisk.Get([]byte(key)); !bytes.Equal(value, []byte{byte(i + 1)}) {
+			t.Fatalf("Error value of key %s: %v", key, value)
+		}
+	}
+}
+
+func Test_NodeDatabase_Release(t *testing.T) {
+	diskdb, remove := newTestTrieDB()
+	defer remove()
+	db := NewNodeDatabase(diskdb)
+
+	trie, _ := NewTrie(common.Hash{}, []byte("trietest"), db)
+	for i, key := range proofTestKeys {
+		trie.Put([]byte(key), []byte{byte(i + 1)})
+	}
+	root, _ := trie.Commit(nil)
+	size := db.Size()
+
+	// the nodes are kept in memory if the batch is not committed
+	batch := diskdb.NewBatch()
+	db.Write([]byte("trietest"), root, batch)
+	batch.Rollback()
+	if db.Size() != size {
+		t.Fatalf("Error size of dirty nodes after write: %d,want %d", db.Size(), size)
+	}
+	if _, err := NewTrie(root, []byte("trietest"), db); err != nil {
+		t.Fatalf("Failed to open written trie: %v", err)
+	}
+
+	batch = diskdb.NewBatch()
+	db.Write([]byte("trietest"), root, batch)
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Failed to commit batch: %v", err)
+	}
+	db.Release([]byte("trietest"), root)
+	if db.Size() != 0 {
+		t.Fatalf("Error size of dirty nodes after release: %d", db.Size())
+	}
+	ondisk, err := NewTrie(root, []byte("trietest"), NewNodeDatabase(diskdb))
+	if err != nil {
+		t.Fatalf("Failed to open trie from disk: %v", err)
+	}
+	for i, key := range proofTestKeys {
+		if value := ondisk.Get([]byte(key)); !bytes.Equal(value, []byte{byte(i + 1)}) {
+			t.Fatalf("Error value of key %s: %v", key, value)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package trie
+
+import (
+	"bytes"
+	"seth/common"
+)
+
+// NodeIterator is an iterator to traverse the trie pre-order.
+type NodeIterator interface {
+	// Next moves the iterator to the next node. If the parameter is false,any
+	// child nodes of the current node will be skipped.
+	Next(bool) bool
+	// Error returns the error status of the iterator.
+	Error() error
+	// Hash returns the hash of the current node.
+	Hash() common.Hash
+	// Node returns the current node.
+	Node() Noder
+	// Path returns the hex-encoded path to the current node.
+	Path() []byte
+	// Leaf returns true iff the current node is a leaf node.
+	Leaf() bool
+	// LeafKey returns the key of the leaf. It panics if the iterator is not
+	// positioned at a leaf.
+	LeafKey() []byte
+	// LeafValue returns the value of the leaf. It panics if the iterator is not
+	// positioned at a leaf.
+	LeafValue() []byte
+}
+
+// branchOrder is the order to visit the children of branch node,the value
+// node of the branch(key-end) is shorter than other keys,so visit it first
+var branchOrder = [NumberChildren]int{NumberChildren - 1, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// nodeIteratorState is the iteration state of one node
+type nodeIteratorState struct {
+	node  Noder
+	path  []byte // hex-encoded path to the node
+	index int    // index of the next child to visit
+}
+
+type nodeIterator struct {
+	trie    *Trie
+	start   []byte // hex-encoded start key without terminator
+	stack   []*nodeIteratorState
+	started bool
+	err     error
+}
+
+// NodeIterator returns an iterator that returns nodes of the trie. Iteration
+// starts at the first key >= start,nodes whose subtree only holds keys before
+// start are skipped.
+func (t *Trie) NodeIterator(start []byte) NodeIterator {
+	// make sure the hash of dirty nodes is up to date
+	t.Hash()
+
+	start = keybytesToHex(start)
+	return &nodeIterator{
+		trie:  t,
+		start: start[:len(start)-1],
+	}
+}
+
+// Next moves the iterator to the next node
+func (it *nodeIterator) Next(descend bool) bool {
+	for it.err == nil {
+		state, err := it.step(descend)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if state == nil {
+			return false
+		}
+		if !it.beforeStart(state) {
+			return true
+		}
+		descend = false
+	}
+	return false
+}
+
+// Error returns the error status of the iterator
+func (it *nodeIterator) Error() error {
+	return it.err
+}
+
+// Hash returns the hash of the current node
+func (it *nodeIterator) Hash() common.Hash {
+	if len(it.stack) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(it.stack[len(it.stack)-1].node.Hash())
+}
+
+// Node returns the current node
+func (it *nodeIterator) Node() Noder {
+	if len(it.stack) == 0 {
+		return nil
+	}
+	return it.stack[len(it.stack)-1].node
+}
+
+// Path returns the hex-encoded path to the current node
+func (it *nodeIterator) Path() []byte {
+	if len(it.stack) == 0 {
+		return nil
+	}
+	return it.stack[len(it.stack)-1].path
+}
+
+// Leaf returns true iff the current node is a leaf node
+func (it *nodeIterator) Leaf() bool {
+	_, ok := it.Node().(*LeafNode)
+	return ok
+}
+
+// LeafKey returns the key of the leaf
+func (it *nodeIterator) LeafKey() []byte {
+	leaf := it.Node().(*LeafNode)
+	return hexToKeybytes(concatKey(it.Path(), leaf.Key))
+}
+
+// LeafValue returns the value of the leaf
+func (it *nodeIterator) LeafValue() []byte {
+	return it.Node().(*LeafNode).Value
+}
+
+// step moves to the next node pre-order and resolves it from the database
+func (it *nodeIterator) step(descend bool) (*nodeIteratorState, error) {
+	if !it.started {
+		it.started = true
+		if it.trie.root == nil {
+			return nil, nil
+		}
+		return it.push(it.trie.root, nil)
+	}
+	if !descend && len(it.stack) > 0 {
+		it.stack = it.stack[:len(it.stack)-1]
+	}
+	for len(it.stack) > 0 {
+		parent := it.stack[len(it.stack)-1]
+		if child, path := parent.nextChild(); child != nil {
+			return it.push(child, path)
+		}
+		it.stack = it.stack[:len(it.stack)-1]
+	}
+	return nil, nil
+}
+
+func (it *nodeIterator) push(node Noder, path []byte) (*nodeIteratorState, error) {
+	if hashnode, ok := node.(hashNode); ok {
+		loadnode, err := it.trie.loadNode(hashnode)
+		if err != nil {
+			return nil, err
+		}
+		node = loadnode
+	}
+	state := &nodeIteratorState{
+		node: node,
+		path: path,
+	}
+	it.stack = append(it.stack, state)
+	return state, nil
+}
+
+// beforeStart return true if all keys of the subtree of state are before the
+// start key of the iterator
+func (it *nodeIterator) beforeStart(state *nodeIteratorState) bool {
+	path, complete := state.path, false
+	if leaf, ok := state.node.(*LeafNode); ok {
+		path = concatKey(path, leaf.Key)
+	}
+	if hasTerminator(path) {
+		path, complete = path[:len(path)-1], true
+	}
+	if complete {
+		return bytes.Compare(path, it.start) < 0
+	}
+	if len(path) > len(it.start) {
+		path = path[:len(it.start)]
+	}
+	return bytes.Compare(path, it.start[:len(path)]) < 0
+}
+
+// nextChild return the next child to visit and the path of it
+func (st *nodeIteratorState) nextChild() (Noder, []byte) {
+	switch n := st.node.(type) {
+	case *ExtendNode:
+		if st.index == 0 {
+			st.index++
+			return n.Nextnode, concatKey(st.path, n.Key)
+		}
+	case *BranchNode:
+		for st.index < NumberChildren {
+			i := branchOrder[st.index]
+			st.index++
+			if n.Children[i] != nil {
+				return n.Children[i], concatKey(st.path, []byte{byte(i)})
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Iterator is a key-value trie iterator that traverses a Trie in key order.
+type Iterator struct {
+	nodeIt NodeIterator
+
+	Key   []byte // current key on which the iterator is positioned on
+	Value []byte // current value on which the iterator is positioned on
+	Err   error
+}
+
+// NewIterator creates a new key-value iterator from a node iterator
+func NewIterator(it NodeIterator) *Iterator {
+	return &Iterator{
+		nodeIt: it,
+	}
+}
+
+// Next moves the iterator forward one key-value entry.
+func (it *Iterator) Next() bool {
+	for it.nodeIt.Next(true) {
+		if it.nodeIt.Leaf() {
+			it.Key = it.nodeIt.LeafKey()
+			it.Value = it.nodeIt.LeafValue()
+			return true
+		}
+	}
+	it.Key = nil
+	it.Value = nil
+	it.Err = it.nodeIt.Error()
+	return false
+}
+
+func concatKey(a, b []byte) []byte {
+	key := make([]byte, len(a)+len(b))
+	copy(key, a)
+	copy(key[len(a):], b)
+	return key
+}
+
+func hasTerminator(hex []byte) bool {
+	return len(hex) > 0 && hex[len(hex)-1] == byte(NumberChildren-1)
+}
+
+func hexToKeybytes(hex []byte) []byte {
+	if hasTerminator(hex) {
+		hex = hex[:len(hex)-1]
+	}
+	key := make([]byte, len(hex)/2)
+	for i := range key {
+		key[i] = hex[i*2]*byte(NumberChildren-1) + hex[i*2+1]
+	}
+	return key
+}
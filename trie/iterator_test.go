@@ -0,0 +1,86 @@
+package trie
+
+import (
+	"bytes"
+	"seth/common"
+	"sort"
+	"testing"
+)
+
+func Test_trie_Iterator(t *testing.T) {
+	trie, remove := newProofTestTrie(t)
+	defer remove()
+
+	sorted := append([]string{}, proofTestKeys...)
+	sort.Strings(sorted)
+	values := make(map[string][]byte)
+	for i, key := range proofTestKeys {
+		values[key] = []byte{byte(i + 1)}
+	}
+
+	checkIterator := func(trie *Trie, start string) {
+		want := sorted[sort.SearchStrings(sorted, start):]
+		it := NewIterator(trie.NodeIterator([]byte(start)))
+		got := []string{}
+		for it.Next() {
+			if !bytes.Equal(it.Value, values[string(it.Key)]) {
+				t.Fatalf("Error value of key %s: %v", it.Key, it.Value)
+			}
+			got = append(got, string(it.Key))
+		}
+		if it.Err != nil {
+			t.Fatalf("Failed to iterate trie: %v", it.Err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("Error keys from %q: got %v,want %v", start, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("Error keys from %q: got %v,want %v", start, got, want)
+			}
+		}
+	}
+
+	for _, start := range []string{"", "1", "12345557", "1234556", "24355879", "3"} {
+		checkIterator(trie, start)
+	}
+
+	// iterate the trie with nodes loaded from database
+	batch := trie.db.DiskDB().NewBatch()
+	root, _ := trie.Commit(batch)
+	batch.Commit()
+	trie, _ = NewTrie(root, []byte("trietest"), trie.db)
+	for _, start := range []string{"", "1", "24375878", "243"} {
+		checkIterator(trie, start)
+	}
+}
+
+func Test_trie_NodeIterator(t *testing.T) {
+	trie, remove := newProofTestTrie(t)
+	defer remove()
+	batch := trie.db.DiskDB().NewBatch()
+	root, _ := trie.Commit(batch)
+	batch.Commit()
+
+	trie, _ = NewTrie(root, []byte("trietest"), trie.db)
+	it := trie.NodeIterator(nil)
+	count := 0
+	for it.Next(true) {
+		has, err := trie.db.DiskDB().Has(append([]byte("trietest"), it.Hash().Bytes()...))
+		if err != nil || !has {
+			t.Fatalf("Error node %x not in database", it.Hash())
+		}
+		count++
+	}
+	if it.Error() != nil {
+		t.Fatalf("Failed to iterate trie nodes: %v", it.Error())
+	}
+	if count <= len(proofTestKeys) {
+		t.Fatalf("Error count of nodes: %d", count)
+	}
+
+	empty, _ := NewTrie(common.Hash{}, []byte("trietest"), trie.db)
+	if empty.NodeIterator(nil).Next(true) {
+		t.Fatalf("Error: empty trie should have no nodes")
+	}
+}
@@ -0,0 +1,407 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"seth/common"
+	"seth/crypto/sha3"
+)
+
+var (
+	errProofHashMismatch = errors.New("proof node hash mismatch")
+	errProofIncomplete   = errors.New("proof is missing nodes")
+	errProofUnusedNodes  = errors.New("proof contains unused nodes")
+	errRangeLength       = errors.New("keys and values length mismatch")
+	errRangeOrder        = errors.New("range keys are not sorted")
+	errRangeEmptyValue   = errors.New("range value is empty")
+	errRangeHashMismatch = errors.New("range does not match the root")
+)
+
+// Prove constructs a merkle proof for key. The result contains the rlp encoded
+// nodes on the path from the root to the value of key,the value itself is
+// carried by the last node of the proof.
+//
+// If the trie does not contain key,the proof contains the nodes of the longest
+// existing prefix of key,the last node proves the absence of key.
+func (t *Trie) Prove(key []byte) ([][]byte, error) {
+	// make sure the hash of dirty nodes is up to date
+	t.Hash()
+
+	key = keybytesToHex(key)
+	proof := [][]byte{}
+	node := t.root
+	for node != nil {
+		if hashnode, ok := node.(hashNode); ok {
+			loadnode, err := t.loadNode(hashnode)
+			if err != nil {
+				return nil, err
+			}
+			node = loadnode
+		}
+		buf := new(bytes.Buffer)
+		encodeNode(node, buf)
+		proof = append(proof, buf.Bytes())
+
+		switch n := node.(type) {
+		case *LeafNode:
+			node = nil
+		case *ExtendNode:
+			if len(key) < len(n.Key) || !bytes.Equal(n.Key, key[:len(n.Key)]) {
+				node = nil
+			} else {
+				key = key[len(n.Key):]
+				node = n.Nextnode
+			}
+		case *BranchNode:
+			if len(key) == 0 {
+				node = nil
+			} else {
+				node = n.Children[key[0]]
+				key = key[1:]
+			}
+		}
+	}
+	return proof, nil
+}
+
+// VerifyProof checks the merkle proof generated by Prove against root.
+// It returns the value of key if the proof proves the existence of key,or a
+// nil value with nil error if the proof proves that key is not in the trie.
+func VerifyProof(root common.Hash, key []byte, proof [][]byte) (value []byte, err error) {
+	if len(proof) == 0 {
+		if root == (common.Hash{}) {
+			// empty trie contains nothing
+			return nil, nil
+		}
+		return nil, errProofIncomplete
+	}
+
+	key = keybytesToHex(key)
+	wanted := root[:]
+	sha := sha3.NewKeccak256()
+	for i, buf := range proof {
+		sha.Reset()
+		sha.Write(buf)
+		if !bytes.Equal(sha.Sum(nil), wanted) {
+			return nil, errProofHashMismatch
+		}
+		node, err := decodeNode(wanted, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		var next Noder
+		switch n := node.(type) {
+		case *LeafNode:
+			if bytes.Equal(n.Key, key) {
+				value = n.Value
+			}
+		case *ExtendNode:
+			if len(key) >= len(n.Key) && bytes.Equal(n.Key, key[:len(n.Key)]) {
+				next = n.Nextnode
+				key = key[len(n.Key):]
+			}
+		case *BranchNode:
+			if len(key) > 0 {
+				next = n.Children[key[0]]
+				key = key[1:]
+			}
+		}
+
+		last := i == len(proof)-1
+		if next == nil {
+			if !last {
+				return nil, errProofUnusedNodes
+			}
+			return value, nil
+		}
+		if last {
+			return nil, errProofIncomplete
+		}
+		wanted = next.Hash()
+	}
+	return nil, errProofIncomplete
+}
+
+// ProveRange returns the keys and values in [start,end] of the trie in key
+// order,with the proof of the range. The proof contains the merkle proof of
+// start and the merkle proof of the last returned key.
+//
+// If there is no key in [start,end],the first key after end is returned so
+// that the absence of keys in the range can still be proved.
+func (t *Trie) ProveRange(start, end []byte) (keys, values, proof [][]byte, err error) {
+	it := NewIterator(t.NodeIterator(start))
+	for it.Next() {
+		if bytes.Compare(it.Key, end) > 0 && len(keys) > 0 {
+			break
+		}
+		keys = append(keys, it.Key)
+		values = append(values, it.Value)
+		if bytes.Compare(it.Key, end) > 0 {
+			break
+		}
+	}
+	if it.Err != nil {
+		return nil, nil, nil, it.Err
+	}
+
+	proof, err = t.Prove(start)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(keys) > 0 {
+		lastproof, err := t.Prove(keys[len(keys)-1])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		exists := make(map[string]bool)
+		for _, node := range proof {
+			exists[string(node)] = true
+		}
+		for _, node := range lastproof {
+			if !exists[string(node)] {
+				proof = append(proof, node)
+			}
+		}
+	}
+	return keys, values, proof, nil
+}
+
+// VerifyRangeProof checks that keys and values are exactly all the entries of
+// the trie with root between firstKey and the last key of keys,the proof
+// is generated by ProveRange. An empty proof means keys and values are all
+// the entries of the trie.
+//
+// It returns true if there are more entries after the last key in the trie.
+func VerifyRangeProof(root common.Hash, firstKey []byte, keys, values, proof [][]byte) (bool, error) {
+	if len(keys) != len(values) {
+		return false, errRangeLength
+	}
+	for i, key := range keys {
+		if i == 0 && bytes.Compare(key, firstKey) < 0 {
+			return false, errRangeOrder
+		}
+		if i > 0 && bytes.Compare(keys[i-1], key) >= 0 {
+			return false, errRangeOrder
+		}
+		if len(values[i]) == 0 {
+			return false, errRangeEmptyValue
+		}
+	}
+
+	// the range is the whole trie,just rebuild it
+	if len(proof) == 0 {
+		trie := &Trie{}
+		for i, key := range keys {
+			trie.Put(key, values[i])
+		}
+		if trie.Hash() != root {
+			return false, errRangeHashMismatch
+		}
+		return false, nil
+	}
+
+	nodes := make(map[string][]byte)
+	sha := sha3.NewKeccak256()
+	for _, buf := range proof {
+		sha.Reset()
+		sha.Write(buf)
+		nodes[string(sha.Sum(nil))] = buf
+	}
+	resolve := func(node Noder) (Noder, error) {
+		hashnode, ok := node.(hashNode)
+		if !ok {
+			return node, nil
+		}
+		buf, ok := nodes[string(hashnode)]
+		if !ok {
+			return nil, errProofIncomplete
+		}
+		return decodeNode(append([]byte{}, hashnode...), buf)
+	}
+
+	lastKey := firstKey
+	if len(keys) > 0 {
+		lastKey = keys[len(keys)-1]
+	}
+	left, right := keybytesToHex(firstKey), keybytesToHex(lastKey)
+
+	rootnode, err := resolve(append(hashNode{}, root[:]...))
+	if err != nil {
+		return false, err
+	}
+	hasMore, err := hasRightElement(rootnode, right, resolve)
+	if err != nil {
+		return false, err
+	}
+	// remove everything in the range from the proved trie,then the keys of
+	// the range must rebuild the same trie
+	rootnode, err = unsetRange(rootnode, left, right, resolve)
+	if err != nil {
+		return false, err
+	}
+	trie := &Trie{root: rootnode}
+	for i, key := range keys {
+		if err := trie.Put(key, values[i]); err != nil {
+			return false, err
+		}
+	}
+	if trie.Hash() != root {
+		return false, errRangeHashMismatch
+	}
+	return hasMore, nil
+}
+
+// unsetRange removes all the entries between the hex-encoded key left and
+// right from the subtree of node,left and right are relative to node and a
+// nil bound means the range is unbounded on the side. It returns the new
+// node,nil if the whole subtree is in the range.
+func unsetRange(node Noder, left, right []byte, resolve func(Noder) (Noder, error)) (Noder, error) {
+	if left == nil && right == nil {
+		return nil, nil
+	}
+	node, err := resolve(node)
+	if err != nil {
+		return nil, err
+	}
+	switch n := node.(type) {
+	case *LeafNode:
+		if (left == nil || compareHex(n.Key, left) >= 0) && (right == nil || compareHex(n.Key, right) <= 0) {
+			return nil, nil
+		}
+		return n, nil
+	case *ExtendNode:
+		var nextleft, nextright []byte
+		if left != nil {
+			switch compareHex(n.Key, prefixHex(left, len(n.Key))) {
+			case -1:
+				return n, nil
+			case 0:
+				nextleft = left[len(n.Key):]
+			}
+		}
+		if right != nil {
+			switch compareHex(n.Key, prefixHex(right, len(n.Key))) {
+			case 1:
+				return n, nil
+			case 0:
+				nextright = right[len(n.Key):]
+			}
+		}
+		next, err := unsetRange(n.Nextnode, nextleft, nextright, resolve)
+		if err != nil || next == nil {
+			return nil, err
+		}
+		n.Nextnode = next
+		n.dirty = true
+		return n, nil
+	case *BranchNode:
+		if (left != nil && len(left) == 0) || (right != nil && len(right) == 0) {
+			return nil, errNodeFormat
+		}
+		count := 0
+		for i, child := range n.Children {
+			if child == nil {
+				continue
+			}
+			var childleft, childright []byte
+			keep := false
+			if left != nil {
+				switch compareHex([]byte{byte(i)}, left[:1]) {
+				case -1:
+					keep = true
+				case 0:
+					childleft = left[1:]
+				}
+			}
+			if right != nil {
+				switch compareHex([]byte{byte(i)}, right[:1]) {
+				case 1:
+					keep = true
+				case 0:
+					childright = right[1:]
+				}
+			}
+			if !keep {
+				child, err = unsetRange(child, childleft, childright, resolve)
+				if err != nil {
+					return nil, err
+				}
+				n.Children[i] = child
+				n.dirty = true
+			}
+			if child != nil {
+				count++
+			}
+		}
+		if count == 0 {
+			return nil, nil
+		}
+		return n, nil
+	default:
+		return nil, errNodeFormat
+	}
+}
+
+// hasRightElement return true if there is any entry after the hex-encoded
+// key in the subtree of node
+func hasRightElement(node Noder, key []byte, resolve func(Noder) (Noder, error)) (bool, error) {
+	for node != nil {
+		resolved, err := resolve(node)
+		if err != nil {
+			return false, err
+		}
+		switch n := resolved.(type) {
+		case *LeafNode:
+			return compareHex(n.Key, key) > 0, nil
+		case *ExtendNode:
+			if c := compareHex(n.Key, prefixHex(key, len(n.Key))); c != 0 {
+				return c > 0, nil
+			}
+			key = key[len(n.Key):]
+			node = n.Nextnode
+		case *BranchNode:
+			if len(key) == 0 {
+				return false, errNodeFormat
+			}
+			for i, child := range n.Children {
+				if child != nil && compareHex([]byte{byte(i)}, key[:1]) > 0 {
+					return true, nil
+				}
+			}
+			node = n.Children[key[0]]
+			key = key[1:]
+		default:
+			return false, errNodeFormat
+		}
+	}
+	return false, nil
+}
+
+// compareHex compare two hex-encoded keys in key order,the terminator means
+// the end of key so it is before any other nibble
+func compareHex(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		if a[i] == byte(NumberChildren-1) || (b[i] != byte(NumberChildren-1) && a[i] < b[i]) {
+			return -1
+		}
+		return 1
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+func prefixHex(key []byte, length int) []byte {
+	if len(key) > length {
+		return key[:length]
+	}
+	return key
+}
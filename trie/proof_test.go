@@ -0,0 +1,190 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"seth/common"
+	"sort"
+	"testing"
+)
+
+var proofTestKeys = []string{
+	"12345678", "12345557", "12375879", "02375879", "04375879",
+	"24375879", "24375878", "24355879", "243558790", "2",
+}
+
+func newProofTestTrie(t *testing.T) (*Trie, func()) {
+	db, remove := newTestTrieDB()
+	trie, err := NewTrie(common.Hash{}, []byte("trietest"), NewNodeDatabase(db))
+	if err != nil {
+		remove()
+		t.Fatalf("Failed to new trie: %v", err)
+	}
+	for i, key := range proofTestKeys {
+		trie.Put([]byte(key), []byte{byte(i + 1)})
+	}
+	return trie, remove
+}
+
+func Test_trie_Prove(t *testing.T) {
+	trie, remove := newProofTestTrie(t)
+	defer remove()
+	root := trie.Hash()
+
+	for i, key := range proofTestKeys {
+		proof, err := trie.Prove([]byte(key))
+		if err != nil {
+			t.Fatalf("Failed to prove key %s: %v", key, err)
+		}
+		value, err := VerifyProof(root, []byte(key), proof)
+		if err != nil {
+			t.Fatalf("Failed to verify proof of key %s: %v", key, err)
+		}
+		if !bytes.Equal(value, []byte{byte(i + 1)}) {
+			t.Fatalf("Error value of key %s: %v", key, value)
+		}
+	}
+}
+
+func Test_trie_ProveAbsence(t *testing.T) {
+	trie, remove := newProofTestTrie(t)
+	defer remove()
+	batch := trie.db.DiskDB().NewBatch()
+	root, _ := trie.Commit(batch)
+	batch.Commit()
+
+	// prove with nodes loaded from database
+	trie, _ = NewTrie(root, []byte("trietest"), trie.db)
+	for _, key := range []string{"1234567", "123456789", "0", "3", "24355870", ""} {
+		proof, err := trie.Prove([]byte(key))
+		if err != nil {
+			t.Fatalf("Failed to prove key %s: %v", key, err)
+		}
+		value, err := VerifyProof(root, []byte(key), proof)
+		if err != nil {
+			t.Fatalf("Failed to verify proof of key %s: %v", key, err)
+		}
+		if value != nil {
+			t.Fatalf("Error value of absent key %s: %v", key, value)
+		}
+	}
+}
+
+func Test_trie_ProveInvalid(t *testing.T) {
+	trie, remove := newProofTestTrie(t)
+	defer remove()
+	root := trie.Hash()
+	key := []byte(proofTestKeys[0])
+
+	proof, _ := trie.Prove(key)
+	if _, err := VerifyProof(root, key, proof[:len(proof)-1]); err == nil {
+		t.Fatalf("Error: incomplete proof should be rejected")
+	}
+	if _, err := VerifyProof(common.Hash{1}, key, proof); err == nil {
+		t.Fatalf("Error: proof with wrong root should be rejected")
+	}
+	last := proof[len(proof)-1]
+	proof[len(proof)-1] = append(append([]byte{}, last[:len(last)-1]...), last[len(last)-1]+1)
+	if _, err := VerifyProof(root, key, proof); err == nil {
+		t.Fatalf("Error: modified proof should be rejected")
+	}
+}
+
+func newRangeTestTrie(t *testing.T, count int) (*Trie, []string) {
+	trie, err := NewTrie(common.Hash{}, []byte("trietest"), nil)
+	if err != nil {
+		t.Fatalf("Failed to new trie: %v", err)
+	}
+	random := rand.New(rand.NewSource(1))
+	keys := []string{}
+	for len(keys) < count {
+		key := fmt.Sprintf("%x", random.Int63n(1<<20))
+		if trie.Get([]byte(key)) != nil {
+			continue
+		}
+		trie.Put([]byte(key), []byte("value-"+key))
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return trie, keys
+}
+
+func Test_trie_ProveRange(t *testing.T) {
+	trie, keys := newRangeTestTrie(t, 200)
+	root := trie.Hash()
+
+	ranges := [][2]string{
+		{keys[0], keys[len(keys)-1]},
+		{keys[10], keys[20]},
+		{"", keys[5]},
+		{keys[100] + "0", keys[150]},
+		{keys[199], "z"},
+		{keys[50] + "0", keys[50] + "1"},
+		{"z", "zz"},
+	}
+	for _, r := range ranges {
+		rangekeys, values, proof, err := trie.ProveRange([]byte(r[0]), []byte(r[1]))
+		if err != nil {
+			t.Fatalf("Failed to prove range %v: %v", r, err)
+		}
+		hasMore, err := VerifyRangeProof(root, []byte(r[0]), rangekeys, values, proof)
+		if err != nil {
+			t.Fatalf("Failed to verify range %v: %v", r, err)
+		}
+		last := r[0]
+		if len(rangekeys) > 0 {
+			last = string(rangekeys[len(rangekeys)-1])
+		}
+		if expected := last < keys[len(keys)-1]; hasMore != expected {
+			t.Fatalf("Error has more of range %v: %v", r, hasMore)
+		}
+	}
+
+	// the whole trie without proof
+	all := make([][]byte, len(keys))
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		all[i] = []byte(key)
+		values[i] = []byte("value-" + key)
+	}
+	if _, err := VerifyRangeProof(root, nil, all, values, nil); err != nil {
+		t.Fatalf("Failed to verify the whole trie: %v", err)
+	}
+}
+
+func Test_trie_ProveRangeInvalid(t *testing.T) {
+	trie, keys := newRangeTestTrie(t, 200)
+	root := trie.Hash()
+	rangekeys, values, proof, err := trie.ProveRange([]byte(keys[10]), []byte(keys[40]))
+	if err != nil {
+		t.Fatalf("Failed to prove range: %v", err)
+	}
+
+	// missing key in the middle
+	missingkeys := append(append([][]byte{}, rangekeys[:5]...), rangekeys[6:]...)
+	missingvalues := append(append([][]byte{}, values[:5]...), values[6:]...)
+	if _, err := VerifyRangeProof(root, rangekeys[0], missingkeys, missingvalues, proof); err == nil {
+		t.Fatalf("Error: range with missing key should be rejected")
+	}
+	// missing first key
+	if _, err := VerifyRangeProof(root, rangekeys[0], rangekeys[1:], values[1:], proof); err == nil {
+		t.Fatalf("Error: range with missing first key should be rejected")
+	}
+	// modified value
+	modified := append([][]byte{}, values...)
+	modified[3] = []byte("modified")
+	if _, err := VerifyRangeProof(root, rangekeys[0], rangekeys, modified, proof); err == nil {
+		t.Fatalf("Error: range with modified value should be rejected")
+	}
+	// unsorted keys
+	unsorted := append([][]byte{}, rangekeys...)
+	unsorted[1], unsorted[2] = unsorted[2], unsorted[1]
+	if _, err := VerifyRangeProof(root, rangekeys[0], unsorted, values, proof); err == nil {
+		t.Fatalf("Error: range with unsorted keys should be rejected")
+	}
+	// incomplete proof
+	if _, err := VerifyRangeProof(root, rangekeys[0], rangekeys, values, proof[:len(proof)-1]); err == nil {
+		t.Fatalf("Error: range with incomplete proof should be rejected")
+	}
+}
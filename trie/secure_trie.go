@@ -0,0 +1,101 @@
+package trie
+
+import (
+	"seth/common"
+	"seth/crypto/sha3"
+	"seth/database"
+)
+
+// SecureTrie wraps a trie with the keys hashed by keccak256,so that the trie
+// can not be unbalanced by crafted keys. The preimages of the hashed keys are
+// stored in the node database,use GetKey to get the original key.
+type SecureTrie struct {
+	trie        Trie
+	secKeyCache map[common.Hash][]byte // preimages of keys not committed
+}
+
+// NewSecureTrie new a secure trie,the node database must not be nil
+func NewSecureTrie(root common.Hash, prefix []byte, db *NodeDatabase) (*SecureTrie, error) {
+	if db == nil {
+		return nil, errNilDatabase
+	}
+	trie, err := NewTrie(root, prefix, db)
+	if err != nil {
+		return nil, err
+	}
+	return &SecureTrie{
+		trie:        *trie,
+		secKeyCache: make(map[common.Hash][]byte),
+	}, nil
+}
+
+// Put put [key,value] in the trie with hashed key
+func (t *SecureTrie) Put(key, value []byte) error {
+	hk := t.hashKey(key)
+	if err := t.trie.Put(hk[:], value); err != nil {
+		return err
+	}
+	t.secKeyCache[hk] = append([]byte{}, key...)
+	return nil
+}
+
+// Delete delete node with hashed key in the trie
+func (t *SecureTrie) Delete(key []byte) bool {
+	hk := t.hashKey(key)
+	delete(t.secKeyCache, hk)
+	return t.trie.Delete(hk[:])
+}
+
+// Get get the value by hashed key
+func (t *SecureTrie) Get(key []byte) []byte {
+	hk := t.hashKey(key)
+	return t.trie.Get(hk[:])
+}
+
+// GetKey return the preimage of a hashed key
+func (t *SecureTrie) GetKey(shaKey []byte) []byte {
+	if key, ok := t.secKeyCache[common.BytesToHash(shaKey)]; ok {
+		return key
+	}
+	return t.trie.db.preimage(common.BytesToHash(shaKey))
+}
+
+// Hash return the hash of trie
+func (t *SecureTrie) Hash() common.Hash {
+	return t.trie.Hash()
+}
+
+// Commit commit the dirty nodes and the preimages of keys to node database,
+// they are flushed to batch if batch is not nil
+func (t *SecureTrie) Commit(batch database.Batch) (common.Hash, error) {
+	if len(t.secKeyCache) > 0 {
+		t.trie.db.insertPreimages(t.secKeyCache)
+		t.secKeyCache = make(map[common.Hash][]byte)
+	}
+	return t.trie.Commit(batch)
+}
+
+// NodeIterator returns an iterator that returns nodes of the trie,the leaf
+// keys are hashed keys
+func (t *SecureTrie) NodeIterator(start []byte) NodeIterator {
+	return t.trie.NodeIterator(start)
+}
+
+// Copy return a copy of secure trie
+func (t *SecureTrie) Copy() *SecureTrie {
+	cpy := &SecureTrie{
+		trie:        *t.trie.Copy(),
+		secKeyCache: make(map[common.Hash][]byte, len(t.secKeyCache)),
+	}
+	for hash, key := range t.secKeyCache {
+		cpy.secKeyCache[hash] = key
+	}
+	return cpy
+}
+
+func (t *SecureTrie) hashKey(key []byte) (hk common.Hash) {
+	sha := sha3.NewKeccak256()
+	sha.Write(key)
+	sha.Sum(hk[:0])
+	return hk
+}
@@ -0,0 +1,92 @@
+package trie
+
+import (
+	"bytes"
+	"seth/common"
+	"testing"
+)
+
+func Test_SecureTrie_Operate(t *testing.T) {
+	diskdb, remove := newTestTrieDB()
+	defer remove()
+	db := NewNodeDatabase(diskdb)
+
+	trie, err := NewSecureTrie(common.Hash{}, []byte("trietest"), db)
+	if err != nil {
+		t.Fatalf("Failed to new secure trie: %v", err)
+	}
+	for i, key := range proofTestKeys {
+		trie.Put([]byte(key), []byte{byte(i + 1)})
+	}
+	trie.Delete([]byte(proofTestKeys[0]))
+	batch := diskdb.NewBatch()
+	root, err := trie.Commit(batch)
+	if err != nil {
+		t.Fatalf("Failed to commit secure trie: %v", err)
+	}
+	batch.Commit()
+
+	// the keys are hashed in the underlying trie
+	trie, _ = NewSecureTrie(root, []byte("trietest"), NewNodeDatabase(diskdb))
+	plain, _ := NewTrie(root, []byte("trietest"), NewNodeDatabase(diskdb))
+	if plain.Get([]byte(proofTestKeys[1])) != nil {
+		t.Fatalf("Error: key should be hashed")
+	}
+	if trie.Get([]byte(proofTestKeys[0])) != nil {
+		t.Fatalf("Error: deleted key %s exists", proofTestKeys[0])
+	}
+	for i, key := range proofTestKeys[1:] {
+		if value := trie.Get([]byte(key)); !bytes.Equal(value, []byte{byte(i + 2)}) {
+			t.Fatalf("Error value of key %s: %v", key, value)
+		}
+	}
+
+	// iterator returns hashed keys,the original keys are got from preimages
+	count := 0
+	it := NewIterator(trie.NodeIterator(nil))
+	for it.Next() {
+		key := trie.GetKey(it.Key)
+		if key == nil {
+			t.Fatalf("Error: preimage of %x not found", it.Key)
+		}
+		if value := trie.Get(key); !bytes.Equal(value, it.Value) {
+			t.Fatalf("Error value of key %s: %v", key, value)
+		}
+		count++
+	}
+	if count != len(proofTestKeys)-1 {
+		t.Fatalf("Error count of keys: %d", count)
+	}
+}
+
+func Test_SecureTrie_Preimage(t *testing.T) {
+	diskdb, remove := newTestTrieDB()
+	defer remove()
+	db := NewNodeDatabase(diskdb)
+
+	trie, _ := NewSecureTrie(common.Hash{}, []byte("trietest"), db)
+	trie.Put([]byte("key"), []byte("value"))
+	hk := trie.hashKey([]byte("key"))
+	if key := trie.GetKey(hk[:]); !bytes.Equal(key, []byte("key")) {
+		t.Fatalf("Error preimage of uncommitted key: %s", key)
+	}
+
+	// committed preimages are kept in memory until flushed
+	root, _ := trie.Commit(nil)
+	if key := db.preimage(hk); !bytes.Equal(key, []byte("key")) {
+		t.Fatalf("Error preimage in node database: %s", key)
+	}
+	if has, _ := diskdb.Has(secureKey(hk)); has {
+		t.Fatalf("Error: preimage should not be written to disk")
+	}
+	batch := diskdb.NewBatch()
+	db.Commit([]byte("trietest"), root, batch)
+	batch.Commit()
+	if key := NewNodeDatabase(diskdb).preimage(hk); !bytes.Equal(key, []byte("key")) {
+		t.Fatalf("Error preimage on disk: %s", key)
+	}
+
+	if _, err := NewSecureTrie(common.Hash{}, []byte("trietest"), nil); err == nil {
+		t.Fatalf("Error: secure trie should not be created without node database")
+	}
+}
@@ -15,17 +15,18 @@ import (
 var (
 	errNodeFormat   = errors.New("node format is invalid")
 	errNodeNotExist = errors.New("node not exist in db")
+	errNilDatabase  = errors.New("node database is nil")
 )
 
 //Trie is a Merkle Patricia Trie
 type Trie struct {
-	db     database.Database
+	db     *NodeDatabase
 	root   Noder  // root node of the Trie
 	prefix []byte //prefix of Trie node
 }
 
 // NewTrie new a trie tree
-func NewTrie(root common.Hash, prefix []byte, db database.Database) (*Trie, error) {
+func NewTrie(root common.Hash, prefix []byte, db *NodeDatabase) (*Trie, error) {
 
 	trie := &Trie{
 		db:     db,
@@ -90,18 +91,64 @@ func (t *Trie) Hash() common.Hash {
 	return common.Hash{}
 }
 
-// Commit commit the dirty node to database
+// Commit commit the dirty node to node database,the nodes are flushed to
+// batch if batch is not nil,otherwise they are kept in memory of node database
 func (t *Trie) Commit(batch database.Batch) (common.Hash, error) {
 	if t.root != nil {
 		buf := new(bytes.Buffer)
 		sha := sha3.NewKeccak256()
-		t.hash(t.root, buf, sha, batch)
-		return common.BytesToHash(t.root.Hash()), nil
+		t.hash(t.root, buf, sha, t.db)
+		root := common.BytesToHash(t.root.Hash())
+		if batch != nil && t.db != nil {
+			t.db.Commit(t.prefix, root, batch)
+		}
+		return root, nil
 	}
 	return common.Hash{}, nil
 }
 
-func (t *Trie) hash(node Noder, buf *bytes.Buffer, sha hash.Hash, batch database.Batch) []byte {
+// Copy return a copy of trie,the nodes in memory are copied so that the copy
+// can be modified independently
+func (t *Trie) Copy() *Trie {
+	return &Trie{
+		db:     t.db,
+		root:   copyNode(t.root),
+		prefix: t.prefix,
+	}
+}
+
+// copyNode deep copy the node in memory,hash node is never modified so it is
+// shared by the copies
+func copyNode(node Noder) Noder {
+	switch n := node.(type) {
+	case *LeafNode:
+		cpy := *n
+		cpy.hash = copyHash(n.hash)
+		return &cpy
+	case *ExtendNode:
+		cpy := *n
+		cpy.hash = copyHash(n.hash)
+		cpy.Nextnode = copyNode(n.Nextnode)
+		return &cpy
+	case *BranchNode:
+		cpy := *n
+		cpy.hash = copyHash(n.hash)
+		for i, child := range n.Children {
+			cpy.Children[i] = copyNode(child)
+		}
+		return &cpy
+	default:
+		return node
+	}
+}
+
+func copyHash(hash []byte) []byte {
+	cpy := make([]byte, LengthOfNodeHash)
+	copy(cpy, hash)
+	return cpy
+}
+
+func (t *Trie) hash(node Noder, buf *bytes.Buffer, sha hash.Hash, db *NodeDatabase) []byte {
 
 	if node == nil {
 		return nil
@@ -111,58 +158,73 @@ func (t *Trie) hash(node Noder, buf *bytes.Buffer, sha hash.Hash, batch database
 	}
 	switch n := node.(type) {
 	case *LeafNode:
-		buf.Reset()
+		return t.store(&n.Node, n, buf, sha, db)
+	case *ExtendNode:
+		t.hash(n.Nextnode, buf, sha, db)
+		return t.store(&n.Node, n, buf, sha, db)
+	case *BranchNode:
+		for _, child := range n.Children {
+			t.hash(child, buf, sha, db)
+		}
+		return t.store(&n.Node, n, buf, sha, db)
+	case hashNode:
+		return n.Hash()
+	default:
+		panic(fmt.Sprintf("invalid node: %v", node))
+	}
+}
+
+// store encode the node whose children are hashed already,update the hash of
+// the node and insert it to node database if db is not nil
+func (t *Trie) store(n *Node, node Noder, buf *bytes.Buffer, sha hash.Hash, db *NodeDatabase) []byte {
+	buf.Reset()
+	encodeNode(node, buf)
+	sha.Reset()
+	sha.Write(buf.Bytes())
+	hash := sha.Sum(nil)
+	if db != nil {
+		var children [][]byte
+		switch n := node.(type) {
+		case *ExtendNode:
+			children = append(children, nodeKey(t.prefix, n.Nextnode.Hash()))
+		case *BranchNode:
+			for _, child := range n.Children {
+				if child != nil {
+					children = append(children, nodeKey(t.prefix, child.Hash()))
+				}
+			}
+		}
+		db.insert(nodeKey(t.prefix, hash), buf.Bytes(), children)
+		n.dirty = false
+	}
+	copy(n.hash, hash)
+	return n.hash
+}
+
+// encodeNode rlp encode the node with the hash of it's children
+func encodeNode(node Noder, buf *bytes.Buffer) {
+	switch n := node.(type) {
+	case *LeafNode:
 		rlp.Encode(buf, []interface{}{
 			n.Key,
 			n.Value,
 		})
-		sha.Reset()
-		sha.Write(buf.Bytes())
-		hash := sha.Sum(nil)
-		if batch != nil {
-			batch.Put(append(t.prefix, hash...), buf.Bytes())
-			n.dirty = false
-		}
-		copy(n.hash, hash)
-		return n.hash
 	case *ExtendNode:
-		nexthash := t.hash(n.Nextnode, buf, sha, batch)
-		buf.Reset()
 		rlp.Encode(buf, []interface{}{
 			true, //add it to diff with extend node;modify later using compact func?
 			n.Key,
-			nexthash,
+			n.Nextnode.Hash(),
 		})
-		sha.Reset()
-		sha.Write(buf.Bytes())
-		hash := sha.Sum(nil)
-		if batch != nil {
-			batch.Put(append(t.prefix, hash...), buf.Bytes())
-			n.dirty = false
-		}
-		copy(n.hash, hash)
-		return n.hash
 	case *BranchNode:
 		var children [NumberChildren][]byte
 		for i, child := range n.Children {
-			children[i] = t.hash(child, buf, sha, batch)
+			if child != nil {
+				children[i] = child.Hash()
+			}
 		}
-		buf.Reset()
 		rlp.Encode(buf, []interface{}{
 			children,
 		})
-
-		sha.Reset()
-		sha.Write(buf.Bytes())
-		hash := sha.Sum(nil)
-		if batch != nil {
-			batch.Put(append(t.prefix, hash...), buf.Bytes())
-			n.dirty = false
-		}
-		copy(n.hash, hash)
-		return n.hash
-	case hashNode:
-		return n.Hash()
 	default:
 		panic(fmt.Sprintf("invalid node: %v", node))
 	}
@@ -287,11 +349,31 @@ func (t *Trie) delete(node Noder, key []byte) (bool, Noder, error) {
 		if matchlen == len(n.Key) {
 			match, newnode, err := t.delete(n.Nextnode, key[matchlen:])
 			if err == nil && match {
-				n.dirty = true
-				n.Nextnode = newnode
-				if newnode == nil {
+				// merge the shared nibbles if the child branch collapsed
+				switch newnode := newnode.(type) {
+				case nil:
 					return true, nil, nil
+				case *LeafNode:
+					return true, &LeafNode{
+						Node: Node{
+							dirty: true,
+							hash:  make([]byte, LengthOfNodeHash),
+						},
+						Key:   concatKey(n.Key, newnode.Key),
+						Value: newnode.Value,
+					}, nil
+				case *ExtendNode:
+					return true, &ExtendNode{
+						Node: Node{
+							dirty: true,
+							hash:  make([]byte, LengthOfNodeHash),
+						},
+						Key:      concatKey(n.Key, newnode.Key),
+						Nextnode: newnode.Nextnode,
+					}, nil
 				}
+				n.dirty = true
+				n.Nextnode = newnode
 				return true, n, nil
 			}
 		}
@@ -343,6 +425,16 @@ func (t *Trie) delete(node Noder, key []byte) (bool, Noder, error) {
 					Nextnode: childnode.Nextnode,
 				}
 				return true, newnode, nil
+			case *BranchNode:
+				newnode := &ExtendNode{
+					Node: Node{
+						dirty: true,
+						hash:  make([]byte, LengthOfNodeHash),
+					},
+					Key:      []byte{byte(pos)},
+					Nextnode: childnode,
+				}
+				return true, newnode, nil
 			}
 		}
 		return match, n, nil
@@ -363,19 +455,20 @@ func (t *Trie) delete(node Noder, key []byte) (bool, Noder, error) {
 	}
 }
 
-// loadNode get node from memory cache or database
+// loadNode get node from node database
 func (t *Trie) loadNode(hash []byte) (Noder, error) {
-	//TODO need cache nodes
-	key := append(t.prefix, hash...)
-	val, err := t.db.Get(key)
-	if err != nil || len(val) == 0 {
+	if t.db == nil {
 		return nil, errNodeNotExist
 	}
-	return t.decodeNode(hash, val)
+	val, err := t.db.node(nodeKey(t.prefix, hash))
+	if err != nil {
+		return nil, err
+	}
+	return decodeNode(hash, val)
 }
 
 // decodeNode decode node from buf byte
-func (t *Trie) decodeNode(hash, value []byte) (Noder, error) {
+func decodeNode(hash, value []byte) (Noder, error) {
 	if len(value) == 0 {
 		return nil, io.ErrUnexpectedEOF
 	}
@@ -383,19 +476,21 @@ func (t *Trie) decodeNode(hash, value []byte) (Noder, error) {
 	if err != nil {
 		return nil, err
 	}
+	// the hash of node is updated in place when it is modified
+	hash = copyHash(hash)
 	switch n, _ := rlp.CountValues(vals); n {
 	case 1:
-		return t.decodeBranchNode(hash, vals)
+		return decodeBranchNode(hash, vals)
 	case 2:
-		return t.decodeLeafNode(hash, vals)
+		return decodeLeafNode(hash, vals)
 	case 3:
-		return t.decodeExtendNode(hash, vals)
+		return decodeExtendNode(hash, vals)
 	default:
-		return nil, nil
+		return nil, errNodeFormat
 	}
 }
 
-func (t *Trie) decodeLeafNode(hash, values []byte) (Noder, error) {
+func decodeLeafNode(hash, values []byte) (Noder, error) {
 	key, rest, err := rlp.SplitString(values)
 	if err != nil {
 		return nil, err
@@ -414,7 +509,7 @@ func (t *Trie) decodeLeafNode(hash, values []byte) (Noder, error) {
 	}, nil
 }
 
-func (t *Trie) decodeExtendNode(hash, values []byte) (Noder, error) {
+func decodeExtendNode(hash, values []byte) (Noder, error) {
 	_, bufs, err := rlp.SplitString(values)
 	key, rest, err := rlp.SplitString(bufs)
 	if err != nil {
@@ -434,7 +529,7 @@ func (t *Trie) decodeExtendNode(hash, values []byte) (Noder, error) {
 	}, nil
 }
 
-func (t *Trie) decodeBranchNode(hash, values []byte) (Noder, error) {
+func decodeBranchNode(hash, values []byte) (Noder, error) {
 
 	kind, elems, _, err := rlp.Split(values)
 	if err != nil {
@@ -3,6 +3,7 @@ package trie
 import (
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"seth/common"
 	"seth/database"
@@ -28,7 +29,7 @@ func newTestTrieDB() (database.Database, func()) {
 func Test_trie_Update(t *testing.T) {
 	db, remove := newTestTrieDB()
 	defer remove()
-	trie, err := NewTrie(common.Hash{}, []byte("trietest"), db)
+	trie, err := NewTrie(common.Hash{}, []byte("trietest"), NewNodeDatabase(db))
 	if err != nil {
 		panic(err)
 	}
@@ -68,7 +69,7 @@ func Test_trie_Update(t *testing.T) {
 func Test_trie_Delete(t *testing.T) {
 	db, remove := newTestTrieDB()
 	defer remove()
-	trie, err := NewTrie(common.Hash{}, []byte("trietest"), db)
+	trie, err := NewTrie(common.Hash{}, []byte("trietest"), NewNodeDatabase(db))
 	if err != nil {
 		panic(err)
 	}
@@ -109,7 +110,7 @@ func Test_trie_Delete(t *testing.T) {
 func Test_trie_Commit(t *testing.T) {
 	db, remove := newTestTrieDB()
 	defer remove()
-	trie, err := NewTrie(common.Hash{}, []byte("trietest"), db)
+	trie, err := NewTrie(common.Hash{}, []byte("trietest"), NewNodeDatabase(db))
 	if err != nil {
 		panic(err)
 	}
@@ -128,7 +129,7 @@ func Test_trie_Commit(t *testing.T) {
 	fmt.Println(hash)
 
 	fmt.Println(string("----------------------------------"))
-	trienew, err := NewTrie(hash, []byte("trietest"), db)
+	trienew, err := NewTrie(hash, []byte("trietest"), NewNodeDatabase(db))
 
 	trienew.Delete([]byte("24355879"))
 	trienew.Put([]byte("243558790"), []byte("test8"))
@@ -157,3 +158,32 @@ func Test_trie_Commit(t *testing.T) {
 	value = trienew.Get([]byte("12375879"))
 	fmt.Println(string(value))
 }
+
+func Test_trie_DeleteCanonical(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for round := 0; round < 100; round++ {
+		trie, _ := NewTrie(common.Hash{}, nil, nil)
+		live := make(map[string]bool)
+		for i := 0; i < 200; i++ {
+			key := make([]byte, 1+random.Intn(3))
+			for j := range key {
+				key[j] = byte(random.Intn(4))
+			}
+			if random.Intn(3) == 0 {
+				trie.Delete(key)
+				delete(live, string(key))
+			} else {
+				trie.Put(key, []byte("test"))
+				live[string(key)] = true
+			}
+		}
+		// the trie must have the same shape as one built by insertion only
+		expected, _ := NewTrie(common.Hash{}, nil, nil)
+		for key := range live {
+			expected.Put([]byte(key), []byte("test"))
+		}
+		if trie.Hash() != expected.Hash() {
+			t.Fatalf("Error trie hash after delete in round %d", round)
+		}
+	}
+}